@@ -0,0 +1,166 @@
+package build
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// archiveEntry is a file to add to an archive under Name,
+// which uses forward slashes.
+type archiveEntry struct {
+	Name string
+	Path string
+}
+
+// writeTarGz writes the entries into a gzipped tar archive at filename.
+// If modTime is not zero it is recorded as the modification time of every entry.
+func writeTarGz(filename string, entries []archiveEntry, modTime time.Time) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	for _, dir := range archiveDirs(entries) {
+		header := &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir + "/",
+			Mode:     0755,
+			ModTime:  modTime,
+		}
+		if modTime.IsZero() {
+			header.ModTime = time.Now()
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range entries {
+		if err = addTarEntry(tw, e, modTime); err != nil {
+			return fmt.Errorf("could not add %q to %q: %s", e.Path, filename, err)
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func addTarEntry(tw *tar.Writer, e archiveEntry, modTime time.Time) error {
+	file, err := os.Open(e.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = e.Name
+	header.Uname, header.Gname = "", ""
+	header.Uid, header.Gid = 0, 0
+	if !modTime.IsZero() {
+		header.ModTime = modTime
+	}
+
+	if err = tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// writeZipArchive writes the entries into a zip archive at filename.
+// If modTime is not zero it is recorded as the modification time of every entry.
+func writeZipArchive(filename string, entries []archiveEntry, modTime time.Time) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	for _, e := range entries {
+		if err = addZipEntry(zw, e, modTime); err != nil {
+			return fmt.Errorf("could not add %q to %q: %s", e.Path, filename, err)
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func addZipEntry(zw *zip.Writer, e archiveEntry, modTime time.Time) error {
+	file, err := os.Open(e.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = e.Name
+	header.Method = zip.Deflate
+	if !modTime.IsZero() {
+		header.Modified = modTime.UTC()
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// archiveDirs returns the directories containing the entries,
+// parents first, so that they can be added to a tar archive.
+func archiveDirs(entries []archiveEntry) []string {
+	var dirs []string
+	seen := map[string]bool{}
+
+	var add func(dir string)
+	add = func(dir string) {
+		if dir == "." || dir == "/" || seen[dir] {
+			return
+		}
+		add(path.Dir(dir))
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+
+	for _, e := range entries {
+		add(path.Dir(e.Name))
+	}
+	return dirs
+}
@@ -0,0 +1,126 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BuildManifestFile is the name of the manifest written next to build outputs.
+const BuildManifestFile = "build-manifest.json"
+
+// Artifact describes a single output of a build.
+type Artifact struct {
+	Target  PackageTarget `json:"target"`
+	Version string        `json:"version"`
+	// Path is the location of the output, as passed to go build.
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Duration is how long the build took, in nanoseconds when serialized.
+	Duration time.Duration `json:"duration"`
+	// UpToDate is true if the build was skipped because its inputs had not changed.
+	UpToDate bool `json:"upToDate"`
+	// PostBuild holds the results of the package's PostBuild steps.
+	PostBuild []PostBuildResult `json:"postBuild,omitempty"`
+	// SBOM is the path of the artifact's SBOM, if Package.SBOM is set.
+	SBOM string `json:"sbom,omitempty"`
+	// Notices is the path of the artifact's third party notices, if Package.Licenses is set.
+	Notices string `json:"notices,omitempty"`
+}
+
+// BuildManifest lists the artifacts built for a package.
+type BuildManifest struct {
+	Name      string     `json:"name"`
+	Version   string     `json:"version"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// stat populates the size and digest of the artifact from the file at a.Path.
+func (a *Artifact) stat() error {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return fmt.Errorf("could not open artifact %q: %s", a.Path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("could not hash artifact %q: %s", a.Path, err)
+	}
+
+	a.Size = size
+	a.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// WriteBuildManifests writes a build-manifest.json into each directory
+// containing one of the artifacts, listing the artifacts in that directory.
+func WriteBuildManifests(pkg Package, artifacts []Artifact) error {
+	var dirs []string
+	byDir := map[string][]Artifact{}
+	for _, a := range artifacts {
+		dir := filepath.Dir(a.Path)
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], a)
+	}
+
+	for _, dir := range dirs {
+		if err := WriteBuildManifest(dir, pkg, byDir[dir]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteBuildManifest writes a build-manifest.json describing the artifacts into dir.
+func WriteBuildManifest(dir string, pkg Package, artifacts []Artifact) error {
+	version := pkg.VersionString
+	if version == "" {
+		version = pkg.Version.String()
+	}
+
+	manifest := BuildManifest{
+		Name:      pkg.Name,
+		Version:   version,
+		Artifacts: artifacts,
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, BuildManifestFile)
+	if err = ioutil.WriteFile(path, manifestBytes, 0644); err != nil {
+		return fmt.Errorf("could not write build manifest %q: %s", path, err)
+	}
+
+	return nil
+}
+
+// ReadBuildManifest reads a build-manifest.json written by BuildPackages.
+func ReadBuildManifest(path string) (BuildManifest, error) {
+	var manifest BuildManifest
+
+	manifestBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+
+	if err = json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return manifest, fmt.Errorf("could not parse build manifest %q: %s", path, err)
+	}
+
+	return manifest, nil
+}
@@ -0,0 +1,126 @@
+package build
+
+import (
+	"archive/zip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+func TestWriteBuildManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	linux := filepath.Join(dir, "linux", "hello")
+	windows := filepath.Join(dir, "windows", "hello.exe")
+	linuxArm := filepath.Join(dir, "linux", "hello-arm64")
+	var artifacts []Artifact
+	for path, target := range map[string]PackageTarget{
+		linux:    TargetLinuxAmd64,
+		windows:  TargetWindowsAmd64,
+		linuxArm: {OS: "linux", Arch: "arm64"},
+	} {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		a := Artifact{Target: target, Version: "1.2.3", Path: path}
+		if err = a.stat(); err != nil {
+			t.Fatal(err)
+		}
+		artifacts = append(artifacts, a)
+	}
+	sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Path < artifacts[j].Path })
+
+	pkg := NewPackage("hello", *semver.New("1.2.3"))
+	if err = WriteBuildManifests(pkg, artifacts); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir  string
+		want []Artifact
+	}{
+		{filepath.Join(dir, "linux"), artifacts[:2]},
+		{filepath.Join(dir, "windows"), artifacts[2:]},
+	}
+	for _, tt := range tests {
+		manifest, err := ReadBuildManifest(filepath.Join(tt.dir, BuildManifestFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if manifest.Name != "hello" || manifest.Version != "1.2.3" {
+			t.Errorf("%s: manifest is for %s %s", tt.dir, manifest.Name, manifest.Version)
+		}
+		if !reflect.DeepEqual(manifest.Artifacts, tt.want) {
+			t.Errorf("%s: got artifacts\n%+v\nwant\n%+v", tt.dir, manifest.Artifacts, tt.want)
+		}
+	}
+}
+
+func TestWritePluginPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outDir := filepath.Join(dir, "out")
+	if err = os.MkdirAll(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(outDir, "hello")
+	extra := filepath.Join(dir, "schema.json")
+	for _, path := range []string{binary, extra} {
+		if err = ioutil.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pkg := NewPackage("hello", *semver.New("1.2.3"))
+	artifact := Artifact{Target: TargetLinuxAmd64, Version: "1.2.3", Path: binary}
+	manifest := PluginManifest{ID: "hello", DisplayName: "Hello", Version: "1.2.3"}
+	zipPath := filepath.Join(outDir, "package.zip")
+
+	// packaging twice replaces the files linked into the output directory
+	cfg := PluginConfig{Package: pkg, Files: []string{extra}}
+	for i := 0; i < 2; i++ {
+		if err = writePluginPackage(context.Background(), cfg, pkg, manifest, artifact, zipPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	z, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range z.File {
+		names = append(names, f.Name)
+	}
+	z.Close()
+	if want := []string{"hello", PluginManifestFile, "schema.json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("package contains %v, want %v", names, want)
+	}
+	if _, err = os.Stat(filepath.Join(outDir, BuildManifestFile)); err != nil {
+		t.Errorf("build manifest was not written: %s", err)
+	}
+
+	cfg.Files = []string{filepath.Join(dir, "missing.json")}
+	err = writePluginPackage(context.Background(), cfg, pkg, manifest, artifact, zipPath)
+	if err == nil || !strings.Contains(err.Error(), "could not add "+cfg.Files[0]+" to package") {
+		t.Errorf("got error %v, want the missing file to fail the package", err)
+	}
+}
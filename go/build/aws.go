@@ -1,6 +1,7 @@
 package build
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -12,29 +13,40 @@ import (
 
 // UploadToS3 uploads a file to an AWS S3 bucket
 func UploadToS3(bucket, src, target string) (bool, error) {
+	return UploadToS3Context(context.Background(), bucket, src, target)
+}
+
+// UploadToS3Context is UploadToS3 with a context.
+// The upload is limited by the StepUpload timeout.
+func UploadToS3Context(ctx context.Context, bucket, src, target string) (bool, error) {
+	step := beginStep(ctx, StepUpload, Fields{"bucket": bucket, "key": target}, "uploading %s to S3", src)
+	ctx, cancel := stepContext(ctx, StepUpload)
+	defer cancel()
+
 	sess, err := session.NewSession()
 	if err != nil {
-		return false, err
+		return false, step.end(err)
 	}
 
 	uploader := s3manager.NewUploader(sess)
 
 	f, err := os.Open(src)
 	if err != nil {
-		return false, fmt.Errorf("failed to open file %q, %v", src, err)
+		return false, step.end(fmt.Errorf("failed to open file %q, %v", src, err))
 	}
+	defer f.Close()
 
 	// Upload the file to S3
-	_, err = uploader.Upload(&s3manager.UploadInput{
+	_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(target),
 		Body:   f,
 	})
 	if err != nil {
-		return false, fmt.Errorf("failed to upload file, %v", err)
+		return false, step.end(fmt.Errorf("failed to upload file, %v", err))
 	}
 
-	return true, nil
+	return true, step.end(nil)
 }
 
 // ToS3ReleasePath returns a path for upload to S3 in the format
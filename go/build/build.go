@@ -1,21 +1,18 @@
 package build
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"fmt"
-	"github.com/pkg/errors"
 	"io/ioutil"
-	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/coreos/go-semver/semver"
-
-	"github.com/magefile/mage/sh"
 )
 
 const (
@@ -24,12 +21,17 @@ const (
 )
 
 var (
-	TargetLinux386     = PackageTarget{"linux", "386"}
-	TargetLinuxAmd64   = PackageTarget{"linux", "amd64"}
-	TargetWindows386   = PackageTarget{"windows", "386"}
-	TargetWindowsAmd64 = PackageTarget{"windows", "amd64"}
-	TargetDarwinAmd64  = PackageTarget{"darwin", "amd64"}
-	TargetLocal        = PackageTarget{"", ""}
+	TargetLinux386     = PackageTarget{OS: "linux", Arch: "386"}
+	TargetLinuxAmd64   = PackageTarget{OS: "linux", Arch: "amd64"}
+	TargetLinuxArm64   = PackageTarget{OS: "linux", Arch: "arm64"}
+	TargetLinuxArmV6   = PackageTarget{OS: "linux", Arch: "arm", Variant: "v6"}
+	TargetLinuxArmV7   = PackageTarget{OS: "linux", Arch: "arm", Variant: "v7"}
+	TargetWindows386   = PackageTarget{OS: "windows", Arch: "386"}
+	TargetWindowsAmd64 = PackageTarget{OS: "windows", Arch: "amd64"}
+	TargetWindowsArm64 = PackageTarget{OS: "windows", Arch: "arm64"}
+	TargetDarwinAmd64  = PackageTarget{OS: "darwin", Arch: "amd64"}
+	TargetDarwinArm64  = PackageTarget{OS: "darwin", Arch: "arm64"}
+	TargetLocal        = PackageTarget{OS: "", Arch: ""}
 
 	DefaultPackageTargets = []PackageTarget{
 		TargetLinux386,
@@ -38,47 +40,14 @@ var (
 		TargetWindowsAmd64,
 	}
 
-	ReleaserTemplate *template.Template
-
-	releaserConfig = `
-# .goreleaser.yml
-builds:
-  - main: {{.Main}}
-    binary: {{.Name}}
-    goos:
-      - windows
-      - linux
-      - darwin
-    goarch:
-      - amd64
-    ldflags: '-s -w -X "{{.PackagePath}}/version.VersionBuild=Build.{{ "{{" }}.Env.BUILD_NUMBER{{ "}}" }}"'
-    env:
-      - CGO_ENABLED=0
-
-dockers:
-  - goos: linux
-    goarch: amd64
-    binary: {{.Name}}
-    image: {{.DockerRepo}}/{{.Name}}
-    tag_templates:
-    - "{{ "{{" }} .Tag {{ "}}" }}"
-
-# Archive customization
-archive:
-  format: tar.gz
-  format_overrides:
-    - goos: windows
-      format: zip
-  wrap_in_directory: true
-  replacements:
-    darwin: macOS
-`
+	// DefaultReleaseTargets are the targets a release is built for if none are given.
+	DefaultReleaseTargets = []PackageTarget{
+		TargetLinuxAmd64,
+		TargetWindowsAmd64,
+		TargetDarwinAmd64,
+	}
 )
 
-func init() {
-	ReleaserTemplate = template.Must(template.New("releaser").Parse(releaserConfig))
-}
-
 // Package provides information for building a binary image
 type Package struct {
 	Name        string
@@ -90,10 +59,43 @@ type Package struct {
 	// If present, will be compiled into a template and passed a Build to construct the name of the compiled binary.
 	OutTemplate string
 	DockerRepo  string
-	Shrink      bool
+	// Overrides change how the package is built for particular targets.
+	Overrides []TargetOverride
+	// PostBuild is run in order on each successfully built target.
+	PostBuild []PostBuildStep
+	// Archive controls the archives Release writes for each target.
+	Archive ArchiveOptions
+	// Docker controls the image Release builds when DockerRepo is set.
+	Docker DockerOptions
 	Main        string // The path to main.go or build dir
+	// Dir is the directory the go commands which build the package are run in,
+	// which Main is relative to. If empty, the current directory is used.
+	Dir         string
 	BuildArgs   []string
 	CGOEnabled bool
+	// Force disables skipping targets whose inputs have not changed since they were last built.
+	Force bool
+	// Reproducible builds with -trimpath and an empty build ID, sets the build
+	// date to the time of the last commit, and normalizes timestamps in plugin zips,
+	// so that building the same commit always produces identical outputs.
+	Reproducible bool
+	// VersionVars names the variables BuildPackage sets to the version and
+	// git information of the build. NewPackage points these at {PackagePath}/version.
+	VersionVars VersionVars
+	// Parallelism is the maximum number of targets BuildPackages will build at once.
+	// If zero, runtime.NumCPU() is used.
+	Parallelism int
+	// Sign writes SHA256SUMS and detached signatures for the outputs of
+	// BuildPackages, BuildPlugin and ReleaseArchives, using the key from
+	// $CI_SIGNING_KEY or $CI_SIGNING_KEY_FILE. See SignArtifacts.
+	Sign bool
+	// SBOM, if set, is the format of an SBOM written next to each artifact,
+	// SBOMCycloneDX or SBOMSPDX. See WriteSBOM.
+	SBOM string
+	// Licenses, if set, collects the licenses of the modules linked into each
+	// artifact into {artifact}.THIRD_PARTY_NOTICES, and fails the build if
+	// one is denied. See WriteThirdPartyNotices.
+	Licenses *LicenseOptions
 }
 
 // NewPackage creates a new package with default values configured.
@@ -102,6 +104,7 @@ type Package struct {
 // 		OutDir: 	 "./bin"
 // 		DockerRepo:	 "docker.naveego.com:4333"
 // 		Main: 		 "main.go"
+// 		VersionVars: DefaultVersionVars("github.com/naveegoinc/{name}")
 // Given the variables name="helloworld" and version="v1.0.0", the
 // return package would have the following values:
 // 		Name:		 "helloworld"
@@ -119,13 +122,17 @@ func NewPackage(name string, version semver.Version) Package {
 		OutDir:      "./bin",
 		DockerRepo:  "docker.n5o.black/private",
 		Main:        "main.go",
+		VersionVars: DefaultVersionVars("github.com/naveegoinc/" + name),
 	}
 }
 
-// PackageTarget defines
+// PackageTarget defines the platform a package is built for.
 type PackageTarget struct {
-	OS   string
-	Arch string
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+	// Variant selects a sub-architecture, such as "v7" for GOARM=7 or
+	// "v3" for GOAMD64=v3. See ParseTarget for the supported values.
+	Variant string `json:"variant,omitempty"`
 }
 
 // Build combines a Package and a PackageTarget
@@ -135,11 +142,24 @@ type Build struct {
 }
 
 func (t PackageTarget) String() string {
+	if t.Variant != "" {
+		return fmt.Sprintf("%s_%s_%s", t.OS, t.Arch, t.Variant)
+	}
 	return fmt.Sprintf("%s_%s", t.OS, t.Arch)
 }
 
-// BuildPackages performs a go build on the supplied package.
-func BuildPackages(pkg Package, targets ...PackageTarget) error {
+// BuildPackages performs a go build on the supplied package for each target,
+// building up to pkg.Parallelism targets at once. Every target is attempted even
+// if some fail; failures are reported individually and returned as BuildErrors.
+// The artifacts which were built successfully are returned in target order, and
+// are recorded in a build-manifest.json in each output directory.
+func BuildPackages(pkg Package, targets ...PackageTarget) ([]Artifact, error) {
+	return BuildPackagesContext(context.Background(), pkg, targets...)
+}
+
+// BuildPackagesContext is BuildPackages with a context. If ctx is cancelled,
+// running builds are stopped and targets which have not started are not built.
+func BuildPackagesContext(ctx context.Context, pkg Package, targets ...PackageTarget) ([]Artifact, error) {
 	var buildTargets []PackageTarget
 
 	if len(targets) > 0 {
@@ -148,15 +168,126 @@ func BuildPackages(pkg Package, targets ...PackageTarget) error {
 		buildTargets = DefaultPackageTargets
 	}
 
-	for _, t := range buildTargets {
-		BuildPackage(pkg, t)
+	if err := ValidateTargetsContext(ctx, buildTargets...); err != nil {
+		return nil, err
 	}
 
-	return nil
+	parallelism := pkg.Parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+
+	var (
+		wg        sync.WaitGroup
+		sem       = make(chan struct{}, parallelism)
+		results   = make([]*TargetError, len(buildTargets))
+		artifacts = make([]*Artifact, len(buildTargets))
+	)
+
+	for i, t := range buildTargets {
+		wg.Add(1)
+		go func(i int, t PackageTarget) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i] = &TargetError{Target: t, Err: ctx.Err()}
+				return
+			}
+
+			artifact, err := BuildPackageContext(ctx, pkg, t)
+			if err != nil {
+				results[i] = &TargetError{Target: t, Err: err}
+				CIBuildProblem(results[i])
+				return
+			}
+			artifacts[i] = &artifact
+		}(i, t)
+	}
+
+	wg.Wait()
+
+	var (
+		errs  BuildErrors
+		built []Artifact
+	)
+	for i, err := range results {
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			built = append(built, *artifacts[i])
+		}
+	}
+
+	if !isDryRun(ctx) {
+		if err := WriteBuildManifests(pkg, built); err != nil {
+			return built, err
+		}
+	}
+
+	if pkg.Sign && len(built) > 0 {
+		var paths []string
+		for _, a := range built {
+			paths = append(paths, a.Path)
+			if a.SBOM != "" {
+				paths = append(paths, a.SBOM)
+			}
+			if a.Notices != "" {
+				paths = append(paths, a.Notices)
+			}
+		}
+		// outputs named by OutTemplate are signed in the directory containing them all
+		opts := SignOptions{Dir: pkg.OutDir}
+		if pkg.OutTemplate != "" {
+			opts.Dir = ""
+		}
+		if _, err := SignArtifactsContext(ctx, opts, paths...); err != nil {
+			return built, err
+		}
+	}
+
+	if len(errs) > 0 {
+		return built, errs
+	}
+
+	return built, nil
 }
 
-// BuildPackage builds a package and returns the path to the output.
-func BuildPackage(pkg Package, t PackageTarget) (string, error) {
+// TargetError is the error returned when a single target fails to build.
+type TargetError struct {
+	Target PackageTarget
+	Err    error
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("building target %s: %s", e.Target, e.Err)
+}
+
+// Cause returns the underlying build error.
+func (e *TargetError) Cause() error {
+	return e.Err
+}
+
+// BuildErrors combines the failures of a multi-target build.
+type BuildErrors []*TargetError
+
+func (e BuildErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d target(s) failed to build:\n\t%s", len(e), strings.Join(msgs, "\n\t"))
+}
+
+// BuildPackage builds a package and returns the resulting artifact.
+func BuildPackage(pkg Package, t PackageTarget) (Artifact, error) {
+	return BuildPackageContext(context.Background(), pkg, t)
+}
+
+// BuildPackageContext is BuildPackage with a context.
+// The go build is limited by the StepBuild timeout.
+func BuildPackageContext(ctx context.Context, pkg Package, t PackageTarget) (Artifact, error) {
 
 	var err error
 	if pkg.VersionString == "" {
@@ -166,6 +297,13 @@ func BuildPackage(pkg Package, t PackageTarget) (string, error) {
 	SetTeamCityParameter("env.VERSION_NUMBER", "v"+pkg.VersionString)
 	var outDir string
 
+	override := pkg.override(t)
+	if override.CGOEnabled != nil {
+		pkg.CGOEnabled = *override.CGOEnabled
+	}
+	pkg.BuildArgs = mergeTags(pkg.BuildArgs, override.Tags)
+	pkg.BuildArgs = mergeLdflags(pkg.BuildArgs, nil, override.Ldflags)
+
 	env := map[string]string{}
 
 	if pkg.CGOEnabled {
@@ -182,6 +320,14 @@ func BuildPackage(pkg Package, t PackageTarget) (string, error) {
 		env["GOARCH"] = t.Arch
 	}
 
+	if name, value := t.variantEnv(); name != "" {
+		env[name] = value
+	}
+
+	for k, v := range override.Env {
+		env[k] = v
+	}
+
 	var outFile string
 
 	if pkg.OutTemplate != "" {
@@ -189,7 +335,7 @@ func BuildPackage(pkg Package, t PackageTarget) (string, error) {
 		b := new(strings.Builder)
 		err = outTemplate.Execute(b, Build{pkg,t})
 		if err != nil {
-			return "", fmt.Errorf("executing pkg.OutTemplate %q: %s", pkg.OutTemplate, err)
+			return Artifact{}, fmt.Errorf("executing pkg.OutTemplate %q: %s", pkg.OutTemplate, err)
 		}
 		outFile = b.String()
 	} else {
@@ -203,7 +349,7 @@ func BuildPackage(pkg Package, t PackageTarget) (string, error) {
 		if t.OS == "" && t.Arch == "" {
 			pkgName = pkg.Name
 		} else {
-			pkgName = fmt.Sprintf("%s_%s_%s_%s", pkg.Name, pkg.VersionString, t.OS, t.Arch)
+			pkgName = fmt.Sprintf("%s_%s_%s", pkg.Name, pkg.VersionString, t)
 		}
 
 		if t.OS == "windows" {
@@ -213,6 +359,14 @@ func BuildPackage(pkg Package, t PackageTarget) (string, error) {
 		outFile = filepath.Join(outDir, pkgName)
 	}
 
+	if override.OutName != "" {
+		outName, err := override.outName(pkg, t)
+		if err != nil {
+			return Artifact{}, err
+		}
+		outFile = filepath.Join(filepath.Dir(outFile), outName)
+	}
+
 
 	buildArgs := []string{
 		"build",
@@ -220,171 +374,272 @@ func BuildPackage(pkg Package, t PackageTarget) (string, error) {
 		outFile,
 	}
 
-	for _, a := range pkg.BuildArgs {
+	ldflags := versionLdflags(ctx, pkg)
+	buildDate := time.Now()
+
+	if pkg.Reproducible {
+		pkg.BuildArgs = append([]string{"-trimpath"}, pkg.BuildArgs...)
+		ldflags = append(ldflags, "-buildid=")
+		buildDate, err = GitCommitTimeContext(ctx)
+		if err != nil {
+			return Artifact{}, fmt.Errorf("reproducible builds require the commit time: %s", err)
+		}
+	}
+
+	artifact := Artifact{
+		Target:  t,
+		Version: pkg.VersionString,
+		Path:    outFile,
+	}
+
+	fp, fpErr := fingerprint(ctx, pkg, env, ldflags)
+	if fpErr != nil {
+		logEvent(ctx, LevelWarn, StepBuild, Fields{"target": t.String(), "error": fpErr.Error()}, "could not fingerprint %s, it will be rebuilt", outFile)
+	} else if !pkg.Force && upToDate(outFile, fp) {
+		logEvent(ctx, LevelInfo, StepBuild, Fields{"target": t.String()}, "%s is up to date", outFile)
+		artifact.UpToDate = true
+		if pkg.SBOM != "" {
+			if _, err = os.Stat(outFile + sbomExt(pkg.SBOM)); err == nil {
+				artifact.SBOM = outFile + sbomExt(pkg.SBOM)
+			}
+		}
+		if pkg.Licenses != nil {
+			if _, err = os.Stat(outFile + "." + NoticesFile); err == nil {
+				artifact.Notices = outFile + "." + NoticesFile
+			}
+		}
+		return artifact, artifact.stat()
+	}
+
+	ldflags = append(ldflags, buildDateLdflags(pkg, buildDate)...)
+
+	for _, a := range mergeLdflags(pkg.BuildArgs, ldflags, nil) {
 		buildArgs = append(buildArgs, a)
 	}
 
 	buildArgs = append(buildArgs, pkg.Main)
 
-	log.Printf("Building %s to %s ...\n", pkg.PackagePath, outFile)
-	err = sh.RunWith(env, "go", buildArgs...)
+	step := beginStep(ctx, StepBuild, Fields{"target": t.String(), "out": outFile}, "building %s", pkg.PackagePath)
+	start := time.Now()
+	buildCtx, cancel := stepContext(ctx, StepBuild)
+	_, err = runCommand(buildCtx, Command{Name: "go", Args: buildArgs, Env: env, Dir: pkg.Dir})
+	if err != nil && buildCtx.Err() != nil {
+		// report the timeout or cancellation itself, rather than how go died
+		err = buildCtx.Err()
+	}
+	cancel()
 
 	if err != nil {
-		if pkg.Shrink {
-			tryShrink(pkg, t, outFile)
-		}
+		// a build which was killed may have left part of its output behind
+		os.Remove(outFile)
+		return artifact, step.end(err)
 	}
 
-	return outFile, err
-}
+	if isDryRun(ctx) {
+		return artifact, step.end(nil)
+	}
 
-func tryShrink(pkg Package, t PackageTarget, binaryPath string) {
-	strip, err := exec.LookPath("strip")
-	if strip != "" && err == nil {
-		switch t.OS {
-		case "linux":
-			if err = sh.Run("strip", binaryPath); err != nil {
-				log.Printf("running strip on %q returned error: %s", binaryPath, err)
-			}
+	// the SBOM and notices are written first, since post build steps such as UPX hide the build info
+	if pkg.SBOM != "" {
+		artifact.SBOM, err = WriteSBOM(outFile, SBOMOptions{
+			Format:  pkg.SBOM,
+			Name:    pkg.Name,
+			Version: pkg.VersionString,
+			Time:    buildDate,
+		})
+		if err != nil {
+			return artifact, step.end(err)
+		}
+	}
 
+	if pkg.Licenses != nil {
+		artifact.Notices, _, err = WriteThirdPartyNoticesContext(ctx, outFile, *pkg.Licenses)
+		if err != nil {
+			return artifact, step.end(err)
 		}
 	}
 
-	upx, err := exec.LookPath("upx")
-	if upx != "" && err == nil {
-		if err = sh.Run("upx", binaryPath); err != nil {
-			log.Printf("running UPX on %q returned error: %s", binaryPath, err)
+	artifact.PostBuild, err = runPostBuild(ctx, pkg.PostBuild, artifact)
+	if err != nil {
+		return artifact, step.end(err)
+	}
+
+	if err = artifact.stat(); err != nil {
+		return artifact, step.end(err)
+	}
+	artifact.Duration = time.Since(start)
+
+	if fpErr == nil {
+		if err = writeFingerprint(outFile, fp); err != nil {
+			logEvent(ctx, LevelWarn, StepBuild, Fields{"target": t.String(), "error": err.Error()}, "could not save fingerprint for %s", outFile)
 		}
 	}
+
+	step.endWith(Fields{"size": artifact.Size, "sha256": artifact.SHA256}, nil)
+	return artifact, nil
 }
 
-// Release executes a gorelease operation
-func Release(pkg Package) error {
-	if !RunningOnTeamCity() {
+// Release releases the package. If the repo has a .goreleaser.yml, the pinned
+// version of goreleaser is installed with EnsureTool and run with it. Otherwise ReleaseArchives
+// writes the release for targets (or DefaultReleaseTargets) into pkg.Archive.Dir.
+func Release(pkg Package, targets ...PackageTarget) error {
+	return ReleaseContext(context.Background(), pkg, targets...)
+}
+
+// ReleaseContext is Release with a context. The goreleaser run is limited by
+// the StepRelease timeout. Temporary files are removed even if ctx is cancelled.
+func ReleaseContext(ctx context.Context, pkg Package, targets ...PackageTarget) error {
+	if !RunningOnTeamCity() && !isDryRun(ctx) {
 		return fmt.Errorf("this operation should only be performed in our CI environment")
 	}
 
-	configFile := "./.goreleaser.yml"
+	configFile := "./" + DefaultReleaserConfigFile
 
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		log.Println("no goreleaser config found, auto-generating .goreleaser.yml")
-		tmpDir, err := ioutil.TempDir("", pkg.Name)
-		if err != nil {
-			return fmt.Errorf("could not create temp directory for goreleaser config, %v", err)
-		}
-		defer os.RemoveAll(tmpDir)
+		step := beginStep(ctx, StepRelease, nil, "releasing %s %s", pkg.Name, pkg.VersionString)
+		result, err := ReleaseArchivesContext(ctx, pkg, targets...)
+		return step.endWith(Fields{"dir": result.Dir, "archives": len(result.Archives)}, err)
+	}
 
-		err = writeConfigFile(tmpDir, pkg)
-		if err != nil {
-			return fmt.Errorf("could not write .goreleaser.yml, %v", err)
-		}
+	goreleaser, err := EnsureToolContext(ctx, "goreleaser")
+	if err != nil {
+		return fmt.Errorf("could not release with %s: %s (remove it to use the built in release)", configFile, err)
+	}
 
-		configFile = filepath.Join(tmpDir, ".goreleaser.yml")
+	tmpDir, err := ioutil.TempDir("", pkg.Name)
+	if err != nil {
+		return fmt.Errorf("could not create temp directory for release notes, %v", err)
 	}
+	defer os.RemoveAll(tmpDir)
 
-	// make sure we have goreleaser
-	err := sh.Run("go", "get", "github.com/goreleaser/goreleaser")
+	args := []string{"--config", configFile, "--clean"}
+
+	notesFile, err := writeReleaseNotes(ctx, tmpDir, pkg)
 	if err != nil {
-		return fmt.Errorf("could not install goreleaser, %v", err)
+		logEvent(ctx, LevelWarn, StepRelease, Fields{"error": err.Error()}, "could not generate release notes, using goreleaser's changelog")
+	} else {
+		args = append(args, "--release-notes", notesFile)
 	}
 
-	log.Printf("executing goreleaser with config file '%s'\n", configFile)
-	return sh.Run("goreleaser", "--config", configFile, "--rm-dist")
+	step := beginStep(ctx, StepRelease, Fields{"config": configFile}, "releasing %s %s with goreleaser", pkg.Name, pkg.VersionString)
+	ctx, cancel := stepContext(ctx, StepRelease)
+	defer cancel()
+
+	return step.end(run(ctx, goreleaser, args...))
 }
 
-func writeConfigFile(tmpDir string, pkg Package) error {
-	cfgPath := filepath.Join(tmpDir, ".goreleaser.yml")
-	configFile, err := os.Create(cfgPath)
+// writeReleaseNotes writes the changelog since the previous release to
+// RELEASE_NOTES.md in dir, linking issue keys to $JIRA_URL if it is set and
+// collecting only the issues of the comma separated $JIRA_PROJECTS if it is.
+func writeReleaseNotes(ctx context.Context, dir string, pkg Package) (string, error) {
+	opts := ChangelogOptions{Version: "v" + pkg.VersionString}
+	if jiraURL := os.Getenv("JIRA_URL"); jiraURL != "" {
+		opts.IssueURL = strings.TrimSuffix(jiraURL, "/") + "/browse/"
+	}
+	if projects := os.Getenv("JIRA_PROJECTS"); projects != "" {
+		opts.IssueProjects = strings.Split(projects, ",")
+	}
+
+	changelog, err := GenerateChangelogContext(ctx, opts)
 	if err != nil {
-		return fmt.Errorf("could not create .goreleaser.yml, %v", err)
+		return "", err
 	}
-	defer configFile.Close()
 
-	return ReleaserTemplate.Execute(configFile, pkg)
+	path := filepath.Join(dir, "RELEASE_NOTES.md")
+	if err = ioutil.WriteFile(path, []byte(changelog.Markdown()), 0644); err != nil {
+		return "", fmt.Errorf("could not write release notes: %s", err)
+	}
+	return path, nil
 }
 
 type PluginConfig struct {
 	Package Package
 	Targets []PackageTarget
 	Files   []string
+	// IncludeSBOM adds the SBOM of each artifact to its package.zip,
+	// in CycloneDX format unless Package.SBOM is set.
+	IncludeSBOM bool
+	// IncludeNotices adds the third party notices of each artifact to its
+	// package.zip as THIRD_PARTY_NOTICES, collecting them if Package.Licenses is not set.
+	IncludeNotices bool
+	// Icon controls how the icon named by the manifest's iconFile is checked
+	// and resized. See LoadIcon.
+	Icon IconOptions
 }
 
 func BuildPlugin(cfg PluginConfig) error {
+	return BuildPluginContext(context.Background(), cfg)
+}
 
-	manifestBytes, err := ioutil.ReadFile("manifest.json")
-	if err != nil {
-		return err
-	}
-	var manifest map[string]interface{}
-	err = json.Unmarshal(manifestBytes, &manifest)
+// BuildPluginContext is BuildPlugin with a context.
+func BuildPluginContext(ctx context.Context, cfg PluginConfig) error {
+
+	manifest, err := ReadPluginManifest(PluginManifestFile, cfg.Package.VersionString)
 	if err != nil {
 		return err
 	}
 
-	manifest["version"] = cfg.Package.VersionString
+	manifest.Version = cfg.Package.VersionString
 	pkg := cfg.Package
-	if iconFile, ok := manifest["iconFile"].(string); ok {
-		iconBytes, err := ioutil.ReadFile(iconFile)
-		if err == nil {
-			iconBytes64 := base64.StdEncoding.EncodeToString(iconBytes)
-			ext := filepath.Ext(iconFile)
-			icon64 := fmt.Sprintf("data:image/%s;base64,%s", ext, iconBytes64)
-			manifest["icon"] = icon64
+	if cfg.IncludeSBOM && pkg.SBOM == "" {
+		pkg.SBOM = SBOMCycloneDX
+	}
+	if cfg.IncludeNotices && pkg.Licenses == nil {
+		pkg.Licenses = &LicenseOptions{}
+	}
+	if manifest.IconFile != "" {
+		icon, err := LoadIcon(manifest.IconFile, cfg.Icon)
+		if err != nil {
+			return err
 		}
+		manifest.Icon = icon.DataURI()
 	}
 
 	if pkg.OutTemplate == "" {
-		pkg.OutTemplate = fmt.Sprintf("build/outputs/{{.PackageTarget.OS}}/{{.PackageTarget.Arch}}/%s/{{.Package.VersionString}}/%s{{if eq .PackageTarget.OS `windows`}}.exe{{end}}", cfg.Package.Name, cfg.Package.Name)
+		pkg.OutTemplate = fmt.Sprintf("build/outputs/{{.PackageTarget.OS}}/{{.PackageTarget.Arch}}{{with .PackageTarget.Variant}}/{{.}}{{end}}/%s/{{.Package.VersionString}}/%s{{if eq .PackageTarget.OS `windows`}}.exe{{end}}", cfg.Package.Name, cfg.Package.Name)
 	}
 
-	for _, target := range cfg.Targets {
+	if err = ValidateTargetsContext(ctx, cfg.Targets...); err != nil {
+		return err
+	}
 
-		outBinary, err := BuildPackage(pkg, target)
-		outDir := filepath.Dir(outBinary)
+	for _, target := range cfg.Targets {
 
+		artifact, err := BuildPackageContext(ctx, pkg, target)
 		if err != nil {
-			return fmt.Errorf("error building target %s", target)
+			return fmt.Errorf("error building target %s: %s", target, err)
 		}
 
-		manifest["os"] = target.OS
-		manifest["arch"] = target.Arch
-		manifest["executable"] = filepath.Base(outBinary)
+		zipPath := filepath.Join(filepath.Dir(artifact.Path), "package.zip")
 
-		outManifest := filepath.Join(outDir, "manifest.json")
-
-		manifestBytes, _ = json.Marshal(manifest)
-
-		ioutil.WriteFile(outManifest, manifestBytes, 0777)
-
-		include := []string{
-			outBinary,
-			outManifest,
+		if isDryRun(ctx) {
+			logEvent(ctx, LevelInfo, StepBuild, Fields{"target": target.String()}, "dry run: not packaging %s into %s", artifact.Path, zipPath)
+		} else if err = writePluginPackage(ctx, cfg, pkg, manifest, artifact, zipPath); err != nil {
+			return err
 		}
-		for _, file := range cfg.Files {
-			dst := filepath.Join(outDir, file)
-			os.Link(file, dst)
-			include = append(include, dst)
-		}
-
-		zipPath := filepath.Join(outDir, "package.zip")
 
-		err = ZipFiles(zipPath, include)
-		if err != nil {
-			return fmt.Errorf("error zipping files %v into %q: %s", include, zipPath, err)
+		if pkg.Sign {
+			if _, err = SignArtifactsContext(ctx, SignOptions{}, zipPath); err != nil {
+				return err
+			}
 		}
 
 		uploadEnv := os.Getenv("UPLOAD")
 
-		fmt.Println("UPLOAD: ", uploadEnv)
-
-		if uploadEnv != "" {
+		if uploadEnv == "" {
+			logEvent(ctx, LevelDebug, StepUpload, Fields{"target": target.String()}, "UPLOAD is not set, not uploading %s", zipPath)
+		} else {
 
-			err = ensureGoBetweenInstalled()
+			var between string
+			between, err = EnsureToolContext(ctx, "between")
 			if err != nil {
 				return err
 			}
 
-			err = sh.Run("between", "dev", "upload-plugin", zipPath, "--env", uploadEnv)
+			step := beginStep(ctx, StepUpload, Fields{"target": target.String(), "env": uploadEnv}, "uploading %s", zipPath)
+			uploadCtx, cancel := stepContext(ctx, StepUpload)
+			err = step.end(run(uploadCtx, between, "dev", "upload-plugin", zipPath, "--env", uploadEnv))
+			cancel()
 			if err != nil {
 				return err
 			}
@@ -394,15 +649,65 @@ func BuildPlugin(cfg PluginConfig) error {
 	return err
 }
 
-func ensureGoBetweenInstalled() error {
+// writePluginPackage writes the plugin manifest for the artifact and
+// zips it with the artifact and the plugin's files into zipPath.
+func writePluginPackage(ctx context.Context, cfg PluginConfig, pkg Package, manifest PluginManifest, artifact Artifact, zipPath string) error {
+	outBinary := artifact.Path
+	outDir := filepath.Dir(outBinary)
 
-	exe, err := exec.LookPath("between")
+	err := WriteBuildManifest(outDir, pkg, []Artifact{artifact})
 	if err != nil {
 		return err
 	}
-	if exe == "" {
-		return errors.New("you need to run go install github.com/naveegoinc/go-between/cmd/between")
+
+	manifest.OS = artifact.Target.OS
+	manifest.Arch = artifact.Target.ArchVariant()
+	manifest.Executable = filepath.Base(outBinary)
+
+	outManifest := filepath.Join(outDir, PluginManifestFile)
+	if err = WritePluginManifest(outManifest, manifest); err != nil {
+		return err
+	}
+
+	include := []string{
+		outBinary,
+		outManifest,
+	}
+	for _, file := range cfg.Files {
+		dst := filepath.Join(outDir, file)
+		os.Remove(dst)
+		if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+			err = os.Link(file, dst)
+		}
+		if err != nil {
+			return fmt.Errorf("could not add %s to package: %s", file, err)
+		}
+		include = append(include, dst)
+	}
+	if cfg.IncludeSBOM && artifact.SBOM != "" {
+		include = append(include, artifact.SBOM)
+	}
+	if cfg.IncludeNotices && artifact.Notices != "" {
+		dst := filepath.Join(outDir, NoticesFile)
+		os.Remove(dst)
+		if err = os.Link(artifact.Notices, dst); err != nil {
+			return fmt.Errorf("could not add notices to package: %s", err)
+		}
+		include = append(include, dst)
+	}
+
+	if pkg.Reproducible {
+		var commitTime time.Time
+		commitTime, err = GitCommitTimeContext(ctx)
+		if err == nil {
+			err = ZipFilesWithModTime(zipPath, include, commitTime)
+		}
+	} else {
+		err = ZipFiles(zipPath, include)
+	}
+	if err != nil {
+		return fmt.Errorf("error zipping files %v into %q: %s", include, zipPath, err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,148 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// testPlatforms is the `go tool dist list` output given to builds run by fakeGoRunner.
+const testPlatforms = "darwin/amd64\ndarwin/arm64\nlinux/386\nlinux/amd64\nlinux/arm\nlinux/arm64\nwindows/386\nwindows/amd64\nwindows/arm64"
+
+// fakeGoRunner is a RecordingRunner which also writes the output file of each
+// `go build -o file` which succeeds, so that the build functions can find it,
+// and records how many builds were running at once.
+type fakeGoRunner struct {
+	*RecordingRunner
+
+	mu         sync.Mutex
+	running    int
+	maxRunning int
+}
+
+func newFakeGoRunner() *fakeGoRunner {
+	r := &fakeGoRunner{RecordingRunner: &RecordingRunner{}}
+	r.Respond("go tool dist list", testPlatforms, nil)
+	return r
+}
+
+func (r *fakeGoRunner) Run(ctx context.Context, cmd Command) (string, error) {
+	if cmd.Name != "go" || len(cmd.Args) < 3 || cmd.Args[0] != "build" || cmd.Args[1] != "-o" {
+		return r.RecordingRunner.Run(ctx, cmd)
+	}
+
+	r.mu.Lock()
+	r.running++
+	if r.running > r.maxRunning {
+		r.maxRunning = r.running
+	}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running--
+		r.mu.Unlock()
+	}()
+
+	out, err := r.RecordingRunner.Run(ctx, cmd)
+	if err != nil {
+		return out, err
+	}
+	select {
+	case <-time.After(10 * time.Millisecond):
+	case <-ctx.Done():
+		return out, ctx.Err()
+	}
+	if err = os.MkdirAll(filepath.Dir(cmd.Args[2]), 0755); err != nil {
+		return out, err
+	}
+	return out, ioutil.WriteFile(cmd.Args[2], []byte(cmd.String()), 0755)
+}
+
+func newTestPackage(t *testing.T) (Package, func()) {
+	dir, err := ioutil.TempDir("", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := NewPackage("hello", *semver.New("1.2.3"))
+	pkg.OutDir = dir
+	pkg.Main = "."
+	return pkg, func() { os.RemoveAll(dir) }
+}
+
+func TestBuildPackagesAggregatesErrors(t *testing.T) {
+	pkg, cleanup := newTestPackage(t)
+	defer cleanup()
+	pkg.Parallelism = 2
+
+	targets := []PackageTarget{TargetLinuxAmd64, TargetWindowsAmd64, TargetDarwinAmd64, {OS: "linux", Arch: "arm64"}}
+
+	r := newFakeGoRunner()
+	failed := filepath.Join(pkg.OutDir, "hello_1.2.3_windows_amd64.exe")
+	r.Respond("go build -o "+failed, "", fmt.Errorf("exit status 2"))
+
+	artifacts, err := BuildPackagesContext(WithRunner(context.Background(), r), pkg, targets...)
+
+	errs, ok := err.(BuildErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("got error %v, want one BuildErrors entry", err)
+	}
+	if errs[0].Target != TargetWindowsAmd64 {
+		t.Errorf("failed target is %s, want %s", errs[0].Target, TargetWindowsAmd64)
+	}
+	want := "1 target(s) failed to build:\n\tbuilding target windows_amd64: exit status 2"
+	if err.Error() != want {
+		t.Errorf("got error\n%s\nwant\n%s", err, want)
+	}
+
+	var built []PackageTarget
+	for _, a := range artifacts {
+		built = append(built, a.Target)
+		if a.SHA256 == "" {
+			t.Errorf("artifact %s was not hashed", a.Path)
+		}
+	}
+	if wantBuilt := []PackageTarget{TargetLinuxAmd64, TargetDarwinAmd64, {OS: "linux", Arch: "arm64"}}; !reflect.DeepEqual(built, wantBuilt) {
+		t.Errorf("built %v, want %v in target order", built, wantBuilt)
+	}
+
+	if r.maxRunning > pkg.Parallelism {
+		t.Errorf("%d builds ran at once, want at most %d", r.maxRunning, pkg.Parallelism)
+	}
+
+	manifest, err := ReadBuildManifest(filepath.Join(pkg.OutDir, BuildManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Artifacts) != len(artifacts) {
+		t.Errorf("manifest lists %d artifacts, want %d", len(manifest.Artifacts), len(artifacts))
+	}
+}
+
+func TestBuildPackagesCancelled(t *testing.T) {
+	pkg, cleanup := newTestPackage(t)
+	defer cleanup()
+	pkg.Parallelism = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := BuildPackagesContext(WithRunner(ctx, newFakeGoRunner()), pkg, TargetLinuxAmd64, TargetWindowsAmd64)
+	errs, ok := err.(BuildErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("got error %v, want both targets to fail", err)
+	}
+	for _, e := range errs {
+		if !strings.Contains(e.Err.Error(), context.Canceled.Error()) {
+			t.Errorf("%s failed with %v, want %v", e.Target, e.Err, context.Canceled)
+		}
+	}
+}
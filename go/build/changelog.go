@@ -0,0 +1,329 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// DefaultIssuePattern matches JIRA style issue keys, such as "PROJ-123".
+// It also matches names such as "SHA-256" and "HTTP-2", so when it is used
+// the matches whose project is in notIssueProjects are ignored.
+var DefaultIssuePattern = regexp.MustCompile(`\b[A-Z][A-Z0-9]{1,9}-[1-9][0-9]*\b`)
+
+// notIssueProjects are the common names of algorithms, encodings and protocols
+// which DefaultIssuePattern mistakes for issue keys.
+var notIssueProjects = map[string]bool{
+	"AES": true, "CRC": true, "CVE": true, "HTTP": true, "ISO": true, "MD": true,
+	"RFC": true, "SHA": true, "SSL": true, "TLS": true, "UTF": true, "UCS": true,
+}
+
+// Changelog formats, as accepted by Changelog.Render.
+const (
+	ChangelogMarkdown = "markdown"
+	ChangelogHTML     = "html"
+	ChangelogJSON     = "json"
+)
+
+// ChangelogOptions selects the commits in a changelog and how they are rendered.
+type ChangelogOptions struct {
+	// From is the ref the changelog starts after. If empty, the previous
+	// release tag before To is used, or the whole history if there is none.
+	From string
+	// To is the ref the changelog ends at. If empty, HEAD is used.
+	To string
+	// Version is the title of the changelog. If empty, To is used.
+	Version string
+	// IssueProjects, if set, are the project keys of the issues to collect,
+	// such as "PROJ" for "PROJ-123". Only their issues are matched, and
+	// IssuePattern is not used.
+	IssueProjects []string
+	// IssuePattern matches the issue keys in commit messages.
+	// If nil, DefaultIssuePattern is used.
+	IssuePattern *regexp.Regexp
+	// IssueURL, if set, is prefixed to issue keys to link to them,
+	// such as "https://example.atlassian.net/browse/".
+	IssueURL string
+}
+
+// Changelog is the list of changes between two refs, grouped by type.
+type Changelog struct {
+	Version  string             `json:"version"`
+	From     string             `json:"from,omitempty"`
+	To       string             `json:"to"`
+	Date     time.Time          `json:"date"`
+	Sections []ChangelogSection `json:"sections"`
+	// Issues are the issue keys mentioned by any commit, in the order they first appear.
+	Issues   []string `json:"issues"`
+	IssueURL string   `json:"issueUrl,omitempty"`
+}
+
+// ChangelogSection holds the commits of a type, such as "feat".
+type ChangelogSection struct {
+	Type    string           `json:"type"`
+	Title   string           `json:"title"`
+	Entries []ChangelogEntry `json:"entries"`
+}
+
+// ChangelogEntry is a commit in a changelog, with the issue keys it mentions.
+type ChangelogEntry struct {
+	Commit
+	Issues []string `json:"issues,omitempty"`
+}
+
+// ShortHash returns the first 7 characters of the commit hash.
+func (e ChangelogEntry) ShortHash() string {
+	if len(e.Hash) > 7 {
+		return e.Hash[:7]
+	}
+	return e.Hash
+}
+
+// changelogSections are the sections of a changelog, in order.
+// Breaking changes always go in the first section, and commits
+// of other types in the last.
+var changelogSections = []struct {
+	Type  string
+	Title string
+}{
+	{"breaking", "Breaking Changes"},
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance Improvements"},
+	{"refactor", "Refactoring"},
+	{"docs", "Documentation"},
+	{"other", "Other Changes"},
+}
+
+// GenerateChangelog reads the commits selected by opts and groups them
+// into sections by their conventional commit type. Every issue key in
+// each commit's subject and body is collected.
+func GenerateChangelog(opts ChangelogOptions) (Changelog, error) {
+	return GenerateChangelogContext(context.Background(), opts)
+}
+
+func GenerateChangelogContext(ctx context.Context, opts ChangelogOptions) (Changelog, error) {
+	if opts.To == "" {
+		opts.To = "HEAD"
+	}
+
+	c := Changelog{
+		Version:  opts.Version,
+		From:     opts.From,
+		To:       opts.To,
+		Date:     time.Now().UTC(),
+		IssueURL: opts.IssueURL,
+	}
+	if c.Version == "" {
+		c.Version = opts.To
+	}
+
+	if c.From == "" {
+		var err error
+		if c.From, err = previousReleaseTag(ctx, opts.To); err != nil {
+			return c, err
+		}
+	}
+
+	commits, err := GitCommitsContext(ctx, c.From, c.To)
+	if err != nil {
+		return c, err
+	}
+	if len(commits) > 0 {
+		c.Date = commits[0].Time
+	}
+
+	sections := map[string]*ChangelogSection{}
+	seenIssues := map[string]bool{}
+
+	for _, commit := range commits {
+		entry := ChangelogEntry{Commit: commit}
+
+		seen := map[string]bool{}
+		for _, issue := range findIssues(opts, commit.Subject+"\n"+commit.Body) {
+			if !seen[issue] {
+				seen[issue] = true
+				entry.Issues = append(entry.Issues, issue)
+			}
+			if !seenIssues[issue] {
+				seenIssues[issue] = true
+				c.Issues = append(c.Issues, issue)
+			}
+		}
+
+		sectionType := changelogSectionType(commit)
+		section, ok := sections[sectionType]
+		if !ok {
+			section = &ChangelogSection{Type: sectionType}
+			sections[sectionType] = section
+		}
+		section.Entries = append(section.Entries, entry)
+	}
+
+	for _, s := range changelogSections {
+		if section, ok := sections[s.Type]; ok {
+			section.Title = s.Title
+			c.Sections = append(c.Sections, *section)
+		}
+	}
+
+	return c, nil
+}
+
+// findIssues returns the issue keys in text selected by opts.
+func findIssues(opts ChangelogOptions, text string) []string {
+	if len(opts.IssueProjects) > 0 {
+		var projects []string
+		for _, p := range opts.IssueProjects {
+			projects = append(projects, regexp.QuoteMeta(p))
+		}
+		return regexp.MustCompile(`\b(?:`+strings.Join(projects, "|")+`)-[1-9][0-9]*\b`).FindAllString(text, -1)
+	}
+	if opts.IssuePattern != nil {
+		return opts.IssuePattern.FindAllString(text, -1)
+	}
+
+	var issues []string
+	for _, issue := range DefaultIssuePattern.FindAllString(text, -1) {
+		if !notIssueProjects[issue[:strings.Index(issue, "-")]] {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+func changelogSectionType(commit Commit) string {
+	if commit.Breaking {
+		return "breaking"
+	}
+	for _, s := range changelogSections {
+		if s.Type == commit.Type && s.Type != "breaking" {
+			return s.Type
+		}
+	}
+	return "other"
+}
+
+// previousReleaseTag returns the highest release tag reachable from ref
+// which is not on ref itself, or an empty string if there is none.
+func previousReleaseTag(ctx context.Context, ref string) (string, error) {
+	commit, err := gitOutput(ctx, "rev-parse", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s: %s", ref, err)
+	}
+
+	out, err := gitOutput(ctx, "for-each-ref", "--merged", ref, "--format=%(refname:short) %(objectname) %(*objectname)", "refs/tags")
+	if err != nil {
+		return "", fmt.Errorf("could not list git tags: %s", err)
+	}
+
+	var (
+		previousTag string
+		previous    *semver.Version
+	)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// annotated tags have the commit they point at as a third field
+		tag, tagged := fields[0], fields[len(fields)-1]
+		if tagged == commit {
+			continue
+		}
+		version, err := semver.NewVersion(strings.TrimPrefix(tag, "v"))
+		if err != nil || version.PreRelease != "" {
+			continue
+		}
+		if previous == nil || previous.LessThan(*version) {
+			previousTag, previous = tag, version
+		}
+	}
+
+	return previousTag, nil
+}
+
+// Render renders the changelog as markdown, html or json.
+func (c Changelog) Render(format string) (string, error) {
+	switch format {
+	case ChangelogMarkdown, "md", "":
+		return c.Markdown(), nil
+	case ChangelogHTML:
+		return c.HTML()
+	case ChangelogJSON:
+		b, err := json.MarshalIndent(c, "", "  ")
+		return string(b), err
+	}
+	return "", fmt.Errorf("unknown changelog format %q (expected %s, %s or %s)", format, ChangelogMarkdown, ChangelogHTML, ChangelogJSON)
+}
+
+// String returns the changelog as markdown.
+func (c Changelog) String() string {
+	return c.Markdown()
+}
+
+// Markdown renders the changelog as markdown, suitable for release notes.
+func (c Changelog) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "## %s (%s)\n", c.Version, c.Date.Format("2006-01-02"))
+
+	for _, section := range c.Sections {
+		fmt.Fprintf(&b, "\n### %s\n\n", section.Title)
+		for _, e := range section.Entries {
+			b.WriteString("- ")
+			if e.Scope != "" {
+				fmt.Fprintf(&b, "**%s:** ", e.Scope)
+			}
+			b.WriteString(e.Description)
+			for _, issue := range e.Issues {
+				if c.IssueURL != "" {
+					fmt.Fprintf(&b, " [%s](%s%s)", issue, c.IssueURL, issue)
+				} else if !strings.Contains(e.Description, issue) {
+					b.WriteString(" " + issue)
+				}
+			}
+			fmt.Fprintf(&b, " (%s)\n", e.ShortHash())
+		}
+	}
+
+	if len(c.Sections) == 0 {
+		b.WriteString("\nNo changes.\n")
+	}
+
+	return b.String()
+}
+
+var changelogHTMLTemplate = template.Must(template.New("changelog").Parse(`<div>
+<h2>{{.Version}} ({{.Date.Format "2006-01-02"}})</h2>
+{{- if .Issues}}
+<p>Issues:{{range .Issues}} {{if $.IssueURL}}<a href="{{$.IssueURL}}{{.}}">{{.}}</a>{{else}}{{.}}{{end}}{{end}}</p>
+{{- end}}
+{{- range .Sections}}
+<h3>{{.Title}}</h3>
+<ul>
+{{- range .Entries}}
+<li>{{with .Scope}}<b>{{.}}:</b> {{end}}{{.Description}} <code>{{.ShortHash}}</code></li>
+{{- end}}
+</ul>
+{{- else}}
+<p>No changes.</p>
+{{- end}}
+</div>
+`))
+
+// HTML renders the changelog as an HTML fragment, suitable for notifications.
+func (c Changelog) HTML() (string, error) {
+	var buf bytes.Buffer
+	if err := changelogHTMLTemplate.Execute(&buf, c); err != nil {
+		return "", fmt.Errorf("could not render changelog: %s", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,220 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateChangelog(t *testing.T) {
+	messages := []string{
+		"feat(api): add search PROJ-12",
+		"fix: crash on SHA-256 sums\n\nFixes PROJ-7 and OPS-3, not UTF-8.",
+		"feat!: drop v1 PROJ-12",
+		"chore: update deps",
+		"Merge fixes for HTTP-2 and TLS-1",
+		"docs: explain AES-256",
+	}
+
+	tests := []struct {
+		name     string
+		opts     ChangelogOptions
+		sections map[string][]string
+		issues   []string
+	}{
+		{
+			name: "default pattern",
+			sections: map[string][]string{
+				"breaking": {"drop v1 PROJ-12"},
+				"feat":     {"add search PROJ-12"},
+				"fix":      {"crash on SHA-256 sums"},
+				"docs":     {"explain AES-256"},
+				"other":    {"update deps", "Merge fixes for HTTP-2 and TLS-1"},
+			},
+			issues: []string{"PROJ-12", "PROJ-7", "OPS-3"},
+		},
+		{
+			name:   "projects",
+			opts:   ChangelogOptions{IssueProjects: []string{"OPS", "HTTP"}},
+			issues: []string{"OPS-3", "HTTP-2"},
+		},
+		{
+			name:   "pattern",
+			opts:   ChangelogOptions{IssuePattern: regexp.MustCompile(`\b(?:SHA|UTF)-\d+\b`)},
+			issues: []string{"SHA-256", "UTF-8"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RecordingRunner{}
+			r.Respond("git log", gitLogOutput(messages...), nil)
+			tt.opts.From = "v1.0.0"
+
+			c, err := GenerateChangelogContext(WithRunner(context.Background(), r), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.Issues, tt.issues) {
+				t.Errorf("got issues %q, want %q", c.Issues, tt.issues)
+			}
+			if c.To != "HEAD" || c.Version != "HEAD" {
+				t.Errorf("got to %s and version %s, want HEAD", c.To, c.Version)
+			}
+			if tt.sections == nil {
+				return
+			}
+
+			var order []string
+			got := map[string][]string{}
+			for _, s := range c.Sections {
+				order = append(order, s.Type)
+				for _, e := range s.Entries {
+					got[s.Type] = append(got[s.Type], e.Description)
+				}
+			}
+			if want := []string{"breaking", "feat", "fix", "docs", "other"}; !reflect.DeepEqual(order, want) {
+				t.Errorf("got sections %v, want %v", order, want)
+			}
+			if !reflect.DeepEqual(got, tt.sections) {
+				t.Errorf("got entries\n%q\nwant\n%q", got, tt.sections)
+			}
+			if fix := c.Sections[2].Entries[0]; !reflect.DeepEqual(fix.Issues, []string{"PROJ-7", "OPS-3"}) {
+				t.Errorf("got fix issues %q, want the issues in its body", fix.Issues)
+			}
+		})
+	}
+}
+
+func TestGenerateChangelogFromPreviousTag(t *testing.T) {
+	const head = "cccccccccccccccccccccccccccccccccccccccc"
+	r := &RecordingRunner{}
+	r.Respond("git rev-parse", head, nil)
+	r.Respond("git for-each-ref", strings.Join([]string{
+		"v1.1.0 aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"v1.2.0 tttttttttttttttttttttttttttttttttttttttt bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+		"v1.3.0-rc.1 dddddddddddddddddddddddddddddddddddddddd",
+		"v1.3.0 " + head,
+		"latest eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee",
+	}, "\n"), nil)
+	r.Respond("git log", gitLogOutput("fix: a bug"), nil)
+
+	c, err := GenerateChangelogContext(WithRunner(context.Background(), r), ChangelogOptions{Version: "v1.3.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the tag on HEAD, prereleases and tags which are not versions are skipped
+	if c.From != "v1.2.0" {
+		t.Errorf("got from %q, want v1.2.0", c.From)
+	}
+	lines := r.Lines()
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, " v1.2.0..HEAD") {
+		t.Errorf("read commits with %q, want them since v1.2.0", last)
+	}
+}
+
+func testChangelog() Changelog {
+	return Changelog{
+		Version: "v1.3.0",
+		To:      "HEAD",
+		Date:    time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC),
+		Sections: []ChangelogSection{
+			{Type: "feat", Title: "Features", Entries: []ChangelogEntry{
+				{Commit: Commit{Hash: "0123456789abcdef", Scope: "api", Description: "add <search>"}, Issues: []string{"PROJ-12"}},
+			}},
+			{Type: "fix", Title: "Bug Fixes", Entries: []ChangelogEntry{
+				{Commit: Commit{Hash: "abc", Description: "fix PROJ-7"}, Issues: []string{"PROJ-7", "OPS-3"}},
+			}},
+		},
+		Issues: []string{"PROJ-12", "PROJ-7", "OPS-3"},
+	}
+}
+
+func TestChangelogRender(t *testing.T) {
+	linked := testChangelog()
+	linked.IssueURL = "https://jira/browse/"
+
+	tests := []struct {
+		name   string
+		c      Changelog
+		format string
+		want   string
+	}{
+		{
+			name:   "markdown",
+			c:      testChangelog(),
+			format: ChangelogMarkdown,
+			// issues already in the description are not repeated
+			want: "## v1.3.0 (2020-09-13)\n\n### Features\n\n- **api:** add <search> PROJ-12 (0123456)\n\n### Bug Fixes\n\n- fix PROJ-7 OPS-3 (abc)\n",
+		},
+		{
+			name:   "markdown links",
+			c:      linked,
+			format: "md",
+			want: "## v1.3.0 (2020-09-13)\n\n### Features\n\n- **api:** add <search> [PROJ-12](https://jira/browse/PROJ-12) (0123456)\n\n" +
+				"### Bug Fixes\n\n- fix PROJ-7 [PROJ-7](https://jira/browse/PROJ-7) [OPS-3](https://jira/browse/OPS-3) (abc)\n",
+		},
+		{
+			name:   "markdown empty",
+			c:      Changelog{Version: "v1.3.0", Date: time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)},
+			format: "",
+			want:   "## v1.3.0 (2020-09-13)\n\nNo changes.\n",
+		},
+		{
+			name:   "html",
+			c:      linked,
+			format: ChangelogHTML,
+			want: `<div>
+<h2>v1.3.0 (2020-09-13)</h2>
+<p>Issues: <a href="https://jira/browse/PROJ-12">PROJ-12</a> <a href="https://jira/browse/PROJ-7">PROJ-7</a> <a href="https://jira/browse/OPS-3">OPS-3</a></p>
+<h3>Features</h3>
+<ul>
+<li><b>api:</b> add &lt;search&gt; <code>0123456</code></li>
+</ul>
+<h3>Bug Fixes</h3>
+<ul>
+<li>fix PROJ-7 <code>abc</code></li>
+</ul>
+</div>
+`,
+		},
+		{
+			name:   "html empty",
+			c:      Changelog{Version: "v1.3.0", Date: time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)},
+			format: ChangelogHTML,
+			want:   "<div>\n<h2>v1.3.0 (2020-09-13)</h2>\n<p>No changes.</p>\n</div>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.c.Render(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	out, err := testChangelog().Render(ChangelogJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Changelog
+	if err = json.Unmarshal([]byte(out), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, testChangelog()) {
+		t.Errorf("got %+v from the JSON, want %+v", decoded, testChangelog())
+	}
+
+	if _, err = testChangelog().Render("pdf"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,102 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Commit is a commit read from the git history, with its message parsed
+// as a conventional commit (https://www.conventionalcommits.org).
+// Commits which do not follow the convention have an empty Type.
+type Commit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body,omitempty"`
+	// Type is the conventional commit type, such as "feat" or "fix", in lower case.
+	Type  string `json:"type,omitempty"`
+	Scope string `json:"scope,omitempty"`
+	// Description is the subject without the type and scope.
+	Description string `json:"description"`
+	// Breaking is true if the type is followed by "!" or the
+	// body has a BREAKING CHANGE footer.
+	Breaking bool `json:"breaking,omitempty"`
+}
+
+var conventionalHeader = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+
+var breakingFooter = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE:`)
+
+// ParseCommitMessage parses a commit message as a conventional commit.
+// Only the Subject, Body, Type, Scope, Description and Breaking fields are set.
+func ParseCommitMessage(message string) Commit {
+	message = strings.TrimSpace(message)
+	subject, body := message, ""
+	if i := strings.Index(message, "\n"); i >= 0 {
+		subject, body = strings.TrimSpace(message[:i]), strings.TrimSpace(message[i+1:])
+	}
+
+	c := Commit{
+		Subject:     subject,
+		Body:        body,
+		Description: subject,
+		Breaking:    breakingFooter.MatchString(body),
+	}
+
+	if m := conventionalHeader.FindStringSubmatch(subject); m != nil {
+		c.Type = strings.ToLower(m[1])
+		c.Scope = m[2]
+		c.Breaking = c.Breaking || m[3] == "!"
+		c.Description = m[4]
+	}
+
+	return c
+}
+
+const (
+	commitFieldSep  = "\x1f"
+	commitRecordSep = "\x1e"
+)
+
+// GitCommits returns the commits reachable from to but not from from,
+// newest first. If from is empty, all commits reachable from to are returned.
+// Merge commits are skipped.
+func GitCommits(from, to string) ([]Commit, error) {
+	return GitCommitsContext(context.Background(), from, to)
+}
+
+func GitCommitsContext(ctx context.Context, from, to string) ([]Commit, error) {
+	revs := to
+	if from != "" {
+		revs = from + ".." + to
+	}
+
+	out, err := gitOutput(ctx, "log", "--no-merges",
+		"--format=%H"+commitFieldSep+"%an"+commitFieldSep+"%ct"+commitFieldSep+"%B"+commitRecordSep, revs)
+	if err != nil {
+		return nil, fmt.Errorf("could not read commits in %s: %s", revs, err)
+	}
+
+	var commits []Commit
+	for _, record := range strings.Split(out, commitRecordSep) {
+		fields := strings.SplitN(strings.TrimSpace(record), commitFieldSep, 4)
+		if len(fields) != 4 {
+			continue
+		}
+
+		c := ParseCommitMessage(fields[3])
+		c.Hash = fields[0]
+		c.Author = fields[1]
+		var seconds int64
+		if _, err := fmt.Sscan(fields[2], &seconds); err == nil {
+			c.Time = time.Unix(seconds, 0).UTC()
+		}
+		commits = append(commits, c)
+	}
+
+	return commits, nil
+}
@@ -0,0 +1,44 @@
+package build
+
+import (
+	"testing"
+)
+
+func TestParseCommitMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		want    Commit
+	}{
+		{
+			message: "feat: add targets",
+			want:    Commit{Subject: "feat: add targets", Type: "feat", Description: "add targets"},
+		},
+		{
+			message: "fix(build): handle cgo\n\nCloses #12\n",
+			want:    Commit{Subject: "fix(build): handle cgo", Body: "Closes #12", Type: "fix", Scope: "build", Description: "handle cgo"},
+		},
+		{
+			message: "Feat!: drop go 1.10",
+			want:    Commit{Subject: "Feat!: drop go 1.10", Type: "feat", Description: "drop go 1.10", Breaking: true},
+		},
+		{
+			message: "refactor(config): rename fields\n\nBREAKING CHANGE: plugin.files is now a list",
+			want: Commit{Subject: "refactor(config): rename fields", Body: "BREAKING CHANGE: plugin.files is now a list",
+				Type: "refactor", Scope: "config", Description: "rename fields", Breaking: true},
+		},
+		{
+			message: "Update README",
+			want:    Commit{Subject: "Update README", Description: "Update README"},
+		},
+		{
+			message: "Merge branch 'master': sync",
+			want:    Commit{Subject: "Merge branch 'master': sync", Description: "Merge branch 'master': sync"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := ParseCommitMessage(tt.message); got != tt.want {
+			t.Errorf("ParseCommitMessage(%q) = %#v, want %#v", tt.message, got, tt.want)
+		}
+	}
+}
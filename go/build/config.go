@@ -0,0 +1,559 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectConfigFiles are the file names LoadProjectConfig looks for
+// when it is not given a path, in order of preference.
+var ProjectConfigFiles = []string{"ci.yaml", "ci.yml", "ci.json"}
+
+// ProjectConfig is the declarative description of a project's build,
+// normally loaded from ci.yaml. JSON files are also accepted.
+//
+// A minimal ci.yaml looks like:
+//
+//	packages:
+//	  - name: helloworld
+//	    version: ${MAJOR_VERSION}.${MINOR_VERSION}.${BUILD_NUMBER}
+//	    targets: [linux/amd64, linux/arm/v7, windows/amd64]
+//	    overrides:
+//	      - targets: [linux]
+//	        cgoEnabled: true
+//	    plugin:
+//	      files: [icon.png]
+//	docker:
+//	  repo: docker.n5o.black/private
+//	s3:
+//	  bucket: naveego-releases
+//	  serviceID: helloworld
+type ProjectConfig struct {
+	Packages []PackageConfig `yaml:"packages" json:"packages"`
+	Docker   DockerConfig    `yaml:"docker" json:"docker"`
+	S3       S3Config        `yaml:"s3" json:"s3"`
+	Release  ReleaseConfig   `yaml:"release" json:"release"`
+}
+
+// PackageConfig describes a Package, the targets it is built for
+// and, if it is a plugin, the files included in the plugin zip.
+type PackageConfig struct {
+	Name string `yaml:"name" json:"name"`
+	// Version is a semantic version. Environment variables in the form $VAR
+	// or ${VAR} are expanded when the config is loaded.
+	Version      string           `yaml:"version" json:"version"`
+	PackagePath  string           `yaml:"packagePath" json:"packagePath"`
+	Main         string           `yaml:"main" json:"main"`
+	OutDir       string           `yaml:"outDir" json:"outDir"`
+	OutTemplate  string           `yaml:"outTemplate" json:"outTemplate"`
+	DockerRepo   string           `yaml:"dockerRepo" json:"dockerRepo"`
+	BuildArgs    []string         `yaml:"buildArgs" json:"buildArgs"`
+	CGOEnabled   bool             `yaml:"cgoEnabled" json:"cgoEnabled"`
+	Parallelism  int              `yaml:"parallelism" json:"parallelism"`
+	Reproducible bool             `yaml:"reproducible" json:"reproducible"`
+	Sign         bool             `yaml:"sign" json:"sign"`
+	SBOM         string           `yaml:"sbom" json:"sbom"`
+	Licenses     *LicenseConfig   `yaml:"licenses" json:"licenses"`
+	Targets      []string         `yaml:"targets" json:"targets"`
+	Overrides    []OverrideConfig `yaml:"overrides" json:"overrides"`
+	Plugin       *PluginFiles     `yaml:"plugin" json:"plugin"`
+}
+
+// OverrideConfig describes a TargetOverride.
+type OverrideConfig struct {
+	Targets    []string          `yaml:"targets" json:"targets"`
+	Tags       []string          `yaml:"tags" json:"tags"`
+	Ldflags    []string          `yaml:"ldflags" json:"ldflags"`
+	Env        map[string]string `yaml:"env" json:"env"`
+	CGOEnabled *bool             `yaml:"cgoEnabled" json:"cgoEnabled"`
+	OutName    string            `yaml:"outName" json:"outName"`
+}
+
+// PluginFiles lists the extra files included in a plugin's package.zip.
+type PluginFiles struct {
+	Files []string `yaml:"files" json:"files"`
+	// IncludeSBOM adds the SBOM of each target to its package.zip.
+	IncludeSBOM bool `yaml:"includeSBOM" json:"includeSBOM"`
+	// IncludeNotices adds the third party notices of each target to its package.zip.
+	IncludeNotices bool `yaml:"includeNotices" json:"includeNotices"`
+	// Icon controls how the manifest's icon is checked and resized.
+	Icon IconConfig `yaml:"icon" json:"icon"`
+}
+
+// IconConfig describes the IconOptions of a plugin.
+type IconConfig struct {
+	MaxSize  int  `yaml:"maxSize" json:"maxSize"`
+	MaxBytes int  `yaml:"maxBytes" json:"maxBytes"`
+	Resize   bool `yaml:"resize" json:"resize"`
+}
+
+// ToIconOptions converts the config into IconOptions.
+func (c IconConfig) ToIconOptions() IconOptions {
+	return IconOptions{MaxSize: c.MaxSize, MaxBytes: c.MaxBytes, Resize: c.Resize}
+}
+
+// LicenseConfig is the license policy checked when the package is built; see LicenseOptions.
+type LicenseConfig struct {
+	Deny       []string `yaml:"deny" json:"deny"`
+	Exceptions []string `yaml:"exceptions" json:"exceptions"`
+}
+
+// DockerConfig holds the default docker settings for the project.
+// Everything but Repo describes the image built by a release; see DockerOptions.
+type DockerConfig struct {
+	Repo       string   `yaml:"repo" json:"repo"`
+	Dockerfile string   `yaml:"dockerfile" json:"dockerfile"`
+	Target     string   `yaml:"target" json:"target"`
+	Tags       []string `yaml:"tags" json:"tags"`
+	Files      []string `yaml:"files" json:"files"`
+	BuildFlags []string `yaml:"buildFlags" json:"buildFlags"`
+}
+
+// S3Config describes where release artifacts are uploaded.
+type S3Config struct {
+	Bucket string `yaml:"bucket" json:"bucket"`
+	// ServiceID is the {serviceID} segment of ToS3ReleasePath.
+	ServiceID string `yaml:"serviceID" json:"serviceID"`
+}
+
+// ReleaseConfig describes which package is released and for which targets.
+type ReleaseConfig struct {
+	// Package is the name of the package to release, which is also the one
+	// built when no package is named. It may be omitted if there is only one package.
+	Package string        `yaml:"package" json:"package"`
+	Targets []string      `yaml:"targets" json:"targets"`
+	Archive ArchiveConfig `yaml:"archive" json:"archive"`
+	// Dir is the directory the release is written to, by default DefaultReleaseDir.
+	Dir string `yaml:"dir" json:"dir"`
+}
+
+// ArchiveConfig describes ArchiveOptions.
+type ArchiveConfig struct {
+	NameTemplate string            `yaml:"nameTemplate" json:"nameTemplate"`
+	Replacements map[string]string `yaml:"replacements" json:"replacements"`
+	Files        []string          `yaml:"files" json:"files"`
+	Flat         bool              `yaml:"flat" json:"flat"`
+}
+
+// ConfigError is a problem found in a config file, with its position.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e ConfigError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	default:
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+}
+
+// ConfigErrors is every problem found in a config file.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// LoadProjectConfig reads and validates the project config at path. If path is
+// empty, the first of ProjectConfigFiles which exists is used. Problems with the
+// file are returned as ConfigErrors.
+func LoadProjectConfig(path string) (*ProjectConfig, error) {
+	if path == "" {
+		for _, name := range ProjectConfigFiles {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+		if path == "" {
+			return nil, fmt.Errorf("no project config found, expected one of %s", strings.Join(ProjectConfigFiles, ", "))
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseProjectConfig(path, data)
+}
+
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// ParseProjectConfig parses and validates a project config. The file name is
+// only used in error messages.
+func ParseProjectConfig(file string, data []byte) (*ProjectConfig, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, yamlConfigErrors(file, err)
+	}
+
+	var cfg ProjectConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, yamlConfigErrors(file, err)
+	}
+
+	for i := range cfg.Packages {
+		cfg.Packages[i].Version = os.ExpandEnv(cfg.Packages[i].Version)
+	}
+
+	v := configValidator{file: file, root: &root}
+	v.validate(&cfg)
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+
+	return &cfg, nil
+}
+
+// yamlConfigErrors converts the errors reported by the yaml package,
+// which include line numbers in their messages, to ConfigErrors.
+func yamlConfigErrors(file string, err error) error {
+	var lines []string
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		lines = typeErr.Errors
+	} else {
+		lines = []string{err.Error()}
+	}
+
+	var errs ConfigErrors
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if m := yamlErrorLine.FindStringSubmatch(l); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			errs = append(errs, ConfigError{File: file, Line: line, Msg: m[2]})
+		} else {
+			errs = append(errs, ConfigError{File: file, Msg: strings.TrimPrefix(l, "yaml: ")})
+		}
+	}
+	return errs
+}
+
+type configValidator struct {
+	file string
+	root *yaml.Node
+	errs ConfigErrors
+}
+
+// errorf records an error at the position of the node found by following
+// the keys and indexes in at. If that node does not exist the position of its
+// closest ancestor is used.
+func (v *configValidator) errorf(at []interface{}, format string, args ...interface{}) {
+	node := v.root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, step := range at {
+		next := childNode(node, step)
+		if next == nil {
+			break
+		}
+		node = next
+	}
+
+	v.errs = append(v.errs, ConfigError{
+		File:   v.file,
+		Line:   node.Line,
+		Column: node.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+func childNode(node *yaml.Node, step interface{}) *yaml.Node {
+	switch s := step.(type) {
+	case string:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == s {
+				return node.Content[i+1]
+			}
+		}
+	case int:
+		if node.Kind == yaml.SequenceNode && s < len(node.Content) {
+			return node.Content[s]
+		}
+	}
+	return nil
+}
+
+func (v *configValidator) validate(cfg *ProjectConfig) {
+	if len(cfg.Packages) == 0 {
+		v.errorf(nil, "at least one package is required")
+	}
+
+	names := map[string]bool{}
+	for i, p := range cfg.Packages {
+		at := []interface{}{"packages", i}
+		v.validatePackage(at, p)
+		if p.Name != "" && names[p.Name] {
+			v.errorf(append(at, "name"), "duplicate package name %q", p.Name)
+		}
+		names[p.Name] = true
+	}
+
+	if cfg.S3.ServiceID != "" && cfg.S3.Bucket == "" {
+		v.errorf([]interface{}{"s3"}, "s3.bucket is required when s3.serviceID is set")
+	}
+
+	release := []interface{}{"release"}
+	if cfg.Release.Package != "" && !names[cfg.Release.Package] {
+		v.errorf(append(release, "package"), "release package %q is not defined in packages", cfg.Release.Package)
+	}
+	if cfg.Release.Package == "" && len(cfg.Packages) > 1 {
+		v.errorf(release, "release.package is required when there is more than one package")
+	}
+	v.validateTargets(append(release, "targets"), cfg.Release.Targets)
+	v.validateNameTemplate(append(release, "archive", "nameTemplate"), cfg.Release.Archive.NameTemplate)
+
+	docker := []interface{}{"docker"}
+	if cfg.Docker.Target != "" {
+		if _, err := ParseTarget(cfg.Docker.Target); err != nil {
+			v.errorf(append(docker, "target"), "%s", err)
+		}
+	}
+	for i, tag := range cfg.Docker.Tags {
+		v.validateNameTemplate(append(docker, "tags", i), tag)
+	}
+}
+
+func (v *configValidator) validateNameTemplate(at []interface{}, tmpl string) {
+	if tmpl == "" {
+		return
+	}
+	if _, err := renderReleaseName(tmpl, "", ArchiveName{}); err != nil {
+		v.errorf(at, "%s", err)
+	}
+}
+
+func (v *configValidator) validatePackage(at []interface{}, p PackageConfig) {
+	field := func(name string, rest ...interface{}) []interface{} {
+		return append(append(append([]interface{}{}, at...), name), rest...)
+	}
+
+	if p.Name == "" {
+		v.errorf(at, "package name is required")
+	}
+
+	if p.Version == "" {
+		v.errorf(field("version"), "package version is required")
+	} else if _, err := semver.NewVersion(strings.TrimPrefix(p.Version, "v")); err != nil {
+		v.errorf(field("version"), "invalid version %q: %s", p.Version, err)
+	}
+
+	if p.Parallelism < 0 {
+		v.errorf(field("parallelism"), "parallelism must not be negative")
+	}
+
+	switch p.SBOM {
+	case "", SBOMCycloneDX, SBOMSPDX:
+	default:
+		v.errorf(field("sbom"), "unknown SBOM format %q (expected %s or %s)", p.SBOM, SBOMCycloneDX, SBOMSPDX)
+	}
+
+	if p.Licenses != nil {
+		for i, pattern := range p.Licenses.Deny {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.errorf(field("licenses", "deny", i), "invalid license pattern %q: %s", pattern, err)
+			}
+		}
+		for i, pattern := range p.Licenses.Exceptions {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.errorf(field("licenses", "exceptions", i), "invalid module pattern %q: %s", pattern, err)
+			}
+		}
+	}
+
+	v.validateTargets(field("targets"), p.Targets)
+
+	for i, o := range p.Overrides {
+		for j, pattern := range o.Targets {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.errorf(field("overrides", i, "targets", j), "invalid target pattern %q: %s", pattern, err)
+			}
+		}
+	}
+
+	if p.Plugin != nil {
+		for i, file := range p.Plugin.Files {
+			if file == "" {
+				v.errorf(field("plugin", "files", i), "plugin file must not be empty")
+			}
+		}
+		if p.Plugin.Icon.MaxSize < 0 {
+			v.errorf(field("plugin", "icon", "maxSize"), "icon maxSize must not be negative")
+		}
+		if p.Plugin.Icon.MaxBytes < 0 {
+			v.errorf(field("plugin", "icon", "maxBytes"), "icon maxBytes must not be negative")
+		}
+	}
+}
+
+func (v *configValidator) validateTargets(at []interface{}, targets []string) {
+	for i, s := range targets {
+		if _, err := ParseTarget(s); err != nil {
+			v.errorf(append(append([]interface{}{}, at...), i), "%s", err)
+		}
+	}
+}
+
+// FindPackage returns the config of the package with the given name.
+func (c *ProjectConfig) FindPackage(name string) (PackageConfig, bool) {
+	for _, p := range c.Packages {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return PackageConfig{}, false
+}
+
+// ReleasePackage returns the config of the package named by Release.Package,
+// or the only package if it is not set.
+func (c *ProjectConfig) ReleasePackage() (PackageConfig, error) {
+	if c.Release.Package != "" {
+		if p, ok := c.FindPackage(c.Release.Package); ok {
+			return p, nil
+		}
+		return PackageConfig{}, fmt.Errorf("release package %q is not defined", c.Release.Package)
+	}
+	if len(c.Packages) != 1 {
+		return PackageConfig{}, fmt.Errorf("release.package must be set when there are %d packages", len(c.Packages))
+	}
+	return c.Packages[0], nil
+}
+
+// ToPackage converts the config into a Package, starting from the
+// defaults set by NewPackage and using the project's docker settings.
+func (c *ProjectConfig) ToPackage(p PackageConfig) Package {
+	version, _ := semver.NewVersion(strings.TrimPrefix(p.Version, "v"))
+	if version == nil {
+		version = &semver.Version{}
+	}
+
+	pkg := NewPackage(p.Name, *version)
+
+	if p.PackagePath != "" {
+		pkg.PackagePath = p.PackagePath
+		pkg.VersionVars = DefaultVersionVars(p.PackagePath)
+	}
+	if p.Main != "" {
+		pkg.Main = p.Main
+	}
+	if p.OutDir != "" {
+		pkg.OutDir = p.OutDir
+	}
+	if c.Docker.Repo != "" {
+		pkg.DockerRepo = c.Docker.Repo
+	}
+	if p.DockerRepo != "" {
+		pkg.DockerRepo = p.DockerRepo
+	}
+
+	pkg.Docker = DockerOptions{
+		Dockerfile: c.Docker.Dockerfile,
+		Tags:       c.Docker.Tags,
+		Files:      c.Docker.Files,
+		BuildFlags: c.Docker.BuildFlags,
+	}
+	if c.Docker.Target != "" {
+		pkg.Docker.Target, _ = ParseTarget(c.Docker.Target)
+	}
+	pkg.Archive = ArchiveOptions{
+		NameTemplate: c.Release.Archive.NameTemplate,
+		Replacements: c.Release.Archive.Replacements,
+		Files:        c.Release.Archive.Files,
+		Flat:         c.Release.Archive.Flat,
+		Dir:          c.Release.Dir,
+	}
+
+	pkg.OutTemplate = p.OutTemplate
+	pkg.BuildArgs = p.BuildArgs
+	pkg.CGOEnabled = p.CGOEnabled
+	pkg.Parallelism = p.Parallelism
+	pkg.Reproducible = p.Reproducible
+	pkg.Sign = p.Sign
+	pkg.SBOM = p.SBOM
+	if p.Licenses != nil {
+		pkg.Licenses = &LicenseOptions{
+			Deny:       p.Licenses.Deny,
+			Exceptions: p.Licenses.Exceptions,
+		}
+	}
+
+	for _, o := range p.Overrides {
+		pkg.Overrides = append(pkg.Overrides, TargetOverride{
+			Targets:    o.Targets,
+			Tags:       o.Tags,
+			Ldflags:    o.Ldflags,
+			Env:        o.Env,
+			CGOEnabled: o.CGOEnabled,
+			OutName:    o.OutName,
+		})
+	}
+
+	return pkg
+}
+
+// ToTargets returns the package's targets, or DefaultPackageTargets if none are configured.
+func (p PackageConfig) ToTargets() []PackageTarget {
+	if len(p.Targets) == 0 {
+		return DefaultPackageTargets
+	}
+	targets, _ := ParseTargets(p.Targets...)
+	return targets
+}
+
+// ToPluginConfig converts the config into a PluginConfig. It returns
+// false if the package is not configured as a plugin.
+func (c *ProjectConfig) ToPluginConfig(p PackageConfig) (PluginConfig, bool) {
+	if p.Plugin == nil {
+		return PluginConfig{}, false
+	}
+	return PluginConfig{
+		Package:        c.ToPackage(p),
+		Targets:        p.ToTargets(),
+		Files:          p.Plugin.Files,
+		IncludeSBOM:    p.Plugin.IncludeSBOM,
+		IncludeNotices: p.Plugin.IncludeNotices,
+		Icon:           p.Plugin.Icon.ToIconOptions(),
+	}, true
+}
+
+// ReleaseTargets returns the targets configured for release, falling back to
+// the targets of the release package and then DefaultReleaseTargets.
+func (c *ProjectConfig) ReleaseTargets() ([]PackageTarget, error) {
+	if len(c.Release.Targets) > 0 {
+		return ParseTargets(c.Release.Targets...)
+	}
+	p, err := c.ReleasePackage()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Targets) == 0 {
+		return DefaultReleaseTargets, nil
+	}
+	return p.ToTargets(), nil
+}
@@ -0,0 +1,127 @@
+package build
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProjectConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want ConfigErrors
+	}{
+		{
+			name: "valid",
+			yaml: `
+packages:
+  - name: hello
+    version: 1.2.3
+    targets: [linux/amd64, linux/arm/v7]
+`,
+		},
+		{
+			name: "no packages",
+			yaml: `
+docker:
+  repo: docker.example.com
+`,
+			want: ConfigErrors{{File: "ci.yaml", Line: 2, Column: 1, Msg: "at least one package is required"}},
+		},
+		{
+			name: "missing fields point at the package",
+			yaml: `
+packages:
+  - targets: [linux/amd64]
+`,
+			want: ConfigErrors{
+				{File: "ci.yaml", Line: 3, Column: 5, Msg: "package name is required"},
+				{File: "ci.yaml", Line: 3, Column: 5, Msg: "package version is required"},
+			},
+		},
+		{
+			name: "invalid values point at the value",
+			yaml: `
+packages:
+  - name: hello
+    version: one
+    parallelism: -1
+    targets:
+      - linux/amd64
+      - linux/arm/v9
+`,
+			want: ConfigErrors{
+				{File: "ci.yaml", Line: 4, Column: 14, Msg: `invalid version "one": one is not in dotted-tri format`},
+				{File: "ci.yaml", Line: 5, Column: 18, Msg: "parallelism must not be negative"},
+				{File: "ci.yaml", Line: 8, Column: 9, Msg: `invalid target linux/arm/v9: unknown variant "v9" for architecture "arm"`},
+			},
+		},
+		{
+			name: "duplicate package",
+			yaml: `
+packages:
+  - name: hello
+    version: 1.0.0
+  - name: hello
+    version: 1.0.0
+release:
+  package: hello
+`,
+			want: ConfigErrors{{File: "ci.yaml", Line: 5, Column: 11, Msg: `duplicate package name "hello"`}},
+		},
+		{
+			// ReleasePackage needs it whenever there is more than one package
+			name: "several packages without a release package",
+			yaml: `
+packages:
+  - name: hello
+    version: 1.0.0
+  - name: goodbye
+    version: 1.0.0
+release:
+  archive:
+    flat: true
+`,
+			want: ConfigErrors{{File: "ci.yaml", Line: 8, Column: 3, Msg: "release.package is required when there is more than one package"}},
+		},
+		{
+			name: "unknown field",
+			yaml: `
+packages:
+  - name: hello
+    version: 1.0.0
+    colour: blue
+`,
+			want: ConfigErrors{{File: "ci.yaml", Line: 5, Msg: "field colour not found in type build.PackageConfig"}},
+		},
+		{
+			name: "syntax error",
+			yaml: `
+packages:
+  - name: hello
+    version: 1.0.0
+  targets
+`,
+			want: ConfigErrors{{File: "ci.yaml", Line: 5, Msg: "could not find expected ':'"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseProjectConfig("ci.yaml", []byte(tt.yaml))
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			errs, ok := err.(ConfigErrors)
+			if !ok {
+				t.Fatalf("got %T %v, want ConfigErrors", err, err)
+			}
+			if !reflect.DeepEqual(errs, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", errs, tt.want)
+			}
+		})
+	}
+}
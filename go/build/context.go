@@ -0,0 +1,63 @@
+package build
+
+import (
+	"context"
+	"time"
+)
+
+// Step names a kind of operation which can be given its own timeout.
+type Step string
+
+const (
+	StepBuild           Step = "build"
+	StepPostBuild       Step = "post-build"
+	StepTest            Step = "test"
+	StepIntegrationTest Step = "integration-test"
+	StepDockerTag       Step = "docker-tag"
+	StepDockerPush      Step = "docker-push"
+	StepUpload          Step = "upload"
+	StepRelease         Step = "release"
+	StepGit             Step = "git"
+	StepTools           Step = "tools"
+	StepSign            Step = "sign"
+)
+
+// Timeouts limits how long each run of a Step may take.
+// Steps which are not present are not limited.
+type Timeouts map[Step]time.Duration
+
+type contextKey int
+
+const (
+	timeoutsKey contextKey = iota
+	runnerKey
+	loggerKey
+)
+
+// WithTimeouts returns a context which applies timeouts to the steps of
+// operations it is passed to, in addition to any deadline ctx already has.
+func WithTimeouts(ctx context.Context, timeouts Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey, timeouts)
+}
+
+// WithRunner returns a context which makes the operations it is passed to
+// use r instead of the runner set with SetRunner.
+func WithRunner(ctx context.Context, r Runner) context.Context {
+	return context.WithValue(ctx, runnerKey, r)
+}
+
+func runnerFrom(ctx context.Context) Runner {
+	if r, ok := ctx.Value(runnerKey).(Runner); ok && r != nil {
+		return r
+	}
+	return CurrentRunner()
+}
+
+// stepContext returns a context limited by the timeout configured for step, if any.
+func stepContext(ctx context.Context, step Step) (context.Context, context.CancelFunc) {
+	timeouts, _ := ctx.Value(timeoutsKey).(Timeouts)
+	if d := timeouts[step]; d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,129 @@
+package build
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingRunner records commands like a RecordingRunner, but the commands
+// starting with block write a partial output and then wait for their context
+// to be done, like a slow go build which is killed.
+type blockingRunner struct {
+	RecordingRunner
+	block string
+}
+
+func (r *blockingRunner) Run(ctx context.Context, cmd Command) (string, error) {
+	out, err := r.RecordingRunner.Run(ctx, cmd)
+	if !strings.HasPrefix(cmd.argv(), r.block) {
+		return out, err
+	}
+	for i, a := range cmd.Args {
+		if a == "-o" {
+			ioutil.WriteFile(cmd.Args[i+1], []byte("partial"), 0755)
+		}
+	}
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestStepContext(t *testing.T) {
+	ctx := WithTimeouts(context.Background(), Timeouts{StepBuild: time.Hour, StepTest: 0})
+
+	buildCtx, cancel := stepContext(ctx, StepBuild)
+	defer cancel()
+	if deadline, ok := buildCtx.Deadline(); !ok || time.Until(deadline) > time.Hour {
+		t.Errorf("got deadline %v, %t, want one within an hour", deadline, ok)
+	}
+
+	for _, step := range []Step{StepTest, StepUpload} {
+		stepCtx, cancel := stepContext(ctx, step)
+		if _, ok := stepCtx.Deadline(); ok {
+			t.Errorf("%s has a deadline, want none", step)
+		}
+		cancel()
+		if stepCtx.Err() != context.Canceled {
+			t.Errorf("%s: got %v after cancel, want it cancelled", step, stepCtx.Err())
+		}
+	}
+
+	// an earlier deadline from the parent is kept
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelParent()
+	stepCtx, cancel := stepContext(WithTimeouts(parent, Timeouts{StepBuild: time.Hour}), StepBuild)
+	defer cancel()
+	want, _ := parent.Deadline()
+	if got, _ := stepCtx.Deadline(); !got.Equal(want) {
+		t.Errorf("got deadline %v, want the parent's %v", got, want)
+	}
+}
+
+func TestWithRunner(t *testing.T) {
+	r := &RecordingRunner{}
+	if got := runnerFrom(WithRunner(context.Background(), r)); got != r {
+		t.Errorf("got runner %T, want the context's", got)
+	}
+	if got := runnerFrom(context.Background()); got != CurrentRunner() {
+		t.Errorf("got runner %T, want the current runner", got)
+	}
+	if got := runnerFrom(WithRunner(context.Background(), nil)); got != CurrentRunner() {
+		t.Errorf("got runner %T for nil, want the current runner", got)
+	}
+
+	previous := SetRunner(r)
+	defer SetRunner(previous)
+	if err := run(context.Background(), "git", "status"); err != nil {
+		t.Fatal(err)
+	}
+	if lines := r.Lines(); !reflect.DeepEqual(lines, []string{"git status"}) {
+		t.Errorf("ran %v on the runner set with SetRunner", lines)
+	}
+}
+
+// TestBuildTimeout checks a go build which takes longer than the StepBuild
+// timeout is stopped, and that its partial output is removed.
+func TestBuildTimeout(t *testing.T) {
+	pkg, cleanup := newTestPackage(t)
+	defer cleanup()
+
+	r := &blockingRunner{block: "go build"}
+	r.Respond("go tool dist list", testPlatforms, nil)
+	ctx := WithTimeouts(WithRunner(context.Background(), r), Timeouts{StepBuild: 50 * time.Millisecond})
+
+	artifact, err := BuildPackageContext(ctx, pkg, TargetLinuxAmd64)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, statErr := os.Stat(artifact.Path); !os.IsNotExist(statErr) {
+		t.Errorf("partial output %s was left behind: %v", artifact.Path, statErr)
+	}
+}
+
+func TestIntegrationTestsCleanUpWhenCancelled(t *testing.T) {
+	r := &blockingRunner{block: "docker-compose -p it -f docker-compose.yml up"}
+	ctx, cancel := context.WithCancel(WithRunner(context.Background(), r))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	err := RunIntegrationTestsInDockerContext(ctx, "it", "docker-compose.yml")
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+
+	want := []string{
+		"docker-compose -p it -f docker-compose.yml build",
+		"docker-compose -p it -f docker-compose.yml up -d",
+		"docker-compose -p it -f docker-compose.yml kill",
+		"docker-compose -p it -f docker-compose.yml rm -f",
+	}
+	if lines := r.Lines(); !reflect.DeepEqual(lines, want) {
+		t.Errorf("ran\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -1,10 +1,8 @@
 package build
 
 import (
+	"context"
 	"fmt"
-
-	// mg contains helpful utility functions, like Deps
-	"github.com/magefile/mage/sh"
 )
 
 // TagAndPushDockerImages uses the environment parameters
@@ -19,6 +17,12 @@ import (
 // This task returns a slice containing the deployed images, in order from
 // most specific to least specific.
 func TagAndPushDockerImages(sourceImage, imageName, imageTagPrefix, buildNumber, majorVersion, minorVersion string) ([]string, error) {
+	return TagAndPushDockerImagesContext(context.Background(), sourceImage, imageName, imageTagPrefix, buildNumber, majorVersion, minorVersion)
+}
+
+// TagAndPushDockerImagesContext is TagAndPushDockerImages with a context.
+// Each tag and push is limited by the StepDockerTag and StepDockerPush timeouts.
+func TagAndPushDockerImagesContext(ctx context.Context, sourceImage, imageName, imageTagPrefix, buildNumber, majorVersion, minorVersion string) ([]string, error) {
 	var (
 		err error
 	)
@@ -31,12 +35,18 @@ func TagAndPushDockerImages(sourceImage, imageName, imageTagPrefix, buildNumber,
 	}
 
 	for _, name := range images {
-		err = sh.Run("docker", "tag", sourceImage, name)
+		step := beginStep(ctx, StepDockerTag, Fields{"source": sourceImage}, "tagging %s", name)
+		tagCtx, cancel := stepContext(ctx, StepDockerTag)
+		err = step.end(run(tagCtx, "docker", "tag", sourceImage, name))
+		cancel()
 		if err != nil {
 			return nil, fmt.Errorf("error tagging image '%s' as '%s': %s", sourceImage, name, err)
 		}
 
-		err = sh.Run("docker", "push", name)
+		step = beginStep(ctx, StepDockerPush, nil, "pushing %s", name)
+		pushCtx, cancel := stepContext(ctx, StepDockerPush)
+		err = step.end(run(pushCtx, "docker", "push", name))
+		cancel()
 		if err != nil {
 			return nil, fmt.Errorf("error pushing image '%s': %s", name, err)
 		}
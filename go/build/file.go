@@ -1,13 +1,16 @@
 package build
 
 import (
+	"context"
 	"os"
-
-	"github.com/magefile/mage/sh"
 )
 
 func CopyFile(srcFile, dstFile string) error {
-	return sh.Run("cp", srcFile, dstFile)
+	return CopyFileContext(context.Background(), srcFile, dstFile)
+}
+
+func CopyFileContext(ctx context.Context, srcFile, dstFile string) error {
+	return run(ctx, "cp", srcFile, dstFile)
 }
 
 func MakeExecutable(file string) error {
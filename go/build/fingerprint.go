@@ -0,0 +1,129 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fingerprintExt is appended to the output path to name the file
+// holding the fingerprint of the inputs which produced it.
+const fingerprintExt = ".fingerprint"
+
+// listFilesTemplate is passed to go list to print every non-standard-library
+// file that goes into the build, one per line.
+const listFilesTemplate = `{{if not .Standard}}` +
+	`{{range .GoFiles}}{{$.Dir}}/{{.}}{{"\n"}}{{end}}` +
+	`{{range .CgoFiles}}{{$.Dir}}/{{.}}{{"\n"}}{{end}}` +
+	`{{range .CFiles}}{{$.Dir}}/{{.}}{{"\n"}}{{end}}` +
+	`{{range .CXXFiles}}{{$.Dir}}/{{.}}{{"\n"}}{{end}}` +
+	`{{range .HFiles}}{{$.Dir}}/{{.}}{{"\n"}}{{end}}` +
+	`{{range .SFiles}}{{$.Dir}}/{{.}}{{"\n"}}{{end}}` +
+	`{{range .EmbedFiles}}{{$.Dir}}/{{.}}{{"\n"}}{{end}}` +
+	`{{end}}`
+
+// fingerprint computes a digest of the inputs to building pkg with env: the
+// go toolchain version, the source files in the main package's dependency
+// closure, go.mod and go.sum, the environment, the build arguments,
+// the version information injected by ldflags, the post-build steps with
+// their settings, and the SBOM and license options.
+func fingerprint(ctx context.Context, pkg Package, env map[string]string, ldflags []string) (string, error) {
+	h := sha256.New()
+
+	goVersion, err := output(ctx, "go", "version")
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "go: %s\n", goVersion)
+
+	listing, err := runCommand(ctx, Command{Name: "go", Args: []string{"list", "-deps", "-f", listFilesTemplate, pkg.Main}, Env: env, Dir: pkg.Dir})
+	if err != nil {
+		return "", fmt.Errorf("listing dependencies of %s: %s", pkg.Main, err)
+	}
+
+	var files []string
+	for _, line := range strings.Split(listing, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+
+	goMod, err := runCommand(ctx, Command{Name: "go", Args: []string{"env", "GOMOD"}, Env: env, Dir: pkg.Dir})
+	if err != nil {
+		return "", err
+	}
+	if goMod = strings.TrimSpace(goMod); goMod != "" && goMod != os.DevNull {
+		files = append(files, goMod)
+		goSum := filepath.Join(filepath.Dir(goMod), "go.sum")
+		if _, err = os.Stat(goSum); err == nil {
+			files = append(files, goSum)
+		}
+	}
+
+	sort.Strings(files)
+	for _, file := range files {
+		if err = hashFile(h, file); err != nil {
+			return "", err
+		}
+	}
+
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "env: %s=%s\n", k, env[k])
+	}
+
+	fmt.Fprintf(h, "args: %q\n", pkg.BuildArgs)
+	fmt.Fprintf(h, "ldflags: %q\n", ldflags)
+	fmt.Fprintf(h, "version: %s\n", pkg.VersionString)
+	for _, step := range pkg.PostBuild {
+		// a function's address can change between runs, so only its name and targets are hashed
+		if f, ok := step.(PostBuildFunc); ok {
+			f.Func = nil
+			step = f
+		}
+		fmt.Fprintf(h, "post-build: %#v\n", step)
+	}
+	fmt.Fprintf(h, "sbom: %s\n", pkg.SBOM)
+	fmt.Fprintf(h, "licenses: %#v\n", pkg.Licenses)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(w, "file: %s\n", path)
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// upToDate returns true if outFile exists and was built from inputs with the fingerprint fp.
+func upToDate(outFile, fp string) bool {
+	if _, err := os.Stat(outFile); err != nil {
+		return false
+	}
+	stored, err := ioutil.ReadFile(outFile + fingerprintExt)
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(stored)) == fp
+}
+
+func writeFingerprint(outFile, fp string) error {
+	return ioutil.WriteFile(outFile+fingerprintExt, []byte(fp+"\n"), 0644)
+}
@@ -0,0 +1,89 @@
+package build
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildPackageSkipsUnchangedInputs(t *testing.T) {
+	noop := func(ctx context.Context, a Artifact) ([]string, error) { return nil, nil }
+
+	tests := []struct {
+		name    string
+		change  func(pkg *Package, source string)
+		rebuilt bool
+	}{
+		{"unchanged", func(pkg *Package, source string) {}, false},
+		{"force", func(pkg *Package, source string) { pkg.Force = true }, true},
+		{"ldflags", func(pkg *Package, source string) { pkg.BuildArgs = []string{"-ldflags=-s -w"} }, true},
+		{"override ldflags", func(pkg *Package, source string) {
+			pkg.Overrides = []TargetOverride{{Ldflags: []string{"-s"}}}
+		}, true},
+		{"override for another target", func(pkg *Package, source string) {
+			pkg.Overrides = []TargetOverride{{Targets: TargetPatterns{"windows/*"}, Ldflags: []string{"-s"}}}
+		}, false},
+		{"env", func(pkg *Package, source string) {
+			pkg.Overrides = []TargetOverride{{Env: map[string]string{"CC": "musl-gcc"}}}
+		}, true},
+		{"cgo", func(pkg *Package, source string) { pkg.CGOEnabled = true }, true},
+		{"version", func(pkg *Package, source string) { pkg.VersionString = "1.2.4" }, true},
+		{"source", func(pkg *Package, source string) {
+			ioutil.WriteFile(source, []byte("package main\n\nfunc main() { println() }\n"), 0644)
+		}, true},
+		{"post-build step added", func(pkg *Package, source string) { pkg.PostBuild = append(pkg.PostBuild, Checksum{}) }, true},
+		{"post-build step settings", func(pkg *Package, source string) {
+			pkg.PostBuild = []PostBuildStep{PostBuildFunc{StepName: "noop", Targets: TargetPatterns{"linux/*"}, Func: noop}}
+		}, true},
+		{"post-build func", func(pkg *Package, source string) {
+			pkg.PostBuild = []PostBuildStep{PostBuildFunc{StepName: "noop", Func: func(ctx context.Context, a Artifact) ([]string, error) { return nil, nil }}}
+		}, false},
+		{"sbom", func(pkg *Package, source string) { pkg.SBOM = SBOMSPDX }, true},
+		{"licenses", func(pkg *Package, source string) { pkg.Licenses = &LicenseOptions{Deny: []string{"GPL-*"}} }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkg, cleanup := newTestPackage(t)
+			defer cleanup()
+			pkg.PostBuild = []PostBuildStep{PostBuildFunc{StepName: "noop", Func: noop}}
+
+			source := filepath.Join(pkg.OutDir, "main.go")
+			if err := ioutil.WriteFile(source, []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			r := newFakeGoRunner()
+			r.Respond("go list -deps", source, nil)
+			ctx := WithRunner(context.Background(), r)
+
+			first, err := BuildPackageContext(ctx, pkg, TargetLinuxAmd64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.UpToDate {
+				t.Fatal("the first build was skipped")
+			}
+
+			tt.change(&pkg, source)
+			// the SBOM and notices cannot be written for the fake binary,
+			// but only whether it was built again matters here
+			second, _ := BuildPackageContext(ctx, pkg, TargetLinuxAmd64)
+
+			builds := 0
+			for _, line := range r.Lines() {
+				if strings.Contains(line, "go build ") {
+					builds++
+				}
+			}
+			if rebuilt := builds == 2; rebuilt != tt.rebuilt {
+				t.Errorf("rebuilt = %t, want %t", rebuilt, tt.rebuilt)
+			}
+			if second.UpToDate == tt.rebuilt {
+				t.Errorf("UpToDate = %t, want %t", second.UpToDate, !tt.rebuilt)
+			}
+		})
+	}
+}
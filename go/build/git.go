@@ -1,9 +1,10 @@
 package build
 
 import (
-	"strings"
-
-	"github.com/magefile/mage/sh"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
 )
 
 func MustGetGit() (branch, commit, shortCommit string) {
@@ -28,39 +29,47 @@ func MustGetGit() (branch, commit, shortCommit string) {
 }
 
 func GitHash() (string, error) {
-	outBytes, err := sh.Output("git", "rev-parse", "HEAD")
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(outBytes)), nil
+	return GitHashContext(context.Background())
+}
+
+func GitHashContext(ctx context.Context) (string, error) {
+	return gitOutput(ctx, "rev-parse", "HEAD")
 }
 
 func GitShortHash() (string, error) {
-	outBytes, err := sh.Output("git", "rev-parse", "--short", "HEAD")
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(outBytes)), nil
+	return GitShortHashContext(context.Background())
+}
+
+func GitShortHashContext(ctx context.Context) (string, error) {
+	return gitOutput(ctx, "rev-parse", "--short", "HEAD")
 }
 
 func GitBranch() (string, error) {
-	outBytes, err := sh.Output("git", "rev-parse", "--abbrev-ref", "HEAD")
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(outBytes)), nil
+	return GitBranchContext(context.Background())
+}
+
+func GitBranchContext(ctx context.Context) (string, error) {
+	return gitOutput(ctx, "rev-parse", "--abbrev-ref", "HEAD")
 }
 
 func OnReleaseBranch() bool {
-	branch, err := GitBranch()
+	return OnReleaseBranchContext(context.Background())
+}
+
+func OnReleaseBranchContext(ctx context.Context) bool {
+	branch, err := GitBranchContext(ctx)
 	if err != nil {
 		return false
 	}
-	return strings.HasPrefix(branch, "release")
+	return isReleaseBranch(branch)
 }
 
 func OnMasterBranch() bool {
-	branch, err := GitBranch()
+	return OnMasterBranchContext(context.Background())
+}
+
+func OnMasterBranchContext(ctx context.Context) bool {
+	branch, err := GitBranchContext(ctx)
 	if err != nil {
 		return false
 	}
@@ -68,13 +77,66 @@ func OnMasterBranch() bool {
 }
 
 func GitTag(tag, msg string) error {
-	return sh.Run("git", "tag", tag, "-m", msg)
+	return GitTagContext(context.Background(), tag, msg)
+}
+
+func GitTagContext(ctx context.Context, tag, msg string) error {
+	ctx, cancel := stepContext(ctx, StepGit)
+	defer cancel()
+	return run(ctx, "git", "tag", tag, "-m", msg)
 }
 
 func GitPushToRemote(remote, target string) error {
-	return sh.Run("git", "push", remote, target)
+	return GitPushToRemoteContext(context.Background(), remote, target)
+}
+
+func GitPushToRemoteContext(ctx context.Context, remote, target string) error {
+	ctx, cancel := stepContext(ctx, StepGit)
+	defer cancel()
+	return run(ctx, "git", "push", remote, target)
 }
 
 func GitPush(target string) error {
 	return GitPushToRemote("origin", target)
 }
+
+func GitPushContext(ctx context.Context, target string) error {
+	return GitPushToRemoteContext(ctx, "origin", target)
+}
+
+// GitDirty reports whether the working tree has uncommitted changes.
+func GitDirty() (bool, error) {
+	return GitDirtyContext(context.Background())
+}
+
+func GitDirtyContext(ctx context.Context) (bool, error) {
+	out, err := gitOutput(ctx, "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return out != "", nil
+}
+
+// GitCommitTime returns the committer time of HEAD.
+func GitCommitTime() (time.Time, error) {
+	return GitCommitTimeContext(context.Background())
+}
+
+func GitCommitTimeContext(ctx context.Context) (time.Time, error) {
+	out, err := gitOutput(ctx, "log", "-1", "--format=%ct", "HEAD")
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(out, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse commit time %q: %s", out, err)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// gitOutput runs a git command as a StepGit and returns its trimmed output.
+func gitOutput(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := stepContext(ctx, StepGit)
+	defer cancel()
+	return output(ctx, "git", args...)
+}
@@ -0,0 +1,179 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// GitVersion is a version derived from the git history by VersionFromGit.
+type GitVersion struct {
+	Version semver.Version `json:"version"`
+	// Tag is the latest semver tag reachable from HEAD, or empty if there is none.
+	Tag string `json:"tag,omitempty"`
+	// CommitsSinceTag is the number of commits between Tag and HEAD.
+	CommitsSinceTag int    `json:"commitsSinceTag"`
+	Branch          string `json:"branch"`
+	ShortCommit     string `json:"shortCommit"`
+	Dirty           bool   `json:"dirty"`
+}
+
+func (v GitVersion) String() string {
+	return v.Version.String()
+}
+
+// VersionFromGit derives the version of the code in the working directory
+// from the latest semver tag reachable from HEAD (with or without a "v" prefix).
+//
+// A clean checkout of a tagged commit gets the tag's version. Otherwise the
+// version is the next patch version after the tag with a prerelease identifier
+// and build metadata. After a prerelease tag, the identifier is appended to the
+// tag's prerelease instead, so the version still sorts after the tag:
+//
+//	1.2.4-rc.3+a1b2c3d                      3 commits after v1.2.3 on a release branch
+//	1.2.4-feature-x-217d2bf.3+a1b2c3d       3 commits after v1.2.3 on branch feature/x
+//	1.2.4-master.0+a1b2c3d.dirty            uncommitted changes to v1.2.3 on master
+//	2.0.0-rc.1.master.2+a1b2c3d             2 commits after v2.0.0-rc.1 on master
+//
+// The prerelease identifier of a branch which is not a release branch is the
+// branch name, so builds of different branches never get the same version.
+// If there is no tag the version is based on 0.0.0.
+func VersionFromGit() (GitVersion, error) {
+	return VersionFromGitContext(context.Background())
+}
+
+func VersionFromGitContext(ctx context.Context) (GitVersion, error) {
+	var (
+		v   GitVersion
+		err error
+	)
+
+	if v.Branch, err = GitBranchContext(ctx); err != nil {
+		return v, fmt.Errorf("could not determine git branch: %s", err)
+	}
+	if v.Branch == "HEAD" {
+		v.Branch = detachedBranchName()
+	}
+	if v.ShortCommit, err = GitShortHashContext(ctx); err != nil {
+		return v, fmt.Errorf("could not determine git commit: %s", err)
+	}
+	if v.Dirty, err = GitDirtyContext(ctx); err != nil {
+		return v, fmt.Errorf("could not determine git status: %s", err)
+	}
+
+	tag, base, err := latestVersionTag(ctx, true)
+	if err != nil {
+		return v, err
+	}
+	v.Tag = tag
+
+	revs := "HEAD"
+	if tag != "" {
+		revs = tag + "..HEAD"
+	}
+	count, err := gitOutput(ctx, "rev-list", "--count", revs)
+	if err != nil {
+		return v, fmt.Errorf("could not count commits since %q: %s", tag, err)
+	}
+	if v.CommitsSinceTag, err = strconv.Atoi(count); err != nil {
+		return v, fmt.Errorf("could not parse commit count %q: %s", count, err)
+	}
+
+	if tag != "" && v.CommitsSinceTag == 0 && !v.Dirty {
+		v.Version = base
+		return v, nil
+	}
+
+	identifier := "rc"
+	if !isReleaseBranch(v.Branch) {
+		identifier = prereleaseIdentifier(v.Branch)
+	}
+
+	version := semver.Version{Major: base.Major, Minor: base.Minor, Patch: base.Patch}
+	if base.PreRelease == "" {
+		version.Patch++
+		version.PreRelease = semver.PreRelease(fmt.Sprintf("%s.%d", identifier, v.CommitsSinceTag))
+	} else {
+		version.PreRelease = semver.PreRelease(fmt.Sprintf("%s.%s.%d", base.PreRelease, identifier, v.CommitsSinceTag))
+	}
+
+	version.Metadata = v.ShortCommit
+	if v.Dirty {
+		version.Metadata += ".dirty"
+	}
+
+	v.Version = version
+	return v, nil
+}
+
+// latestVersionTag returns the highest semver tag reachable from HEAD
+// and its version. If there is none, the tag is empty. Prerelease
+// tags are ignored unless prerelease is true.
+func latestVersionTag(ctx context.Context, prerelease bool) (string, semver.Version, error) {
+	out, err := gitOutput(ctx, "tag", "--merged", "HEAD")
+	if err != nil {
+		return "", semver.Version{}, fmt.Errorf("could not list git tags: %s", err)
+	}
+
+	var (
+		latestTag string
+		latest    *semver.Version
+	)
+	for _, tag := range strings.Fields(out) {
+		version, err := semver.NewVersion(strings.TrimPrefix(tag, "v"))
+		if err != nil || (version.PreRelease != "" && !prerelease) {
+			continue
+		}
+		if latest == nil || latest.LessThan(*version) {
+			latestTag, latest = tag, version
+		}
+	}
+
+	if latest == nil {
+		return "", semver.Version{}, nil
+	}
+	return latestTag, *latest, nil
+}
+
+func isReleaseBranch(branch string) bool {
+	return strings.HasPrefix(branch, "release")
+}
+
+// detachedBranchName returns the branch name CI reports for a detached
+// checkout, or "detached" if there is none.
+func detachedBranchName() string {
+	for _, name := range []string{"BRANCH_NAME", "TEAMCITY_BUILD_BRANCH", "GIT_BRANCH"} {
+		if branch := os.Getenv(name); branch != "" {
+			return strings.TrimPrefix(branch, "refs/heads/")
+		}
+	}
+	return "detached"
+}
+
+var invalidPrerelease = regexp.MustCompile(`[^0-9A-Za-z-]+`)
+
+// prereleaseIdentifier turns a branch name into a valid semver prerelease
+// identifier, such as "feature-x-217d2bf" for "feature/x". If the branch name
+// has to be changed, a short hash of it is appended, so that branches such as
+// feature/x and feature-x do not get the same identifier.
+func prereleaseIdentifier(branch string) string {
+	id := strings.Trim(invalidPrerelease.ReplaceAllString(branch, "-"), "-")
+	switch _, err := strconv.Atoi(id); {
+	case id == "":
+		id = "branch"
+	case err == nil || id == "rc":
+		// numeric identifiers may not have leading zeros, and must not be
+		// confused with the commit count or a release branch, so they are prefixed
+		id = "b" + id
+	}
+	if id != branch {
+		id += "-" + fmt.Sprintf("%x", sha256.Sum256([]byte(branch)))[:7]
+	}
+	return id
+}
@@ -0,0 +1,116 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+func TestVersionFromGit(t *testing.T) {
+	tests := []struct {
+		name   string
+		branch string
+		dirty  bool
+		tags   string
+		count  string
+		want   string
+	}{
+		{name: "tagged", branch: "master", tags: "v1.2.3\nv1.2.2", count: "0", want: "1.2.3"},
+		{name: "tag without v", branch: "master", tags: "1.2.3", count: "0", want: "1.2.3"},
+		{name: "dirty tag", branch: "master", dirty: true, tags: "v1.2.3", count: "0", want: "1.2.4-master.0+abc1234.dirty"},
+		{name: "release branch", branch: "release/1.2", tags: "v1.2.3", count: "3", want: "1.2.4-rc.3+abc1234"},
+		{name: "feature branch", branch: "feature/x", tags: "v1.2.3", count: "3", want: "1.2.4-feature-x-217d2bf.3+abc1234"},
+		{name: "hyphenated branch", branch: "feature-x", tags: "v1.2.3", count: "3", want: "1.2.4-feature-x.3+abc1234"},
+		{name: "numeric branch", branch: "123", tags: "v1.2.3", count: "1", want: "1.2.4-b123-a665a45.1+abc1234"},
+		{name: "after prerelease tag", branch: "master", tags: "v1.2.3\nv2.0.0-rc.1", count: "2", want: "2.0.0-rc.1.master.2+abc1234"},
+		{name: "release branch after prerelease tag", branch: "release/2.0", tags: "v2.0.0-rc.5", count: "1", want: "2.0.0-rc.5.rc.1+abc1234"},
+		{name: "highest tag wins", branch: "master", tags: "v1.10.0\nv1.9.0\nnot-a-version", count: "0", want: "1.10.0"},
+		{name: "no tags", branch: "master", tags: "", count: "5", want: "0.0.1-master.5+abc1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := ""
+			if tt.dirty {
+				status = " M main.go"
+			}
+			r := &RecordingRunner{}
+			r.Respond("git rev-parse --abbrev-ref HEAD", tt.branch, nil)
+			r.Respond("git rev-parse --short HEAD", "abc1234", nil)
+			r.Respond("git status --porcelain", status, nil)
+			r.Respond("git tag --merged HEAD", tt.tags, nil)
+			r.Respond("git rev-list --count", tt.count, nil)
+
+			got, err := VersionFromGitContext(WithRunner(context.Background(), r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestVersionFromGitSortsAfterTag checks the versions of the commits after
+// a tag sort after it, and before the next tag.
+func TestVersionFromGitSortsAfterTag(t *testing.T) {
+	tests := []struct {
+		tag, next string
+	}{
+		{"v1.2.3", "v1.2.4"},
+		{"v2.0.0-rc.1", "v2.0.0-rc.2"},
+		{"v2.0.0-rc.9", "v2.0.0"},
+		{"v2.0.0-beta", "v2.0.0-rc.1"},
+	}
+
+	for _, tt := range tests {
+		for _, branch := range []string{"master", "release/2.0", "feature/x"} {
+			r := &RecordingRunner{}
+			r.Respond("git rev-parse --abbrev-ref HEAD", branch, nil)
+			r.Respond("git rev-parse --short HEAD", "abc1234", nil)
+			r.Respond("git tag --merged HEAD", tt.tag, nil)
+			r.Respond("git rev-list --count", "4", nil)
+
+			got, err := VersionFromGitContext(WithRunner(context.Background(), r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tag, next := semver.New(strings.TrimPrefix(tt.tag, "v")), semver.New(strings.TrimPrefix(tt.next, "v"))
+			if !tag.LessThan(got.Version) || !got.Version.LessThan(*next) {
+				t.Errorf("%s on %s: got %s, want it between %s and %s", tt.tag, branch, got, tt.tag, tt.next)
+			}
+		}
+	}
+}
+
+func TestPrereleaseIdentifier(t *testing.T) {
+	tests := []struct {
+		branch, want string
+	}{
+		{"master", "master"},
+		{"feature-x", "feature-x"},
+		{"feature/x", "feature-x-217d2bf"},
+		{"123", "b123-a665a45"},
+		{"rc", "brc-7581e0d"},
+		{"/", "branch-8a5edab"},
+	}
+
+	for _, tt := range tests {
+		if got := prereleaseIdentifier(tt.branch); got != tt.want {
+			t.Errorf("prereleaseIdentifier(%q) = %q, want %q", tt.branch, got, tt.want)
+		}
+	}
+}
+
+func TestVersionFromGitErrors(t *testing.T) {
+	r := &RecordingRunner{}
+	r.Respond("git", "", fmt.Errorf("not a git repository"))
+
+	if _, err := VersionFromGitContext(WithRunner(context.Background(), r)); err == nil {
+		t.Error("expected an error outside a git repository")
+	}
+}
@@ -0,0 +1,254 @@
+package build
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The icon formats accepted by LoadIcon.
+const (
+	IconPNG  = "image/png"
+	IconJPEG = "image/jpeg"
+	IconGIF  = "image/gif"
+	IconSVG  = "image/svg+xml"
+)
+
+const (
+	// DefaultIconMaxSize is the largest width and height of an icon, in pixels,
+	// if IconOptions.MaxSize is not set.
+	DefaultIconMaxSize = 512
+	// DefaultIconMaxBytes is the largest icon file if IconOptions.MaxBytes is not set.
+	DefaultIconMaxBytes = 1 << 20
+)
+
+// IconOptions controls the checks LoadIcon makes.
+type IconOptions struct {
+	// MaxSize is the largest width and height of the icon, in pixels.
+	// If zero, DefaultIconMaxSize is used.
+	MaxSize int
+	// MaxBytes is the largest size of the icon once it is encoded.
+	// If zero, DefaultIconMaxBytes is used.
+	MaxBytes int
+	// Resize scales a PNG, JPEG or GIF icon larger than MaxSize down to fit,
+	// keeping its aspect ratio, and re-encodes it as PNG, instead of failing.
+	// An icon which is larger than MaxBytes is also re-encoded as PNG.
+	Resize bool
+}
+
+func (o IconOptions) withDefaults() IconOptions {
+	if o.MaxSize == 0 {
+		o.MaxSize = DefaultIconMaxSize
+	}
+	if o.MaxBytes == 0 {
+		o.MaxBytes = DefaultIconMaxBytes
+	}
+	return o
+}
+
+// Icon is an icon loaded by LoadIcon.
+type Icon struct {
+	// MIMEType is the format of Data, one of IconPNG, IconJPEG, IconGIF or IconSVG.
+	MIMEType string
+	// Width and Height are the size of the icon in pixels. They are zero
+	// for an SVG icon which does not declare its size.
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// DataURI returns the icon as a data URI, as used in a plugin manifest.
+func (i Icon) DataURI() string {
+	return fmt.Sprintf("data:%s;base64,%s", i.MIMEType, base64.StdEncoding.EncodeToString(i.Data))
+}
+
+// LoadIcon reads the icon at path, working out its format from its content rather
+// than its extension. PNG, JPEG, GIF and SVG icons are accepted. An icon larger than
+// opts.MaxSize or opts.MaxBytes is an error, unless opts.Resize is set and it can be
+// scaled down and re-encoded to fit.
+func LoadIcon(path string, opts IconOptions) (Icon, error) {
+	opts = opts.withDefaults()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Icon{}, fmt.Errorf("could not read icon: %s", err)
+	}
+
+	icon, err := decodeIcon(data)
+	if err != nil {
+		return icon, fmt.Errorf("invalid icon %s: %s", path, err)
+	}
+
+	tooLarge := icon.Width > opts.MaxSize || icon.Height > opts.MaxSize
+	if opts.Resize && icon.MIMEType != IconSVG && (tooLarge || len(icon.Data) > opts.MaxBytes) {
+		if icon, err = resizeIcon(icon, opts.MaxSize); err != nil {
+			return icon, fmt.Errorf("could not resize icon %s: %s", path, err)
+		}
+		tooLarge = false
+	}
+
+	if tooLarge {
+		return icon, fmt.Errorf("icon %s is %dx%d, larger than the maximum of %dx%d", path, icon.Width, icon.Height, opts.MaxSize, opts.MaxSize)
+	}
+	if len(icon.Data) > opts.MaxBytes {
+		return icon, fmt.Errorf("icon %s is %d bytes, larger than the maximum of %d", path, len(icon.Data), opts.MaxBytes)
+	}
+	return icon, nil
+}
+
+// decodeIcon works out the format and size of the icon in data.
+func decodeIcon(data []byte) (Icon, error) {
+	icon := Icon{Data: data}
+
+	switch mimeType := http.DetectContentType(data); mimeType {
+	case IconPNG, IconJPEG, IconGIF:
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return icon, fmt.Errorf("could not decode %s: %s", mimeType, err)
+		}
+		if "image/"+format != mimeType {
+			return icon, fmt.Errorf("content is %s but decoded as %s", mimeType, format)
+		}
+		icon.MIMEType, icon.Width, icon.Height = mimeType, config.Width, config.Height
+	default:
+		width, height, err := svgSize(data)
+		if err != nil {
+			return icon, fmt.Errorf("format %s is not supported (expected PNG, JPEG, GIF or SVG)", strings.Split(mimeType, ";")[0])
+		}
+		icon.MIMEType, icon.Width, icon.Height = IconSVG, width, height
+	}
+
+	return icon, nil
+}
+
+// svgSize returns the size of the SVG document in data from the width and height
+// of its root element, or else its viewBox. An error is returned if it is not SVG.
+func svgSize(data []byte) (int, int, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			return 0, 0, fmt.Errorf("no svg element")
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		root, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root.Name.Local != "svg" {
+			return 0, 0, fmt.Errorf("root element is %s, not svg", root.Name.Local)
+		}
+
+		var width, height float64
+		var viewBox []string
+		for _, attr := range root.Attr {
+			switch attr.Name.Local {
+			case "width":
+				width = svgLength(attr.Value)
+			case "height":
+				height = svgLength(attr.Value)
+			case "viewBox":
+				viewBox = strings.Fields(strings.Replace(attr.Value, ",", " ", -1))
+			}
+		}
+		if (width == 0 || height == 0) && len(viewBox) == 4 {
+			width, _ = strconv.ParseFloat(viewBox[2], 64)
+			height, _ = strconv.ParseFloat(viewBox[3], 64)
+		}
+		return int(width + 0.5), int(height + 0.5), nil
+	}
+}
+
+// svgLength parses an SVG length in pixels, such as "64" or "64px".
+// Other units, including percentages, are treated as unknown.
+func svgLength(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "px"), 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// resizeIcon scales a raster icon down to fit within maxSize, if it does not
+// already, and re-encodes it as PNG. The first frame of an animated GIF is used.
+func resizeIcon(icon Icon, maxSize int) (Icon, error) {
+	var (
+		img image.Image
+		err error
+	)
+	switch icon.MIMEType {
+	case IconPNG:
+		img, err = png.Decode(bytes.NewReader(icon.Data))
+	case IconJPEG:
+		img, err = jpeg.Decode(bytes.NewReader(icon.Data))
+	case IconGIF:
+		img, err = gif.Decode(bytes.NewReader(icon.Data))
+	default:
+		return icon, fmt.Errorf("%s icons cannot be resized", icon.MIMEType)
+	}
+	if err != nil {
+		return icon, err
+	}
+
+	width, height := icon.Width, icon.Height
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			width, height = maxSize, maxInt(1, height*maxSize/width)
+		} else {
+			width, height = maxInt(1, width*maxSize/height), maxSize
+		}
+		img = scaleImage(img, width, height)
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return icon, err
+	}
+	return Icon{MIMEType: IconPNG, Width: width, Height: height, Data: buf.Bytes()}, nil
+}
+
+// scaleImage scales src down to width by height, averaging the
+// source pixels covered by each destination pixel.
+func scaleImage(src image.Image, width, height int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	b := src.Bounds()
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := maxInt(y0+1, b.Min.Y+(y+1)*b.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := maxInt(x0+1, b.Min.X+(x+1)*b.Dx()/width)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					// the components are premultiplied by alpha, so they can be averaged
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
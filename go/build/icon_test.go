@@ -0,0 +1,174 @@
+package build
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func encodeTestImage(t *testing.T, format string, width, height int) []byte {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.White, color.Black})
+	for x := 0; x < width; x += 2 {
+		img.SetColorIndex(x, x%height, 1)
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case IconPNG:
+		err = png.Encode(&buf, img)
+	case IconJPEG:
+		err = jpeg.Encode(&buf, img, nil)
+	case IconGIF:
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadIcon(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		data       []byte
+		opts       IconOptions
+		wantType   string
+		wantWidth  int
+		wantHeight int
+		wantErr    string
+	}{
+		{name: "png", file: "icon.png", data: encodeTestImage(t, IconPNG, 64, 32), wantType: IconPNG, wantWidth: 64, wantHeight: 32},
+		{name: "jpeg", file: "icon.jpg", data: encodeTestImage(t, IconJPEG, 16, 16), wantType: IconJPEG, wantWidth: 16, wantHeight: 16},
+		{name: "gif", file: "icon.gif", data: encodeTestImage(t, IconGIF, 8, 24), wantType: IconGIF, wantWidth: 8, wantHeight: 24},
+		{name: "content wins over extension", file: "icon.jpg", data: encodeTestImage(t, IconPNG, 10, 10), wantType: IconPNG, wantWidth: 10, wantHeight: 10},
+		{
+			name:     "svg size",
+			file:     "icon.svg",
+			data:     []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="48px" height="24"></svg>`),
+			wantType: IconSVG, wantWidth: 48, wantHeight: 24,
+		},
+		{
+			name:     "svg viewBox",
+			file:     "icon.svg",
+			data:     []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0,0 100 50"><rect/></svg>`),
+			wantType: IconSVG, wantWidth: 100, wantHeight: 50,
+		},
+		{
+			name:     "svg without size",
+			file:     "icon.svg",
+			data:     []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="100%"></svg>`),
+			wantType: IconSVG,
+		},
+		{
+			name:    "not an image",
+			file:    "icon.png",
+			data:    []byte("just some text"),
+			wantErr: "format text/plain is not supported (expected PNG, JPEG, GIF or SVG)",
+		},
+		{
+			name:    "xml which is not svg",
+			file:    "icon.svg",
+			data:    []byte(`<html><body/></html>`),
+			wantErr: "format text/html is not supported (expected PNG, JPEG, GIF or SVG)",
+		},
+		{
+			name:    "too large",
+			file:    "icon.png",
+			data:    encodeTestImage(t, IconPNG, 64, 32),
+			opts:    IconOptions{MaxSize: 48},
+			wantErr: "is 64x32, larger than the maximum of 48x48",
+		},
+		{
+			name:    "too many bytes",
+			file:    "icon.png",
+			data:    encodeTestImage(t, IconPNG, 64, 32),
+			opts:    IconOptions{MaxBytes: 10},
+			wantErr: "larger than the maximum of 10",
+		},
+		{
+			name:     "resized keeping the aspect ratio",
+			file:     "icon.gif",
+			data:     encodeTestImage(t, IconGIF, 1024, 512),
+			opts:     IconOptions{MaxSize: 128, Resize: true},
+			wantType: IconPNG, wantWidth: 128, wantHeight: 64,
+		},
+		{
+			name:     "resized tall",
+			file:     "icon.jpg",
+			data:     encodeTestImage(t, IconJPEG, 30, 300),
+			opts:     IconOptions{MaxSize: 100, Resize: true},
+			wantType: IconPNG, wantWidth: 10, wantHeight: 100,
+		},
+		{
+			name:     "small enough is not resized",
+			file:     "icon.jpg",
+			data:     encodeTestImage(t, IconJPEG, 30, 30),
+			opts:     IconOptions{MaxSize: 100, Resize: true},
+			wantType: IconJPEG, wantWidth: 30, wantHeight: 30,
+		},
+		{
+			name:    "svg is never resized",
+			file:    "icon.svg",
+			data:    []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="1024" height="1024"></svg>`),
+			opts:    IconOptions{Resize: true},
+			wantErr: "is 1024x1024, larger than the maximum of 512x512",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "icon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			icon, err := LoadIcon(path, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if icon.MIMEType != tt.wantType || icon.Width != tt.wantWidth || icon.Height != tt.wantHeight {
+				t.Errorf("got %s %dx%d, want %s %dx%d", icon.MIMEType, icon.Width, icon.Height, tt.wantType, tt.wantWidth, tt.wantHeight)
+			}
+
+			// the data must match the type and size reported
+			if icon.MIMEType != IconSVG {
+				config, format, err := image.DecodeConfig(bytes.NewReader(icon.Data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if "image/"+format != icon.MIMEType || config.Width != icon.Width || config.Height != icon.Height {
+					t.Errorf("data is %s %dx%d", format, config.Width, config.Height)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadIconMissing(t *testing.T) {
+	_, err := LoadIcon(filepath.Join(os.TempDir(), "no-such-icon.png"), IconOptions{})
+	if err == nil || !strings.HasPrefix(err.Error(), "could not read icon: ") {
+		t.Errorf("got %v, want a read error", err)
+	}
+}
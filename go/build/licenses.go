@@ -0,0 +1,292 @@
+package build
+
+import (
+	"context"
+	"debug/buildinfo"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// LicenseUnknown is the license of a module whose license file is
+// missing or could not be recognized.
+const LicenseUnknown = "UNKNOWN"
+
+// NoticesFile is the name of the notices file in a plugin's package.zip.
+const NoticesFile = "THIRD_PARTY_NOTICES"
+
+// LicenseOptions is the compliance policy for the licenses of linked modules.
+type LicenseOptions struct {
+	// Deny lists the SPDX license IDs which may not be linked, such as
+	// "AGPL-3.0". Patterns such as "GPL-*" are matched with path.Match.
+	// Include LicenseUnknown to require every license to be recognized.
+	Deny []string
+	// Exceptions lists the modules which are allowed whatever their license,
+	// such as those Legal has approved. Patterns are matched with path.Match.
+	Exceptions []string
+}
+
+// ModuleLicense is the license found for a module linked into a binary.
+type ModuleLicense struct {
+	Module  string `json:"module"`
+	Version string `json:"version"`
+	// License is the SPDX ID of the license, or LicenseUnknown.
+	License string `json:"license"`
+	// Files are the license and notice files found in the module.
+	Files []string `json:"files,omitempty"`
+	text  string
+}
+
+// LicenseReport lists the license of every module linked into a binary.
+type LicenseReport struct {
+	Binary  string          `json:"binary"`
+	Modules []ModuleLicense `json:"modules"`
+	// Denied are the modules whose license is on the deny list.
+	Denied []ModuleLicense `json:"denied,omitempty"`
+}
+
+// String lists each module with its license.
+func (r LicenseReport) String() string {
+	var b strings.Builder
+	for _, m := range r.Modules {
+		fmt.Fprintf(&b, "%-14s %s %s\n", m.License, m.Module, m.Version)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// DeniedError is returned when a module's license is on the deny list.
+type DeniedError struct {
+	Denied []ModuleLicense
+}
+
+func (e DeniedError) Error() string {
+	var modules []string
+	for _, m := range e.Denied {
+		modules = append(modules, fmt.Sprintf("%s %s (%s)", m.Module, m.Version, m.License))
+	}
+	return fmt.Sprintf("%d module(s) have a denied license: %s", len(e.Denied), strings.Join(modules, ", "))
+}
+
+// CollectLicenses reads the module build info of the Go binary at path and
+// finds the license files of every module linked into it, and of the Go
+// standard library. Modules are looked for in the project's vendor directory,
+// the directories they were replaced with, and the module cache, so they must
+// have been downloaded. Each license is recognized as an SPDX license type.
+// If any module's license is denied by opts the report is returned with a DeniedError.
+func CollectLicenses(path string, opts LicenseOptions) (LicenseReport, error) {
+	return CollectLicensesContext(context.Background(), path, opts)
+}
+
+func CollectLicensesContext(ctx context.Context, path string, opts LicenseOptions) (LicenseReport, error) {
+	report := LicenseReport{Binary: path}
+
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return report, fmt.Errorf("could not read the build info of %s: %s", path, err)
+	}
+
+	modCache := os.Getenv("GOMODCACHE")
+	if modCache == "" {
+		if modCache, err = output(ctx, "go", "env", "GOMODCACHE"); err != nil {
+			return report, fmt.Errorf("could not find the module cache: %s", err)
+		}
+	}
+
+	if goroot, err := output(ctx, "go", "env", "GOROOT"); err == nil && goroot != "" {
+		report.Modules = append(report.Modules, moduleLicense("std", info.GoVersion, []string{goroot}))
+	}
+
+	for _, dep := range info.Deps {
+		var dirs []string
+		if dep.Replace != nil && (dep.Replace.Version == "" || dep.Replace.Version == "(devel)") {
+			dirs = append(dirs, dep.Replace.Path)
+		}
+		module := dep
+		if dep.Replace != nil && len(dirs) == 0 {
+			module = dep.Replace
+		}
+		dirs = append(dirs,
+			filepath.Join("vendor", filepath.FromSlash(module.Path)),
+			filepath.Join(modCache, filepath.FromSlash(escapeModulePath(module.Path))+"@"+escapeModulePath(module.Version)),
+		)
+
+		m := moduleLicense(module.Path, module.Version, dirs)
+		if len(m.Files) == 0 {
+			logEvent(ctx, LevelWarn, StepBuild, Fields{"module": m.Module}, "no license found for %s %s, is it in the module cache?", m.Module, m.Version)
+		}
+		report.Modules = append(report.Modules, m)
+	}
+
+	for _, m := range report.Modules {
+		if opts.denies(m) {
+			report.Denied = append(report.Denied, m)
+		}
+	}
+	if len(report.Denied) > 0 {
+		return report, DeniedError{Denied: report.Denied}
+	}
+	return report, nil
+}
+
+func (o LicenseOptions) denies(m ModuleLicense) bool {
+	for _, pattern := range o.Exceptions {
+		if ok, _ := path.Match(pattern, m.Module); ok {
+			return false
+		}
+	}
+	for _, pattern := range o.Deny {
+		if ok, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(m.License)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// licenseFilePattern matches the names of license and notice files.
+var licenseFilePattern = regexp.MustCompile(`(?i)^(LICEN[CS]E|COPYING|NOTICE|COPYRIGHT)([.\-_].*)?$`)
+
+// moduleLicense reads the license files in the first of dirs which exists.
+func moduleLicense(module, version string, dirs []string) ModuleLicense {
+	m := ModuleLicense{Module: module, Version: version, License: LicenseUnknown}
+
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		var texts []string
+		for _, e := range entries {
+			if e.IsDir() || !licenseFilePattern.MatchString(e.Name()) {
+				continue
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				continue
+			}
+			m.Files = append(m.Files, filepath.Join(dir, e.Name()))
+			texts = append(texts, strings.TrimSpace(string(data)))
+
+			if m.License == LicenseUnknown && !strings.HasPrefix(strings.ToUpper(e.Name()), "NOTICE") {
+				m.License = ClassifyLicense(string(data))
+			}
+		}
+		m.text = strings.Join(texts, "\n\n")
+		break
+	}
+
+	return m
+}
+
+// licenseSignatures recognize licenses by phrases from their text, which is
+// normalized to lower case words separated by single spaces, so "and/or" is
+// written "and or". They are checked in order, so more specific licenses come
+// before the ones they resemble.
+var licenseSignatures = []struct {
+	ID      string
+	Phrases []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"EPL-2.0", []string{"eclipse public license", "2.0"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "names of its contributors may not be used"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"ISC", []string{"permission to use, copy, modify, and or distribute this software for any purpose with or without fee is hereby granted"}},
+	{"ISC", []string{"permission to use, copy, modify, and distribute this software for any purpose with or without fee is hereby granted"}},
+	{"MIT", []string{"permission is hereby granted, free of charge, to any person obtaining a copy"}},
+	{"BSL-1.0", []string{"boost software license", "version 1.0"}},
+	{"Zlib", []string{"altered source versions must be plainly marked as such"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"cc0 1.0 universal"}},
+}
+
+// ClassifyLicense returns the SPDX ID of the license text, or LicenseUnknown.
+func ClassifyLicense(text string) string {
+	normalized := strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '#' || r == '*' || r == '/'
+	}), " ")
+
+	for _, s := range licenseSignatures {
+		matched := true
+		for _, phrase := range s.Phrases {
+			if !strings.Contains(normalized, phrase) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return s.ID
+		}
+	}
+	return LicenseUnknown
+}
+
+// escapeModulePath escapes a module path or version as it is stored in the
+// module cache, where each upper case letter is replaced with "!" and its lower case.
+func escapeModulePath(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteRune('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// WriteNotices writes the license text of every module to path, ordered by module.
+func (r LicenseReport) WriteNotices(path string) error {
+	modules := append([]ModuleLicense(nil), r.Modules...)
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].Module < modules[j].Module
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "THIRD PARTY NOTICES\n\n%s includes the following third party software.\n", filepath.Base(r.Binary))
+	for _, m := range modules {
+		fmt.Fprintf(&b, "\n%s\n\n%s %s\nLicense: %s\n\n", strings.Repeat("-", 80), m.Module, m.Version, m.License)
+		if m.text == "" {
+			b.WriteString("No license file was found.\n")
+		} else {
+			b.WriteString(m.text + "\n")
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("could not write notices: %s", err)
+	}
+	return nil
+}
+
+// WriteThirdPartyNotices collects the licenses of the modules linked into the
+// binary at path and writes them to {path}.THIRD_PARTY_NOTICES, which is
+// written even if a license is denied. It returns the path of the notices.
+func WriteThirdPartyNotices(path string, opts LicenseOptions) (string, LicenseReport, error) {
+	return WriteThirdPartyNoticesContext(context.Background(), path, opts)
+}
+
+func WriteThirdPartyNoticesContext(ctx context.Context, path string, opts LicenseOptions) (string, LicenseReport, error) {
+	out := path + "." + NoticesFile
+
+	report, err := CollectLicensesContext(ctx, path, opts)
+	if _, denied := err.(DeniedError); err != nil && !denied {
+		return out, report, err
+	}
+	if writeErr := report.WriteNotices(out); writeErr != nil {
+		return out, report, writeErr
+	}
+	return out, report, err
+}
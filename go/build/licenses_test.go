@@ -0,0 +1,77 @@
+package build
+
+import (
+	"testing"
+)
+
+func TestClassifyLicense(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"MIT", `MIT License
+
+Copyright (c) 2020 Someone
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal`, "MIT"},
+		{"Apache", `
+                                 Apache License
+                           Version 2.0, January 2004
+                        http://www.apache.org/licenses/`, "Apache-2.0"},
+		{"BSD-3-Clause", `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+   * Neither the name of Google Inc. nor the names of its
+contributors may be used to endorse or promote products`, "BSD-3-Clause"},
+		{"BSD-2-Clause", `Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:`, "BSD-2-Clause"},
+		{"ISC", `Permission to use, copy, modify, and/or distribute this software for any
+purpose with or without fee is hereby granted, provided that the above`, "ISC"},
+		{"MPL", `Mozilla Public License Version 2.0
+==================================`, "MPL-2.0"},
+		{"GPL-3.0", `GNU GENERAL PUBLIC LICENSE
+Version 3, 29 June 2007`, "GPL-3.0"},
+		{"GPL-2.0", `GNU GENERAL PUBLIC LICENSE
+Version 2, June 1991`, "GPL-2.0"},
+		{"LGPL is not GPL", `GNU LESSER GENERAL PUBLIC LICENSE
+Version 3, 29 June 2007`, "LGPL-3.0"},
+		{"AGPL is not GPL", `GNU AFFERO GENERAL PUBLIC LICENSE
+Version 3, 19 November 2007`, "AGPL-3.0"},
+		{"Unlicense", `This is free and unencumbered software released into the public domain.`, "Unlicense"},
+		{"unknown", `All rights reserved. Do not distribute.`, LicenseUnknown},
+		{"empty", ``, LicenseUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyLicense(tt.text); got != tt.want {
+			t.Errorf("%s: ClassifyLicense = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLicenseOptionsDenies(t *testing.T) {
+	opts := LicenseOptions{
+		Deny:       []string{"GPL-*", "agpl-3.0", LicenseUnknown},
+		Exceptions: []string{"github.com/approved/*"},
+	}
+
+	tests := []struct {
+		module  string
+		license string
+		want    bool
+	}{
+		{"github.com/a/b", "MIT", false},
+		{"github.com/a/b", "GPL-3.0", true},
+		{"github.com/a/b", "LGPL-3.0", false},
+		{"github.com/a/b", "AGPL-3.0", true},
+		{"github.com/a/b", LicenseUnknown, true},
+		{"github.com/approved/gpl", "GPL-2.0", false},
+	}
+
+	for _, tt := range tests {
+		if got := opts.denies(ModuleLicense{Module: tt.module, License: tt.license}); got != tt.want {
+			t.Errorf("denies(%s, %s) = %t, want %t", tt.module, tt.license, got, tt.want)
+		}
+	}
+}
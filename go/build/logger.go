@@ -0,0 +1,399 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of an Event.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// EventKind says whether an Event starts a step, ends one, or neither.
+type EventKind string
+
+const (
+	EventMessage   EventKind = "message"
+	EventStepStart EventKind = "start"
+	EventStepEnd   EventKind = "end"
+)
+
+// Fields are the structured values attached to an Event.
+type Fields map[string]interface{}
+
+// Event is something which happened during a build.
+type Event struct {
+	Time    time.Time
+	Level   Level
+	Kind    EventKind
+	Step    Step
+	Message string
+	Fields  Fields
+	// Duration is how long the step took, for EventStepEnd events.
+	Duration time.Duration
+	// Flow identifies the run of a step which the event belongs to,
+	// so the events of steps run in parallel can be told apart.
+	Flow string
+}
+
+// Logger receives the events logged by this package.
+// The logger in use can be replaced with SetLogger, or for
+// a single operation by passing a context from WithLogger.
+type Logger interface {
+	Log(e Event)
+}
+
+// Log formats, as accepted by NewLogger and the CI_LOG_FORMAT environment variable.
+const (
+	LogFormatText     = "text"
+	LogFormatJSON     = "json"
+	LogFormatTeamCity = "teamcity"
+)
+
+// NewLogger returns a logger which writes events to out in the given format.
+func NewLogger(format string, out io.Writer) (Logger, error) {
+	switch format {
+	case LogFormatText, "":
+		return &TextLogger{Out: out, Level: LevelInfo}, nil
+	case LogFormatJSON:
+		return &JSONLogger{Out: out}, nil
+	case LogFormatTeamCity:
+		return &TeamCityLogger{Out: out}, nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (expected %s, %s or %s)", format, LogFormatText, LogFormatJSON, LogFormatTeamCity)
+}
+
+// defaultLogger uses the format in CI_LOG_FORMAT if it is set, TeamCity
+// service messages when running on TeamCity, and text otherwise.
+func defaultLogger() Logger {
+	format := os.Getenv("CI_LOG_FORMAT")
+	if format == "" && RunningOnTeamCity() {
+		format = LogFormatTeamCity
+	}
+
+	out := os.Stderr
+	if format == LogFormatTeamCity {
+		out = os.Stdout
+	}
+
+	logger, err := NewLogger(format, out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, using text\n", err)
+		logger, _ = NewLogger(LogFormatText, out)
+	}
+	if t, ok := logger.(*TextLogger); ok {
+		if verbose, _ := strconv.ParseBool(os.Getenv("MAGEFILE_VERBOSE")); verbose {
+			t.Level = LevelDebug
+		}
+	}
+	return logger
+}
+
+var (
+	loggerMu      sync.RWMutex
+	currentLogger = defaultLogger()
+)
+
+// SetLogger replaces the Logger used by this package and returns the previous one.
+// Passing nil restores the default logger.
+func SetLogger(l Logger) Logger {
+	if l == nil {
+		l = defaultLogger()
+	}
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	previous := currentLogger
+	currentLogger = l
+	return previous
+}
+
+// CurrentLogger returns the Logger used by this package.
+func CurrentLogger() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return currentLogger
+}
+
+// WithLogger returns a context which makes the operations it is passed to
+// log to l instead of the logger set with SetLogger.
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+func loggerFrom(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey).(Logger); ok && l != nil {
+		return l
+	}
+	return CurrentLogger()
+}
+
+// logEvent logs a message which is not the start or end of a step.
+func logEvent(ctx context.Context, level Level, step Step, fields Fields, format string, args ...interface{}) {
+	loggerFrom(ctx).Log(Event{
+		Time:    time.Now(),
+		Level:   level,
+		Kind:    EventMessage,
+		Step:    step,
+		Message: fmt.Sprintf(format, args...),
+		Fields:  fields,
+	})
+}
+
+var flowCounter uint64
+
+// stepLog logs the start and end of a run of a step.
+type stepLog struct {
+	logger  Logger
+	step    Step
+	message string
+	fields  Fields
+	flow    string
+	start   time.Time
+}
+
+// beginStep logs the start of a run of step and returns a stepLog
+// whose end method must be called when it finishes.
+func beginStep(ctx context.Context, step Step, fields Fields, format string, args ...interface{}) *stepLog {
+	s := &stepLog{
+		logger:  loggerFrom(ctx),
+		step:    step,
+		message: fmt.Sprintf(format, args...),
+		fields:  fields,
+		flow:    fmt.Sprintf("%s-%d", step, atomic.AddUint64(&flowCounter, 1)),
+		start:   time.Now(),
+	}
+	s.logger.Log(Event{
+		Time:    s.start,
+		Level:   LevelInfo,
+		Kind:    EventStepStart,
+		Step:    step,
+		Message: s.message,
+		Fields:  fields,
+		Flow:    s.flow,
+	})
+	return s
+}
+
+// end logs the end of the step. If err is not nil the step is logged as
+// failed, with the error in the "error" field. It returns err.
+func (s *stepLog) end(err error) error {
+	return s.endWith(nil, err)
+}
+
+// endWith is end with extra fields describing the result.
+func (s *stepLog) endWith(fields Fields, err error) error {
+	all := Fields{}
+	for k, v := range s.fields {
+		all[k] = v
+	}
+	for k, v := range fields {
+		all[k] = v
+	}
+
+	level := LevelInfo
+	if err != nil {
+		level = LevelError
+		all["error"] = err.Error()
+	}
+
+	now := time.Now()
+	s.logger.Log(Event{
+		Time:     now,
+		Level:    level,
+		Kind:     EventStepEnd,
+		Step:     s.step,
+		Message:  s.message,
+		Fields:   all,
+		Duration: now.Sub(s.start),
+		Flow:     s.flow,
+	})
+	return err
+}
+
+func sortedFieldKeys(fields Fields) []string {
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TextLogger writes events as human readable lines.
+type TextLogger struct {
+	Out io.Writer
+	// Level is the lowest level which is written.
+	Level Level
+
+	mu sync.Mutex
+}
+
+func (l *TextLogger) Log(e Event) {
+	if e.Level < l.Level {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s", e.Time.Format("15:04:05"), strings.ToUpper(e.Level.String()))
+	if e.Step != "" {
+		fmt.Fprintf(&b, " [%s]", e.Step)
+	}
+
+	switch e.Kind {
+	case EventStepStart:
+		b.WriteString(" started:")
+	case EventStepEnd:
+		if e.Level >= LevelError {
+			b.WriteString(" failed:")
+		} else {
+			b.WriteString(" finished:")
+		}
+	}
+	b.WriteString(" " + e.Message)
+
+	if e.Kind == EventStepEnd {
+		fmt.Fprintf(&b, " duration=%s", e.Duration.Round(time.Millisecond))
+	}
+	for _, k := range sortedFieldKeys(e.Fields) {
+		v := fmt.Sprint(e.Fields[k])
+		if v == "" || strings.ContainsAny(v, " \t\r\n\"'=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.Out, b.String())
+}
+
+// JSONLogger writes each event as a line of JSON.
+type JSONLogger struct {
+	Out io.Writer
+
+	mu sync.Mutex
+}
+
+type jsonEvent struct {
+	Time       time.Time `json:"time"`
+	Level      string    `json:"level"`
+	Kind       EventKind `json:"kind"`
+	Step       Step      `json:"step,omitempty"`
+	Message    string    `json:"msg"`
+	Fields     Fields    `json:"fields,omitempty"`
+	DurationMS *int64    `json:"durationMs,omitempty"`
+	Flow       string    `json:"flow,omitempty"`
+}
+
+func (l *JSONLogger) Log(e Event) {
+	je := jsonEvent{
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Kind:    e.Kind,
+		Step:    e.Step,
+		Message: e.Message,
+		Fields:  e.Fields,
+		Flow:    e.Flow,
+	}
+	if e.Kind == EventStepEnd {
+		ms := int64(e.Duration / time.Millisecond)
+		je.DurationMS = &ms
+	}
+
+	b, err := json.Marshal(je)
+	if err != nil {
+		b, _ = json.Marshal(jsonEvent{Time: e.Time, Level: LevelError.String(), Kind: EventMessage, Message: fmt.Sprintf("could not encode event %q: %s", e.Message, err)})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.Out, string(b))
+}
+
+// TeamCityLogger writes events as TeamCity service messages. Steps are
+// written as blocks, and the duration of each step is reported as a
+// build statistic named ci.<step>.duration.
+type TeamCityLogger struct {
+	Out io.Writer
+
+	mu sync.Mutex
+}
+
+func (l *TeamCityLogger) Log(e Event) {
+	var lines []string
+
+	text := e.Message
+	for _, k := range sortedFieldKeys(e.Fields) {
+		text += fmt.Sprintf(" %s=%v", k, e.Fields[k])
+	}
+
+	name := string(e.Step)
+	if e.Message != "" {
+		name += ": " + e.Message
+	}
+
+	switch e.Kind {
+	case EventStepStart:
+		lines = append(lines, teamCityMessage("blockOpened", map[string]string{"name": name, "flowId": e.Flow}))
+	case EventStepEnd:
+		result := "finished"
+		if e.Level >= LevelError {
+			result = "failed"
+		}
+		text = fmt.Sprintf("%s %s in %s: %s", e.Step, result, e.Duration.Round(time.Millisecond), text)
+		lines = append(lines,
+			teamCityMessage("message", map[string]string{"text": text, "status": teamCityStatus(e.Level), "flowId": e.Flow}),
+			teamCityMessage("buildStatisticValue", map[string]string{"key": "ci." + string(e.Step) + ".duration", "value": strconv.FormatInt(int64(e.Duration/time.Millisecond), 10)}),
+			teamCityMessage("blockClosed", map[string]string{"name": name, "flowId": e.Flow}))
+	default:
+		if e.Level == LevelDebug {
+			return
+		}
+		lines = append(lines, teamCityMessage("message", map[string]string{"text": text, "status": teamCityStatus(e.Level), "flowId": e.Flow}))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range lines {
+		fmt.Fprintln(l.Out, line)
+	}
+}
+
+func teamCityStatus(level Level) string {
+	switch level {
+	case LevelWarn:
+		return "WARNING"
+	case LevelError:
+		return "ERROR"
+	}
+	return "NORMAL"
+}
@@ -0,0 +1,179 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingLogger keeps the events it is given.
+type recordingLogger struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *recordingLogger) Log(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+// testEvents are a build with a nested post-build step, as they are logged.
+func testEvents(loc *time.Location) []Event {
+	at := func(sec int) time.Time { return time.Date(2020, 1, 2, 3, 4, sec, 0, loc) }
+	return []Event{
+		{Time: at(5), Level: LevelInfo, Kind: EventStepStart, Step: StepBuild, Message: "building hello",
+			Fields: Fields{"target": "linux_amd64", "out": "dist/hello app"}, Flow: "build-1"},
+		{Time: at(5), Level: LevelDebug, Kind: EventMessage, Step: StepBuild, Message: "cache miss"},
+		{Time: at(6), Level: LevelInfo, Kind: EventStepStart, Step: StepPostBuild, Message: "strip",
+			Flow: "post-build-2"},
+		{Time: at(6), Level: LevelWarn, Kind: EventMessage, Step: StepPostBuild, Message: "it's [big] | huge\nreally",
+			Fields: Fields{"size": 10}},
+		{Time: at(7), Level: LevelInfo, Kind: EventStepEnd, Step: StepPostBuild, Message: "strip",
+			Fields: Fields{"size": 8}, Duration: 1500 * time.Millisecond, Flow: "post-build-2"},
+		{Time: at(8), Level: LevelError, Kind: EventStepEnd, Step: StepBuild, Message: "building hello",
+			Fields:   Fields{"target": "linux_amd64", "out": "dist/hello app", "error": "exit status 1"},
+			Duration: 3 * time.Second, Flow: "build-1"},
+	}
+}
+
+func logAll(l Logger, events []Event) {
+	for _, e := range events {
+		l.Log(e)
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	var out bytes.Buffer
+	logAll(&TextLogger{Out: &out, Level: LevelInfo}, testEvents(time.Local))
+
+	want := `03:04:05 INFO  [build] started: building hello out="dist/hello app" target=linux_amd64
+03:04:06 INFO  [post-build] started: strip
+03:04:06 WARN  [post-build] it's [big] | huge
+really size=10
+03:04:07 INFO  [post-build] finished: strip duration=1.5s size=8
+03:04:08 ERROR [build] failed: building hello duration=3s error="exit status 1" out="dist/hello app" target=linux_amd64
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	logAll(&TextLogger{Out: &out, Level: LevelDebug}, testEvents(time.Local)[:2])
+	if want := "03:04:05 DEBUG [build] cache miss\n"; !strings.HasSuffix(out.String(), want) {
+		t.Errorf("got\n%s\nwant it to end with the debug message", out.String())
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var out bytes.Buffer
+	logAll(&JSONLogger{Out: &out}, testEvents(time.UTC))
+
+	want := `{"time":"2020-01-02T03:04:05Z","level":"info","kind":"start","step":"build","msg":"building hello","fields":{"out":"dist/hello app","target":"linux_amd64"},"flow":"build-1"}
+{"time":"2020-01-02T03:04:05Z","level":"debug","kind":"message","step":"build","msg":"cache miss"}
+{"time":"2020-01-02T03:04:06Z","level":"info","kind":"start","step":"post-build","msg":"strip","flow":"post-build-2"}
+{"time":"2020-01-02T03:04:06Z","level":"warn","kind":"message","step":"post-build","msg":"it's [big] | huge\nreally","fields":{"size":10}}
+{"time":"2020-01-02T03:04:07Z","level":"info","kind":"end","step":"post-build","msg":"strip","fields":{"size":8},"durationMs":1500,"flow":"post-build-2"}
+{"time":"2020-01-02T03:04:08Z","level":"error","kind":"end","step":"build","msg":"building hello","fields":{"error":"exit status 1","out":"dist/hello app","target":"linux_amd64"},"durationMs":3000,"flow":"build-1"}
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestTeamCityLogger(t *testing.T) {
+	var out bytes.Buffer
+	logAll(&TeamCityLogger{Out: &out}, testEvents(time.UTC))
+
+	want := `##teamcity[blockOpened flowId='build-1' name='build: building hello']
+##teamcity[blockOpened flowId='post-build-2' name='post-build: strip']
+##teamcity[message status='WARNING' text='it|'s |[big|] || huge|nreally size=10']
+##teamcity[message flowId='post-build-2' status='NORMAL' text='post-build finished in 1.5s: strip size=8']
+##teamcity[buildStatisticValue key='ci.post-build.duration' value='1500']
+##teamcity[blockClosed flowId='post-build-2' name='post-build: strip']
+##teamcity[message flowId='build-1' status='ERROR' text='build failed in 3s: building hello error=exit status 1 out=dist/hello app target=linux_amd64']
+##teamcity[buildStatisticValue key='ci.build.duration' value='3000']
+##teamcity[blockClosed flowId='build-1' name='build: building hello']
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestCIEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"it's", "it|'s"},
+		{"[a|b]", "|[a||b|]"},
+		{"one\r\ntwo", "one|r|ntwo"},
+		{"next\u0085line sep para", "next|xline|lsep|ppara"},
+	}
+
+	for _, tt := range tests {
+		if got := ciEscape(tt.in); got != tt.want {
+			t.Errorf("ciEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestStepFlows checks nested and parallel steps get their own flows,
+// which their ends repeat.
+func TestStepFlows(t *testing.T) {
+	l := &recordingLogger{}
+	ctx := WithLogger(context.Background(), l)
+
+	outer := beginStep(ctx, StepBuild, Fields{"target": "linux_amd64"}, "building %s", "hello")
+	inner := beginStep(ctx, StepPostBuild, nil, "strip")
+	inner.endWith(Fields{"size": 8}, nil)
+	failed := errors.New("exit status 1")
+	if err := outer.end(failed); err != failed {
+		t.Errorf("end returned %v, want %v", err, failed)
+	}
+
+	if len(l.events) != 4 {
+		t.Fatalf("logged %d events, want 4", len(l.events))
+	}
+	start, innerStart, innerEnd, end := l.events[0], l.events[1], l.events[2], l.events[3]
+	if start.Flow == "" || start.Flow == innerStart.Flow {
+		t.Errorf("got flows %q and %q, want separate flows for nested steps", start.Flow, innerStart.Flow)
+	}
+	if end.Flow != start.Flow || innerEnd.Flow != innerStart.Flow {
+		t.Errorf("ends have flows %q and %q, want %q and %q", end.Flow, innerEnd.Flow, start.Flow, innerStart.Flow)
+	}
+	if end.Kind != EventStepEnd || end.Level != LevelError || end.Fields["error"] != "exit status 1" || end.Fields["target"] != "linux_amd64" {
+		t.Errorf("got end %+v, want a failed end with the start's fields", end)
+	}
+	if innerEnd.Level != LevelInfo || innerEnd.Fields["size"] != 8 || end.Message != "building hello" {
+		t.Errorf("got ends %+v and %+v", innerEnd, end)
+	}
+
+	// steps run in parallel never share a flow
+	l.events = nil
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			beginStep(ctx, StepBuild, nil, "building").end(nil)
+		}()
+	}
+	wg.Wait()
+	flows := map[string]int{}
+	for _, e := range l.events {
+		flows[e.Flow]++
+	}
+	if len(flows) != 20 {
+		t.Errorf("got %d flows for 20 steps", len(flows))
+	}
+	for flow, n := range flows {
+		if n != 2 {
+			t.Errorf("flow %s has %d events, want a start and an end", flow, n)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// PluginManifestFile is the manifest BuildPlugin reads from the project
+// and writes into each package.zip.
+const PluginManifestFile = "manifest.json"
+
+// PluginManifest describes a plugin to the platform. BuildPlugin fills in
+// Version, Icon, OS, Arch and Executable for each target.
+type PluginManifest struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	Description string `json:"description,omitempty"`
+	// Version must match the version of the package, if it is set.
+	Version string `json:"version,omitempty"`
+	// IconFile is the path of the plugin's icon, relative to the project.
+	IconFile string `json:"iconFile,omitempty"`
+	// Icon is the icon as a data URI.
+	Icon       string `json:"icon,omitempty"`
+	OS         string `json:"os,omitempty"`
+	Arch       string `json:"arch,omitempty"`
+	Executable string `json:"executable,omitempty"`
+	// Extra holds the fields this package does not know about,
+	// which are written back unchanged.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// pluginManifestFields are the JSON names of the fields of PluginManifest.
+var pluginManifestFields = []string{"id", "displayName", "description", "version", "iconFile", "icon", "os", "arch", "executable"}
+
+// pluginManifest has the fields of PluginManifest without its JSON methods.
+type pluginManifest PluginManifest
+
+func (m *PluginManifest) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*pluginManifest)(m)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &m.Extra); err != nil {
+		return err
+	}
+	for _, name := range pluginManifestFields {
+		delete(m.Extra, name)
+	}
+	if len(m.Extra) == 0 {
+		m.Extra = nil
+	}
+	return nil
+}
+
+func (m PluginManifest) MarshalJSON() ([]byte, error) {
+	known, err := json.Marshal(pluginManifest(m))
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(known, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range m.Extra {
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// ReadPluginManifest reads and validates the plugin manifest at path. The id and
+// displayName are required, and if version is not empty the manifest's version
+// must match it, ignoring a leading "v". Problems are returned as ConfigErrors
+// with the line and column they were found at.
+func ReadPluginManifest(path string, version string) (PluginManifest, error) {
+	var m PluginManifest
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, fmt.Errorf("plugin manifest %s not found", path)
+		}
+		return m, fmt.Errorf("could not read plugin manifest: %s", err)
+	}
+
+	if err = json.Unmarshal(data, &m); err != nil {
+		return m, manifestJSONError(path, data, err)
+	}
+
+	offsets := manifestKeyOffsets(data)
+	var errs ConfigErrors
+	errorf := func(key string, format string, args ...interface{}) {
+		e := ConfigError{File: path, Msg: fmt.Sprintf(format, args...)}
+		if offset, ok := offsets[key]; ok {
+			e.Line, e.Column = lineColumn(data, offset)
+		}
+		errs = append(errs, e)
+	}
+
+	if strings.TrimSpace(m.ID) == "" {
+		errorf("id", "id is required")
+	} else if strings.ContainsAny(m.ID, " \t\r\n/\\") {
+		errorf("id", "id %q must not contain spaces or slashes", m.ID)
+	}
+	if strings.TrimSpace(m.DisplayName) == "" {
+		errorf("displayName", "displayName is required")
+	}
+	if m.Version != "" {
+		if _, err = semver.NewVersion(strings.TrimPrefix(m.Version, "v")); err != nil {
+			errorf("version", "invalid version %q: %s", m.Version, err)
+		} else if version != "" && strings.TrimPrefix(m.Version, "v") != strings.TrimPrefix(version, "v") {
+			errorf("version", "version %q does not match the package version %q", m.Version, version)
+		}
+	}
+
+	if len(errs) > 0 {
+		return m, errs
+	}
+	return m, nil
+}
+
+// WritePluginManifest writes the manifest to path as indented JSON.
+func WritePluginManifest(path string, m PluginManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode plugin manifest: %s", err)
+	}
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write plugin manifest %q: %s", path, err)
+	}
+	return nil
+}
+
+// manifestJSONError converts an error from decoding the manifest into a
+// ConfigError with the position the decoder stopped at.
+func manifestJSONError(path string, data []byte, err error) error {
+	e := ConfigError{File: path, Msg: err.Error()}
+	switch err := err.(type) {
+	case *json.SyntaxError:
+		// the offset is after the byte which could not be read
+		e.Line, e.Column = lineColumn(data, err.Offset-1)
+		e.Msg = "invalid JSON: " + err.Error()
+	case *json.UnmarshalTypeError:
+		if err.Field != "" {
+			offset, ok := manifestKeyOffsets(data)[err.Field]
+			if !ok {
+				offset = err.Offset
+			}
+			e.Line, e.Column = lineColumn(data, offset)
+			e.Msg = fmt.Sprintf("%s must be a %s, not %s", err.Field, err.Type, withArticle(err.Value))
+		} else {
+			// the whole document is the wrong type, so point at its start
+			e.Line, e.Column = lineColumn(data, int64(len(data)-len(bytes.TrimLeft(data, " \t\r\n"))))
+			e.Msg = fmt.Sprintf("manifest must be a JSON object, not %s", withArticle(err.Value))
+		}
+	}
+	return ConfigErrors{e}
+}
+
+// withArticle prefixes a JSON type name with "a" or "an".
+func withArticle(s string) string {
+	if s != "" && strings.ContainsRune("aeiou", rune(s[0])) {
+		return "an " + s
+	}
+	return "a " + s
+}
+
+// manifestKeyOffsets returns the offset of each top level key in the JSON object data.
+func manifestKeyOffsets(data []byte) map[string]int64 {
+	offsets := map[string]int64{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return offsets
+	}
+	for dec.More() {
+		offset := dec.InputOffset()
+		t, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if key, ok := t.(string); ok {
+			// the offset is before any whitespace and the comma preceding the key
+			offsets[key] = offset + int64(bytes.IndexByte(data[offset:], '"'))
+		}
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			break
+		}
+	}
+	return offsets
+}
+
+// lineColumn returns the 1-based line and column of offset in data.
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	} else if offset < 0 {
+		offset = 0
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
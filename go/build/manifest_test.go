@@ -0,0 +1,120 @@
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadPluginManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		version  string
+		want     string
+	}{
+		{
+			name:     "valid",
+			manifest: `{"id": "hello", "displayName": "Hello", "version": "v1.2.3"}`,
+			version:  "1.2.3",
+		},
+		{
+			name:     "no version",
+			manifest: `{"id": "hello", "displayName": "Hello"}`,
+			version:  "1.2.3",
+		},
+		{
+			name:     "syntax error",
+			manifest: "{\n  \"id\": \"hello\",\n  \"displayName\": \"Hello\",,\n}",
+			want:     `manifest.json:3:26: invalid JSON: invalid character ',' looking for beginning of object key string`,
+		},
+		{
+			name:     "truncated",
+			manifest: "{\n  \"id\": \"hello\"",
+			want:     `manifest.json:2:15: invalid JSON: unexpected end of JSON input`,
+		},
+		{
+			name:     "wrong type",
+			manifest: "{\n  \"id\": \"hello\",\n  \"displayName\": 5\n}",
+			want:     `manifest.json:3:3: displayName must be a string, not a number`,
+		},
+		{
+			name:     "object instead of string",
+			manifest: "{\n  \"id\": {\"name\": \"hello\"},\n  \"displayName\": \"Hello\"\n}",
+			want:     `manifest.json:2:3: id must be a string, not an object`,
+		},
+		{
+			name:     "not an object",
+			manifest: `["hello"]`,
+			want:     `manifest.json:1:1: manifest must be a JSON object, not an array`,
+		},
+		{
+			name:     "missing fields",
+			manifest: "{\n  \"version\": \"1.0.0\"\n}",
+			version:  "1.2.3",
+			want: "manifest.json: id is required\n" +
+				"manifest.json: displayName is required\n" +
+				`manifest.json:2:3: version "1.0.0" does not match the package version "1.2.3"`,
+		},
+		{
+			name:     "invalid id and version",
+			manifest: "{\n  \"id\": \"my plugin\",\n  \"displayName\": \"Hello\",\n  \"version\": \"latest\"\n}",
+			want: `manifest.json:2:3: id "my plugin" must not contain spaces or slashes` + "\n" +
+				`manifest.json:4:3: invalid version "latest": latest is not in dotted-tri format`,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "manifest.json")
+			if err := ioutil.WriteFile(path, []byte(tt.manifest), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := ReadPluginManifest(path, tt.version)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if want := tt.want; want != "" {
+				want = strings.ReplaceAll(want, "manifest.json", path)
+				if got != want {
+					t.Errorf("got\n%s\nwant\n%s", got, want)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestPluginManifestKeepsUnknownFields(t *testing.T) {
+	in := `{"id":"hello","displayName":"Hello","kind":"publisher","schema":{"a":[1,2]}}`
+
+	var m PluginManifest
+	if err := json.Unmarshal([]byte(in), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Extra) != 2 {
+		t.Errorf("got extra fields %v, want kind and schema", m.Extra)
+	}
+
+	m.Version = "1.2.3"
+	out, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"displayName":"Hello","id":"hello","kind":"publisher","schema":{"a":[1,2]},"version":"1.2.3"}`
+	if string(out) != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
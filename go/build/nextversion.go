@@ -0,0 +1,181 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// Bump is the part of a version which is incremented for a release.
+type Bump int
+
+const (
+	BumpNone Bump = iota
+	BumpPatch
+	BumpMinor
+	BumpMajor
+)
+
+func (b Bump) String() string {
+	switch b {
+	case BumpPatch:
+		return "patch"
+	case BumpMinor:
+		return "minor"
+	case BumpMajor:
+		return "major"
+	}
+	return "none"
+}
+
+func (b Bump) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// CommitBump returns the bump a commit calls for: major for breaking changes,
+// minor for "feat" and patch for "fix". Other commits do not need a release.
+func CommitBump(c Commit) Bump {
+	switch {
+	case c.Breaking:
+		return BumpMajor
+	case c.Type == "feat":
+		return BumpMinor
+	case c.Type == "fix":
+		return BumpPatch
+	}
+	return BumpNone
+}
+
+// NextVersionResult is the version worked out by NextVersion.
+type NextVersionResult struct {
+	// Tag is the latest release tag reachable from HEAD, or empty if there is none.
+	Tag     string         `json:"tag,omitempty"`
+	Current semver.Version `json:"current"`
+	Next    semver.Version `json:"next"`
+	Bump    Bump           `json:"bump"`
+	// Commits are the commits since Tag, newest first.
+	Commits []Commit `json:"commits"`
+}
+
+// NextTag returns the tag for the next version, such as "v1.3.0".
+func (r NextVersionResult) NextTag() string {
+	return "v" + r.Next.String()
+}
+
+func (r NextVersionResult) String() string {
+	return r.Next.String()
+}
+
+// NextVersion reads the commits since the latest release tag reachable
+// from HEAD and works out the next version by the conventional commit
+// rules in CommitBump, using the largest bump any commit calls for.
+// Prerelease tags are ignored. If no commit calls for a release,
+// Next is the current version and Bump is BumpNone.
+func NextVersion() (NextVersionResult, error) {
+	return NextVersionContext(context.Background())
+}
+
+func NextVersionContext(ctx context.Context) (NextVersionResult, error) {
+	var result NextVersionResult
+
+	tag, current, err := latestVersionTag(ctx, false)
+	if err != nil {
+		return result, err
+	}
+	result.Tag = tag
+	result.Current = current
+
+	if result.Commits, err = GitCommitsContext(ctx, tag, "HEAD"); err != nil {
+		return result, err
+	}
+
+	for _, c := range result.Commits {
+		if b := CommitBump(c); b > result.Bump {
+			result.Bump = b
+		}
+	}
+
+	result.Next = semver.Version{Major: current.Major, Minor: current.Minor, Patch: current.Patch}
+	switch result.Bump {
+	case BumpMajor:
+		result.Next.BumpMajor()
+	case BumpMinor:
+		result.Next.BumpMinor()
+	case BumpPatch:
+		result.Next.BumpPatch()
+	}
+
+	return result, nil
+}
+
+// TagRelease creates an annotated tag for version on HEAD and, if remote is
+// not empty, pushes it there. It is safe to repeat: a tag which already
+// exists on HEAD is not created again, and one which the remote already has
+// is not pushed again. A tag which exists on another commit is an error.
+// It returns the tag.
+func TagRelease(version semver.Version, remote string) (string, error) {
+	return TagReleaseContext(context.Background(), version, remote)
+}
+
+func TagReleaseContext(ctx context.Context, version semver.Version, remote string) (string, error) {
+	tag := "v" + version.String()
+
+	head, err := GitHashContext(ctx)
+	if err != nil {
+		return tag, fmt.Errorf("could not determine git commit: %s", err)
+	}
+
+	// git tag --list succeeds with no output if the tag does not exist,
+	// so any error is a real failure rather than a missing tag
+	var tagged string
+	existing, err := gitOutput(ctx, "tag", "--list", tag)
+	if err != nil {
+		return tag, fmt.Errorf("could not look for tag %s: %s", tag, err)
+	}
+	if existing != "" {
+		if tagged, err = gitOutput(ctx, "rev-parse", "--verify", "refs/tags/"+tag+"^{commit}"); err != nil {
+			return tag, fmt.Errorf("could not resolve tag %s: %s", tag, err)
+		}
+	}
+
+	switch {
+	case tagged == "":
+		if err = GitTagContext(ctx, tag, "Release "+tag); err != nil {
+			return tag, fmt.Errorf("could not create tag %s: %s", tag, err)
+		}
+		logEvent(ctx, LevelInfo, StepGit, Fields{"commit": head}, "created tag %s", tag)
+	case tagged != head:
+		return tag, fmt.Errorf("tag %s already exists on commit %s, not HEAD (%s)", tag, tagged, head)
+	default:
+		logEvent(ctx, LevelInfo, StepGit, Fields{"commit": head}, "tag %s already exists", tag)
+	}
+
+	if remote == "" {
+		return tag, nil
+	}
+
+	local, err := gitOutput(ctx, "rev-parse", "refs/tags/"+tag)
+	if err != nil {
+		return tag, fmt.Errorf("could not resolve tag %s: %s", tag, err)
+	}
+	out, err := gitOutput(ctx, "ls-remote", "--tags", remote, "refs/tags/"+tag)
+	if err != nil {
+		return tag, fmt.Errorf("could not list tags on %s: %s", remote, err)
+	}
+	if fields := strings.Fields(out); len(fields) > 0 {
+		if fields[0] != local {
+			return tag, fmt.Errorf("tag %s on %s is %s, but the local tag is %s", tag, remote, fields[0], local)
+		}
+		logEvent(ctx, LevelInfo, StepGit, Fields{"remote": remote}, "tag %s has already been pushed", tag)
+		return tag, nil
+	}
+
+	if err = GitPushToRemoteContext(ctx, remote, "refs/tags/"+tag); err != nil {
+		return tag, fmt.Errorf("could not push tag %s to %s: %s", tag, remote, err)
+	}
+	logEvent(ctx, LevelInfo, StepGit, Fields{"remote": remote}, "pushed tag %s", tag)
+
+	return tag, nil
+}
@@ -0,0 +1,154 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// gitLogOutput formats commit messages the way GitCommits asks git log to.
+func gitLogOutput(messages ...string) string {
+	var records []string
+	for i, m := range messages {
+		records = append(records, strings.Join([]string{"hash" + string(rune('a'+i)), "dev", "1600000000", m}, commitFieldSep)+commitRecordSep)
+	}
+	return strings.Join(records, "\n")
+}
+
+func TestNextVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     string
+		messages []string
+		want     string
+		bump     Bump
+	}{
+		{name: "fix", tags: "v1.2.3", messages: []string{"fix: a bug", "docs: typo"}, want: "1.2.4", bump: BumpPatch},
+		{name: "feat", tags: "v1.2.3", messages: []string{"fix: a bug", "feat: a feature"}, want: "1.3.0", bump: BumpMinor},
+		{name: "breaking", tags: "v1.2.3", messages: []string{"feat!: a break", "fix: a bug"}, want: "2.0.0", bump: BumpMajor},
+		{name: "breaking footer", tags: "v1.2.3", messages: []string{"fix: x\n\nBREAKING CHANGE: y"}, want: "2.0.0", bump: BumpMajor},
+		{name: "nothing to release", tags: "v1.2.3", messages: []string{"chore: deps", "Update README"}, want: "1.2.3", bump: BumpNone},
+		{name: "prerelease tags ignored", tags: "v1.2.3\nv1.3.0-rc.1\nv1.2.2", messages: []string{"fix: a bug"}, want: "1.2.4", bump: BumpPatch},
+		{name: "no tags", tags: "", messages: []string{"feat: first"}, want: "0.1.0", bump: BumpMinor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RecordingRunner{}
+			r.Respond("git tag --merged HEAD", tt.tags, nil)
+			r.Respond("git log", gitLogOutput(tt.messages...), nil)
+
+			got, err := NextVersionContext(WithRunner(context.Background(), r))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Next.String() != tt.want || got.Bump != tt.bump {
+				t.Errorf("got %s (%s), want %s (%s)", got.Next, got.Bump, tt.want, tt.bump)
+			}
+			if len(got.Commits) != len(tt.messages) {
+				t.Errorf("got %d commits, want %d", len(got.Commits), len(tt.messages))
+			}
+
+			// only the commits since the tag are read
+			revs := "HEAD"
+			if got.Tag != "" {
+				revs = got.Tag + "..HEAD"
+			}
+			lines := r.Lines()
+			if last := lines[len(lines)-1]; !strings.HasSuffix(last, " "+revs) {
+				t.Errorf("read commits with %q, want them from %s", last, revs)
+			}
+		})
+	}
+}
+
+func TestTagRelease(t *testing.T) {
+	const head, other = "1111111111111111111111111111111111111111", "2222222222222222222222222222222222222222"
+	failed := fmt.Errorf("exit status 128")
+
+	tests := []struct {
+		name    string
+		tagList string
+		tagErr  error
+		tagged  string
+		remote  string
+		ls      string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "new tag",
+			want: []string{"git tag v1.2.3 -m 'Release v1.2.3'"},
+		},
+		{
+			name:    "existing tag on HEAD",
+			tagList: "v1.2.3",
+			tagged:  head,
+		},
+		{
+			name:    "existing tag elsewhere",
+			tagList: "v1.2.3",
+			tagged:  other,
+			wantErr: "tag v1.2.3 already exists on commit " + other + ", not HEAD (" + head + ")",
+		},
+		{
+			name:    "git fails",
+			tagErr:  failed,
+			wantErr: "could not look for tag v1.2.3: exit status 128",
+		},
+		{
+			name:   "push",
+			remote: "origin",
+			want:   []string{"git tag v1.2.3 -m 'Release v1.2.3'", "git push origin refs/tags/v1.2.3"},
+		},
+		{
+			name:    "already pushed",
+			tagList: "v1.2.3",
+			tagged:  head,
+			remote:  "origin",
+			ls:      head + "\trefs/tags/v1.2.3",
+		},
+		{
+			name:    "pushed elsewhere",
+			tagList: "v1.2.3",
+			tagged:  head,
+			remote:  "origin",
+			ls:      other + "\trefs/tags/v1.2.3",
+			wantErr: "tag v1.2.3 on origin is " + other + ", but the local tag is " + head,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RecordingRunner{}
+			r.Respond("git rev-parse HEAD", head, nil)
+			r.Respond("git tag --list", tt.tagList, tt.tagErr)
+			r.Respond("git rev-parse --verify", tt.tagged, nil)
+			r.Respond("git rev-parse refs/tags/v1.2.3", head, nil)
+			r.Respond("git ls-remote", tt.ls, nil)
+
+			_, err := TagReleaseContext(WithRunner(context.Background(), r), *semver.New("1.2.3"), tt.remote)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %s", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			var changes []string
+			for _, line := range r.Lines() {
+				if strings.HasPrefix(line, "git tag v") || strings.HasPrefix(line, "git push") {
+					changes = append(changes, line)
+				}
+			}
+			if !reflect.DeepEqual(changes, tt.want) {
+				t.Errorf("ran %q, want %q", changes, tt.want)
+			}
+		})
+	}
+}
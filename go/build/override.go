@@ -0,0 +1,90 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// TargetOverride changes how a Package is built for the targets matching Targets.
+// When several overrides match a target they are applied in order: tags, ldflags
+// and env are combined, and later values of CGOEnabled and OutName win.
+type TargetOverride struct {
+	// Targets are the patterns of the targets this override applies to,
+	// such as "linux/*" or "windows/386". If empty it applies to all targets.
+	Targets TargetPatterns
+	// Tags are added to the -tags build flag.
+	Tags []string
+	// Ldflags are added to the -ldflags build flag, after the package's own.
+	Ldflags []string
+	// Env is added to the build environment, e.g. to set CC when cross compiling with CGO.
+	Env map[string]string
+	// CGOEnabled, if set, replaces Package.CGOEnabled.
+	CGOEnabled *bool
+	// OutName, if set, replaces the file name of the output. Like Package.OutTemplate
+	// it is a template which is passed a Build. The directory of the output is unchanged.
+	OutName string
+}
+
+// override combines the overrides which apply to t.
+func (pkg Package) override(t PackageTarget) TargetOverride {
+	var combined TargetOverride
+
+	for _, o := range pkg.Overrides {
+		if !o.Targets.Match(t) {
+			continue
+		}
+		combined.Tags = append(combined.Tags, o.Tags...)
+		combined.Ldflags = append(combined.Ldflags, o.Ldflags...)
+		for k, v := range o.Env {
+			if combined.Env == nil {
+				combined.Env = map[string]string{}
+			}
+			combined.Env[k] = v
+		}
+		if o.CGOEnabled != nil {
+			combined.CGOEnabled = o.CGOEnabled
+		}
+		if o.OutName != "" {
+			combined.OutName = o.OutName
+		}
+	}
+
+	return combined
+}
+
+func (o TargetOverride) outName(pkg Package, t PackageTarget) (string, error) {
+	outTemplate, err := template.New("outName").Parse(o.OutName)
+	if err != nil {
+		return "", fmt.Errorf("parsing override OutName %q: %s", o.OutName, err)
+	}
+
+	b := new(strings.Builder)
+	if err = outTemplate.Execute(b, Build{pkg, t}); err != nil {
+		return "", fmt.Errorf("executing override OutName %q: %s", o.OutName, err)
+	}
+
+	name := b.String()
+	if t.OS == "windows" && !strings.HasSuffix(name, ".exe") {
+		name += ".exe"
+	}
+	return name, nil
+}
+
+// mergeTags removes any -tags from args and returns args with a single
+// -tags containing the tags the caller supplied followed by tags.
+func mergeTags(args []string, tags []string) []string {
+	if len(tags) == 0 {
+		return args
+	}
+
+	out, values := extractFlag(args, "tags")
+
+	var merged []string
+	for _, v := range values {
+		merged = append(merged, strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })...)
+	}
+	merged = append(merged, tags...)
+
+	return append(out, "-tags="+strings.Join(merged, ","))
+}
@@ -0,0 +1,149 @@
+package build
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPackageOverride(t *testing.T) {
+	yes, no := true, false
+	pkg := Package{Overrides: []TargetOverride{
+		{Tags: []string{"netgo"}, Ldflags: []string{"-s"}, Env: map[string]string{"CC": "gcc", "A": "1"}},
+		{Targets: TargetPatterns{"windows/*"}, Tags: []string{"windows"}, CGOEnabled: &yes, OutName: "first.exe"},
+		{Targets: TargetPatterns{"linux/*"}, Ldflags: []string{"-w"}, Env: map[string]string{"CC": "musl-gcc"}, CGOEnabled: &yes},
+		{Targets: TargetPatterns{"windows/amd64"}, Env: map[string]string{"A": "2"}, CGOEnabled: &no, OutName: "{{.Package.Name}}-win"},
+	}}
+
+	tests := []struct {
+		target PackageTarget
+		want   TargetOverride
+	}{
+		{
+			// env is combined, with later values winning
+			target: TargetLinuxAmd64,
+			want: TargetOverride{
+				Tags:       []string{"netgo"},
+				Ldflags:    []string{"-s", "-w"},
+				Env:        map[string]string{"CC": "musl-gcc", "A": "1"},
+				CGOEnabled: &yes,
+			},
+		},
+		{
+			// the last CGOEnabled and OutName win
+			target: TargetWindowsAmd64,
+			want: TargetOverride{
+				Tags:       []string{"netgo", "windows"},
+				Ldflags:    []string{"-s"},
+				Env:        map[string]string{"CC": "gcc", "A": "2"},
+				CGOEnabled: &no,
+				OutName:    "{{.Package.Name}}-win",
+			},
+		},
+		{
+			target: PackageTarget{OS: "windows", Arch: "386"},
+			want: TargetOverride{
+				Tags:       []string{"netgo", "windows"},
+				Ldflags:    []string{"-s"},
+				Env:        map[string]string{"CC": "gcc", "A": "1"},
+				CGOEnabled: &yes,
+				OutName:    "first.exe",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := pkg.override(tt.target); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("override(%s) = %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+
+	if got := (Package{}).override(TargetLinuxAmd64); !reflect.DeepEqual(got, TargetOverride{}) {
+		t.Errorf("override without overrides = %+v, want none", got)
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	tests := []struct {
+		args []string
+		tags []string
+		want []string
+	}{
+		{[]string{"-v"}, nil, []string{"-v"}},
+		{[]string{"-v"}, []string{"netgo"}, []string{"-v", "-tags=netgo"}},
+		// the caller's tags come first, in either separator style
+		{[]string{"-tags", "a,b", "-v", "--tags=c d"}, []string{"netgo", "osusergo"}, []string{"-v", "-tags=a,b,c,d,netgo,osusergo"}},
+	}
+
+	for _, tt := range tests {
+		if got := mergeTags(tt.args, tt.tags); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeTags(%q, %q) = %q, want %q", tt.args, tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestTargetOverrideOutName(t *testing.T) {
+	pkg := Package{Name: "hello", VersionString: "1.2.3"}
+	tests := []struct {
+		outName string
+		target  PackageTarget
+		want    string
+	}{
+		{"{{.Package.Name}}-{{.PackageTarget.Arch}}", TargetLinuxAmd64, "hello-amd64"},
+		{"{{.Package.Name}}", TargetWindowsAmd64, "hello.exe"},
+		{"{{.Package.Name}}.exe", TargetWindowsAmd64, "hello.exe"},
+	}
+
+	for _, tt := range tests {
+		got, err := TargetOverride{OutName: tt.outName}.outName(pkg, tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("outName %q for %s = %s, want %s", tt.outName, tt.target, got, tt.want)
+		}
+	}
+
+	if _, err := (TargetOverride{OutName: "{{.Nope"}).outName(pkg, TargetLinuxAmd64); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+// TestBuildPackageAppliesOverrides checks the overrides reach the go build:
+// override env wins over the target's, and override ldflags follow the package's.
+func TestBuildPackageAppliesOverrides(t *testing.T) {
+	pkg, cleanup := newTestPackage(t)
+	defer cleanup()
+	yes := true
+	pkg.BuildArgs = []string{"-tags=dev", "-ldflags=-X main.a=1"}
+	pkg.VersionVars = VersionVars{}
+	pkg.Overrides = []TargetOverride{{
+		Targets:    TargetPatterns{"linux/*"},
+		Tags:       []string{"netgo"},
+		Ldflags:    []string{"-s"},
+		Env:        map[string]string{"GOARCH": "arm64", "CC": "musl-gcc"},
+		CGOEnabled: &yes,
+		OutName:    "{{.Package.Name}}-linux",
+	}}
+
+	r := newFakeGoRunner()
+	if _, err := BuildPackageContext(WithRunner(context.Background(), r), pkg, TargetLinuxAmd64); err != nil {
+		t.Fatal(err)
+	}
+
+	var build Command
+	for _, cmd := range r.Commands() {
+		if cmd.Name == "go" && cmd.Args[0] == "build" {
+			build = cmd
+		}
+	}
+	wantArgs := []string{"build", "-o", filepath.Join(pkg.OutDir, "hello-linux"), "-tags=dev,netgo", "-ldflags=-X main.a=1 -s", "."}
+	if !reflect.DeepEqual(build.Args, wantArgs) {
+		t.Errorf("got args\n%q\nwant\n%q", build.Args, wantArgs)
+	}
+	wantEnv := map[string]string{"CGO_ENABLED": "1", "GOOS": "linux", "GOARCH": "arm64", "CC": "musl-gcc"}
+	if !reflect.DeepEqual(build.Env, wantEnv) {
+		t.Errorf("got env %v, want %v", build.Env, wantEnv)
+	}
+}
@@ -0,0 +1,202 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// PostBuildStep processes an artifact after a successful build,
+// for example to strip or compress the binary.
+type PostBuildStep interface {
+	// Name identifies the step in logs and results.
+	Name() string
+	// Applies returns true if the step should run for the target.
+	Applies(t PackageTarget) bool
+	// Run processes the artifact and returns the paths of any additional files it created.
+	Run(ctx context.Context, a Artifact) ([]string, error)
+}
+
+// PostBuildResult records the effect of a PostBuildStep on an artifact.
+type PostBuildResult struct {
+	Step       string   `json:"step"`
+	SizeBefore int64    `json:"sizeBefore"`
+	SizeAfter  int64    `json:"sizeAfter"`
+	Files      []string `json:"files,omitempty"`
+}
+
+// Saved returns the number of bytes the step removed from the artifact.
+func (r PostBuildResult) Saved() int64 {
+	return r.SizeBefore - r.SizeAfter
+}
+
+func runPostBuild(ctx context.Context, steps []PostBuildStep, a Artifact) ([]PostBuildResult, error) {
+	var results []PostBuildResult
+
+	for _, step := range steps {
+		if !step.Applies(a.Target) {
+			continue
+		}
+
+		result := PostBuildResult{Step: step.Name()}
+		result.SizeBefore = fileSize(a.Path)
+
+		progress := beginStep(ctx, StepPostBuild, Fields{"target": a.Target.String()}, "%s %s", step.Name(), a.Path)
+		stepCtx, cancel := stepContext(ctx, StepPostBuild)
+		files, err := step.Run(stepCtx, a)
+		cancel()
+		if err != nil {
+			return results, progress.end(fmt.Errorf("post-build step %s failed on %q: %s", step.Name(), a.Path, err))
+		}
+
+		result.Files = files
+		result.SizeAfter = fileSize(a.Path)
+		results = append(results, result)
+
+		progress.endWith(Fields{"saved": result.Saved()}, nil)
+	}
+
+	return results, nil
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// Strip removes the symbol table and debug information using the strip tool.
+// If Targets is empty it applies to linux targets.
+type Strip struct {
+	Targets TargetPatterns
+}
+
+func (s Strip) Name() string { return "strip" }
+
+func (s Strip) Applies(t PackageTarget) bool {
+	if len(s.Targets) == 0 {
+		return t.OS == "linux"
+	}
+	return s.Targets.Match(t)
+}
+
+func (s Strip) Run(ctx context.Context, a Artifact) ([]string, error) {
+	strip, err := EnsureToolContext(ctx, "strip")
+	if err != nil {
+		return nil, err
+	}
+	return nil, run(ctx, strip, a.Path)
+}
+
+// UPX compresses the artifact using upx. Level is the compression level
+// from 1 (fastest) to 9 (best); if zero, upx's default is used.
+// If Targets is empty it applies to all targets.
+type UPX struct {
+	Level   int
+	Targets TargetPatterns
+}
+
+func (u UPX) Name() string { return "upx" }
+
+func (u UPX) Applies(t PackageTarget) bool {
+	return u.Targets.Match(t)
+}
+
+func (u UPX) Run(ctx context.Context, a Artifact) ([]string, error) {
+	if u.Level < 0 || u.Level > 9 {
+		return nil, fmt.Errorf("upx level must be between 0 (upx's default) and 9, got %d", u.Level)
+	}
+	upx, err := EnsureToolContext(ctx, "upx")
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"-q"}
+	if u.Level > 0 {
+		args = append(args, "-"+strconv.Itoa(u.Level))
+	}
+	args = append(args, a.Path)
+
+	return nil, run(ctx, upx, args...)
+}
+
+// DebugSymbols moves the debug information into a separate {artifact}.debug file
+// using objcopy, and links the artifact to it. If Targets is empty it applies to linux targets.
+type DebugSymbols struct {
+	Targets TargetPatterns
+}
+
+func (d DebugSymbols) Name() string { return "debug-symbols" }
+
+func (d DebugSymbols) Applies(t PackageTarget) bool {
+	if len(d.Targets) == 0 {
+		return t.OS == "linux"
+	}
+	return d.Targets.Match(t)
+}
+
+func (d DebugSymbols) Run(ctx context.Context, a Artifact) ([]string, error) {
+	objcopy, err := EnsureToolContext(ctx, "objcopy")
+	if err != nil {
+		return nil, err
+	}
+
+	debugFile := a.Path + ".debug"
+	if err = run(ctx, objcopy, "--only-keep-debug", a.Path, debugFile); err != nil {
+		return nil, err
+	}
+	if err = run(ctx, objcopy, "--strip-debug", "--add-gnu-debuglink="+debugFile, a.Path); err != nil {
+		return nil, err
+	}
+
+	return []string{debugFile}, nil
+}
+
+// Checksum writes the SHA-256 digest of the artifact to {artifact}.sha256,
+// in the format used by sha256sum. If Targets is empty it applies to all targets.
+type Checksum struct {
+	Targets TargetPatterns
+}
+
+func (c Checksum) Name() string { return "checksum" }
+
+func (c Checksum) Applies(t PackageTarget) bool {
+	return c.Targets.Match(t)
+}
+
+func (c Checksum) Run(ctx context.Context, a Artifact) ([]string, error) {
+	if err := a.stat(); err != nil {
+		return nil, err
+	}
+
+	sumFile := a.Path + ".sha256"
+	line := fmt.Sprintf("%s  %s\n", a.SHA256, filepath.Base(a.Path))
+	if err := ioutil.WriteFile(sumFile, []byte(line), 0644); err != nil {
+		return nil, err
+	}
+
+	return []string{sumFile}, nil
+}
+
+// PostBuildFunc adapts a function to a PostBuildStep.
+// If Targets is empty it applies to all targets.
+type PostBuildFunc struct {
+	StepName string
+	Targets  TargetPatterns
+	Func     func(ctx context.Context, a Artifact) ([]string, error)
+}
+
+func (f PostBuildFunc) Name() string { return f.StepName }
+
+func (f PostBuildFunc) Applies(t PackageTarget) bool {
+	return f.Targets.Match(t)
+}
+
+func (f PostBuildFunc) Run(ctx context.Context, a Artifact) ([]string, error) {
+	return f.Func(ctx, a)
+}
@@ -0,0 +1,123 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunPostBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "postbuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hello")
+	if err = ioutil.WriteFile(path, []byte("0123456789"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var ran []string
+	step := func(name string, targets TargetPatterns, size int) PostBuildFunc {
+		return PostBuildFunc{StepName: name, Targets: targets, Func: func(ctx context.Context, a Artifact) ([]string, error) {
+			ran = append(ran, name)
+			return nil, ioutil.WriteFile(a.Path, make([]byte, size), 0755)
+		}}
+	}
+
+	steps := []PostBuildStep{
+		step("shrink", nil, 6),
+		step("windows only", TargetPatterns{"windows/*"}, 0),
+		Checksum{},
+		step("shrink again", TargetPatterns{"linux/*"}, 4),
+	}
+	results, err := runPostBuild(context.Background(), steps, Artifact{Target: TargetLinuxAmd64, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"shrink", "shrink again"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+	want := []PostBuildResult{
+		{Step: "shrink", SizeBefore: 10, SizeAfter: 6},
+		{Step: "checksum", SizeBefore: 6, SizeAfter: 6, Files: []string{path + ".sha256"}},
+		{Step: "shrink again", SizeBefore: 6, SizeAfter: 4},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got results\n%+v\nwant\n%+v", results, want)
+	}
+
+	sum, err := ioutil.ReadFile(path + ".sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%x  hello\n", sha256.Sum256(make([]byte, 6))); string(sum) != want {
+		t.Errorf("got checksum file %q, want %q", sum, want)
+	}
+}
+
+func TestRunPostBuildStopsAtFailure(t *testing.T) {
+	var ran []string
+	steps := []PostBuildStep{
+		PostBuildFunc{StepName: "fails", Func: func(ctx context.Context, a Artifact) ([]string, error) {
+			ran = append(ran, "fails")
+			return nil, fmt.Errorf("no space left")
+		}},
+		PostBuildFunc{StepName: "after", Func: func(ctx context.Context, a Artifact) ([]string, error) {
+			ran = append(ran, "after")
+			return nil, nil
+		}},
+	}
+
+	_, err := runPostBuild(context.Background(), steps, Artifact{Target: TargetLinuxAmd64, Path: "hello"})
+	if want := `post-build step fails failed on "hello": no space left`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+	if len(ran) != 1 {
+		t.Errorf("ran %v, want the steps after the failure skipped", ran)
+	}
+}
+
+func TestPostBuildStepsApply(t *testing.T) {
+	tests := []struct {
+		step   PostBuildStep
+		target PackageTarget
+		want   bool
+	}{
+		{Strip{}, TargetLinuxAmd64, true},
+		{Strip{}, TargetWindowsAmd64, false},
+		{Strip{Targets: TargetPatterns{"windows/*"}}, TargetWindowsAmd64, true},
+		{DebugSymbols{}, TargetLinuxAmd64, true},
+		{DebugSymbols{}, TargetDarwinAmd64, false},
+		{UPX{}, TargetWindowsAmd64, true},
+		{UPX{Targets: TargetPatterns{"linux/amd64"}}, TargetWindowsAmd64, false},
+		{Checksum{}, TargetDarwinAmd64, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.step.Applies(tt.target); got != tt.want {
+			t.Errorf("%s.Applies(%s) = %t, want %t", tt.step.Name(), tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestUPXLevelIsCheckedFirst(t *testing.T) {
+	for _, level := range []int{-1, 10} {
+		r := &RecordingRunner{}
+		_, err := UPX{Level: level}.Run(WithRunner(context.Background(), r), Artifact{Path: "hello"})
+		want := fmt.Sprintf("upx level must be between 0 (upx's default) and 9, got %d", level)
+		if err == nil || err.Error() != want {
+			t.Errorf("got error %v, want %s", err, want)
+		}
+		if lines := r.Lines(); len(lines) > 0 {
+			t.Errorf("level %d ran %v before failing", level, lines)
+		}
+	}
+}
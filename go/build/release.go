@@ -0,0 +1,503 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+const (
+	// DefaultReleaseDir is where ReleaseArchives writes archives and checksums
+	// if ArchiveOptions.Dir is empty.
+	DefaultReleaseDir = "./dist"
+
+	// releaseDirMarker is written into the release directory, so that
+	// ReleaseArchives only ever empties a directory it wrote.
+	releaseDirMarker = ".ci-release"
+
+	// DefaultArchiveNameTemplate names archives like goreleaser does,
+	// such as my-tool_1.2.3_linux_armv7.
+	DefaultArchiveNameTemplate = "{{.Name}}_{{.Version}}_{{.Os}}_{{.Arch}}{{.Variant}}"
+)
+
+var (
+	// DefaultArchiveReplacements are applied to the OS and architecture
+	// in archive names if ArchiveOptions.Replacements is nil.
+	DefaultArchiveReplacements = map[string]string{"darwin": "macOS"}
+
+	// DefaultArchiveFiles are added to every archive if ArchiveOptions.Files is nil.
+	DefaultArchiveFiles = []string{"LICENSE*", "README*", "CHANGELOG*"}
+
+	// DefaultDockerTags are the image tags used if DockerOptions.Tags is nil.
+	DefaultDockerTags = []string{"v{{.Version}}"}
+)
+
+// ArchiveOptions controls how release archives are named and what they contain.
+// The zero value archives like goreleaser's defaults.
+type ArchiveOptions struct {
+	// NameTemplate is a text/template for the archive name without its extension.
+	// It is passed an ArchiveName. If empty, DefaultArchiveNameTemplate is used.
+	NameTemplate string
+	// Replacements replace the OS and architecture in archive names.
+	// If nil, DefaultArchiveReplacements is used.
+	Replacements map[string]string
+	// Files are globs of extra files to add to each archive.
+	// If nil, DefaultArchiveFiles is used.
+	Files []string
+	// Flat puts the files at the root of the archive
+	// instead of in a directory named like the archive.
+	Flat bool
+	// Dir is the directory the release is written to. If empty,
+	// DefaultReleaseDir is used.
+	Dir string
+}
+
+// DockerOptions controls the image Release builds when Package.DockerRepo is set.
+type DockerOptions struct {
+	// Dockerfile is built with the binary copied next to it.
+	// If empty, "Dockerfile" is used.
+	Dockerfile string
+	// Target is the target whose binary goes into the image.
+	// If zero, linux/amd64 is used.
+	Target PackageTarget
+	// Tags are templates for the image tags, which are passed an ArchiveName.
+	// Images are named {DockerRepo}/{Name}:{tag}. If nil, DefaultDockerTags is used.
+	Tags []string
+	// Files are extra files copied into the build context.
+	Files []string
+	// BuildFlags are extra arguments to docker build.
+	BuildFlags []string
+}
+
+func (o DockerOptions) withDefaults() DockerOptions {
+	if o.Dockerfile == "" {
+		o.Dockerfile = "Dockerfile"
+	}
+	if o.Target == TargetLocal {
+		o.Target = TargetLinuxAmd64
+	}
+	if o.Tags == nil {
+		o.Tags = DefaultDockerTags
+	}
+	return o
+}
+
+// ArchiveName is passed to ArchiveOptions.NameTemplate and DockerOptions.Tags.
+type ArchiveName struct {
+	Name    string
+	Version string
+	Major   string
+	Minor   string
+	Patch   string
+	Os      string
+	Arch    string
+	// Variant is the target's architecture variant, such as "v7", or empty.
+	Variant string
+}
+
+// newArchiveName returns the ArchiveName of pkg built for t.
+func newArchiveName(pkg Package, t PackageTarget, replacements map[string]string) ArchiveName {
+	replace := func(s string) string {
+		if r, ok := replacements[s]; ok {
+			return r
+		}
+		return s
+	}
+
+	version, err := semver.NewVersion(strings.TrimPrefix(pkg.VersionString, "v"))
+	if err != nil {
+		version = &pkg.Version
+	}
+
+	return ArchiveName{
+		Name:    pkg.Name,
+		Version: pkg.VersionString,
+		Major:   strconv.FormatInt(version.Major, 10),
+		Minor:   strconv.FormatInt(version.Minor, 10),
+		Patch:   strconv.FormatInt(version.Patch, 10),
+		Os:      replace(t.OS),
+		Arch:    replace(t.Arch),
+		Variant: t.Variant,
+	}
+}
+
+// renderReleaseName executes a name template with data, naming the package name.
+func renderReleaseName(tmpl, name string, data ArchiveName) (string, error) {
+	parsed, err := template.New("name").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid name template %q: %s", tmpl, err)
+	}
+
+	data.Name = name
+
+	var b strings.Builder
+	if err = parsed.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("executing name template %q: %s", tmpl, err)
+	}
+	return b.String(), nil
+}
+
+// name returns the archive name for t, without its extension.
+func (o ArchiveOptions) name(pkg Package, t PackageTarget) (string, error) {
+	tmpl := o.NameTemplate
+	if tmpl == "" {
+		tmpl = DefaultArchiveNameTemplate
+	}
+	replacements := o.Replacements
+	if replacements == nil {
+		replacements = DefaultArchiveReplacements
+	}
+
+	return renderReleaseName(tmpl, pkg.Name, newArchiveName(pkg, t, replacements))
+}
+
+// files returns the extra files to add to each archive.
+func (o ArchiveOptions) files() ([]string, error) {
+	globs := o.Files
+	if globs == nil {
+		globs = DefaultArchiveFiles
+	}
+
+	var files []string
+	for _, glob := range globs {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive file pattern %q: %s", glob, err)
+		}
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && !info.IsDir() {
+				files = append(files, m)
+			}
+		}
+	}
+	return files, nil
+}
+
+// ReleaseArchive is an archive written by ReleaseArchives.
+type ReleaseArchive struct {
+	Target PackageTarget `json:"target"`
+	Path   string        `json:"path"`
+	Size   int64         `json:"size"`
+	SHA256 string        `json:"sha256"`
+}
+
+// ReleaseResult describes the files written by ReleaseArchives.
+type ReleaseResult struct {
+	Version   string           `json:"version"`
+	Dir       string           `json:"dir"`
+	Archives  []ReleaseArchive `json:"archives"`
+	Checksums string           `json:"checksums"`
+	// ReleaseNotes is the changelog since the previous release, if it could be generated.
+	ReleaseNotes string `json:"releaseNotes,omitempty"`
+	// Images are the docker images which were pushed.
+	Images []string `json:"images,omitempty"`
+	// Signature lists the signatures of the archives and checksums, if pkg.Sign is set.
+	Signature *SignResult `json:"signature,omitempty"`
+}
+
+// ReleaseArchives builds the package for each target (or DefaultReleaseTargets)
+// and writes a release into pkg.Archive.Dir (or DefaultReleaseDir), replacing
+// the previous release there. A directory which is not empty and was not
+// written by ReleaseArchives is left alone and is an error:
+//
+//   - an archive per target holding the binary and the files in pkg.Archive.Files,
+//     in a directory named like the archive unless pkg.Archive.Flat is set;
+//     tar.gz for unix and zip for windows
+//   - {name}_{version}_checksums.txt, with the sha256 of each archive
+//   - RELEASE_NOTES.md, from the changelog since the previous release
+//   - if pkg.Sign is set, SHA256SUMS and signatures of the archives and checksums
+//
+// If pkg.DockerRepo is set and there is a Dockerfile, an image is also built
+// as described by pkg.Docker and pushed. Nothing is
+// installed or downloaded to make the release.
+func ReleaseArchives(pkg Package, targets ...PackageTarget) (ReleaseResult, error) {
+	return ReleaseArchivesContext(context.Background(), pkg, targets...)
+}
+
+func ReleaseArchivesContext(ctx context.Context, pkg Package, targets ...PackageTarget) (ReleaseResult, error) {
+	result := ReleaseResult{
+		Version: pkg.VersionString,
+		Dir:     pkg.Archive.Dir,
+	}
+	if result.Dir == "" {
+		result.Dir = DefaultReleaseDir
+	}
+	if len(targets) == 0 {
+		targets = DefaultReleaseTargets
+	}
+
+	if !isDryRun(ctx) {
+		if err := cleanReleaseDir(result.Dir); err != nil {
+			return result, err
+		}
+	}
+
+	extraFiles, err := pkg.Archive.files()
+	if err != nil {
+		return result, err
+	}
+
+	// the archives are signed rather than the binaries
+	buildPkg := pkg
+	buildPkg.Sign = false
+	buildPkg.OutDir = ""
+	buildPkg.OutTemplate = filepath.ToSlash(filepath.Join(result.Dir, "build")) +
+		"/{{.PackageTarget}}/{{.Package.Name}}{{if eq .PackageTarget.OS `windows`}}.exe{{end}}"
+
+	artifacts, err := BuildPackagesContext(ctx, buildPkg, targets...)
+	if err != nil {
+		return result, err
+	}
+
+	modTime := time.Time{}
+	if pkg.Reproducible {
+		if modTime, err = GitCommitTimeContext(ctx); err != nil {
+			return result, fmt.Errorf("reproducible releases require the commit time: %s", err)
+		}
+	}
+
+	for _, artifact := range artifacts {
+		archive, err := writeReleaseArchive(ctx, pkg, artifact, extraFiles, result.Dir, modTime)
+		if err != nil {
+			return result, err
+		}
+		result.Archives = append(result.Archives, archive)
+	}
+
+	if result.Checksums, err = writeArchiveChecksums(ctx, pkg, result.Archives, result.Dir); err != nil {
+		return result, err
+	}
+
+	if pkg.Sign {
+		paths := []string{result.Checksums}
+		for _, a := range result.Archives {
+			paths = append(paths, a.Path)
+		}
+		signed, err := SignArtifactsContext(ctx, SignOptions{Dir: result.Dir}, paths...)
+		if err != nil {
+			return result, err
+		}
+		result.Signature = &signed
+	}
+
+	if !isDryRun(ctx) {
+		if notes, err := writeReleaseNotes(ctx, result.Dir, pkg); err != nil {
+			logEvent(ctx, LevelWarn, StepRelease, Fields{"error": err.Error()}, "could not generate release notes")
+		} else {
+			result.ReleaseNotes = notes
+		}
+	}
+
+	if result.Images, err = releaseDockerImage(ctx, pkg, artifacts); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// cleanReleaseDir empties dir, or creates it, and marks it as a release
+// directory. It refuses to empty a directory which has files in it but
+// was not marked, since it may be something other than an old release.
+func cleanReleaseDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read %q: %s", dir, err)
+	}
+	if len(files) > 0 {
+		if _, err = os.Stat(filepath.Join(dir, releaseDirMarker)); err != nil {
+			return fmt.Errorf("%q is not empty and was not written by a release; remove it or release into another directory", dir)
+		}
+		if err = os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("could not clean %q: %s", dir, err)
+		}
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create %q: %s", dir, err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, releaseDirMarker), nil, 0644); err != nil {
+		return fmt.Errorf("could not create %q: %s", dir, err)
+	}
+	return nil
+}
+
+func writeReleaseArchive(ctx context.Context, pkg Package, artifact Artifact, extraFiles []string, dir string, modTime time.Time) (ReleaseArchive, error) {
+	archive := ReleaseArchive{Target: artifact.Target}
+
+	name, err := pkg.Archive.name(pkg, artifact.Target)
+	if err != nil {
+		return archive, err
+	}
+
+	prefix := name + "/"
+	if pkg.Archive.Flat {
+		prefix = ""
+	}
+
+	entries := []archiveEntry{{Name: prefix + filepath.Base(artifact.Path), Path: artifact.Path}}
+	for _, f := range extraFiles {
+		entries = append(entries, archiveEntry{Name: prefix + filepath.ToSlash(f), Path: f})
+	}
+
+	step := beginStep(ctx, StepRelease, Fields{"target": artifact.Target.String()}, "archiving %s", name)
+
+	if artifact.Target.OS == "windows" {
+		archive.Path = filepath.Join(dir, name+".zip")
+	} else {
+		archive.Path = filepath.Join(dir, name+".tar.gz")
+	}
+
+	if isDryRun(ctx) {
+		return archive, step.end(nil)
+	}
+
+	if artifact.Target.OS == "windows" {
+		err = writeZipArchive(archive.Path, entries, modTime)
+	} else {
+		err = writeTarGz(archive.Path, entries, modTime)
+	}
+	if err != nil {
+		return archive, step.end(fmt.Errorf("could not write archive %q: %s", archive.Path, err))
+	}
+
+	a := Artifact{Path: archive.Path}
+	if err = a.stat(); err != nil {
+		return archive, step.end(err)
+	}
+	archive.Size, archive.SHA256 = a.Size, a.SHA256
+
+	return archive, step.endWith(Fields{"path": archive.Path, "size": archive.Size}, nil)
+}
+
+// writeArchiveChecksums writes the sha256 of each archive in the format
+// of sha256sum and returns the path of the file.
+func writeArchiveChecksums(ctx context.Context, pkg Package, archives []ReleaseArchive, dir string) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s_checksums.txt", pkg.Name, pkg.VersionString))
+	if isDryRun(ctx) {
+		return path, nil
+	}
+
+	sorted := append([]ReleaseArchive(nil), archives...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return filepath.Base(sorted[i].Path) < filepath.Base(sorted[j].Path)
+	})
+
+	var b bytes.Buffer
+	for _, a := range sorted {
+		fmt.Fprintf(&b, "%s  %s\n", a.SHA256, filepath.Base(a.Path))
+	}
+
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		return path, fmt.Errorf("could not write checksums: %s", err)
+	}
+	return path, nil
+}
+
+// releaseDockerImage builds pkg.Docker.Dockerfile with the binary of
+// pkg.Docker.Target copied into the build context, then tags the image as
+// {DockerRepo}/{Name}:{tag} for each of pkg.Docker.Tags and pushes it.
+// It does nothing if there is no DockerRepo, Dockerfile or binary.
+func releaseDockerImage(ctx context.Context, pkg Package, artifacts []Artifact) ([]string, error) {
+	if pkg.DockerRepo == "" {
+		return nil, nil
+	}
+	d := pkg.Docker.withDefaults()
+	if _, err := os.Stat(d.Dockerfile); err != nil {
+		logEvent(ctx, LevelInfo, StepDockerPush, nil, "there is no %s, not building an image", d.Dockerfile)
+		return nil, nil
+	}
+
+	var binary string
+	for _, a := range artifacts {
+		if a.Target == d.Target {
+			binary = a.Path
+		}
+	}
+	if binary == "" {
+		logEvent(ctx, LevelWarn, StepDockerPush, nil, "%s was not built, not building an image", d.Target)
+		return nil, nil
+	}
+
+	var images []string
+	for _, tag := range d.Tags {
+		tag, err := renderReleaseName(tag, pkg.Name, newArchiveName(pkg, d.Target, nil))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, fmt.Sprintf("%s/%s:%s", pkg.DockerRepo, pkg.Name, tag))
+	}
+	if len(images) == 0 {
+		return nil, nil
+	}
+
+	contextDir, err := ioutil.TempDir("", pkg.Name+"-docker")
+	if err != nil {
+		return nil, fmt.Errorf("could not create docker build context: %s", err)
+	}
+	defer os.RemoveAll(contextDir)
+
+	if err = CopyFileContext(ctx, d.Dockerfile, filepath.Join(contextDir, "Dockerfile")); err != nil {
+		return nil, fmt.Errorf("could not prepare docker build context: %s", err)
+	}
+	for _, f := range append([]string{binary}, d.Files...) {
+		if err = CopyFileContext(ctx, f, filepath.Join(contextDir, filepath.Base(f))); err != nil {
+			return nil, fmt.Errorf("could not prepare docker build context: %s", err)
+		}
+	}
+
+	args := []string{"build"}
+	for _, image := range images {
+		args = append(args, "-t", image)
+	}
+	args = append(args, d.BuildFlags...)
+	args = append(args, contextDir)
+
+	step := beginStep(ctx, StepDockerTag, Fields{"tags": len(images)}, "building %s", images[0])
+	buildCtx, cancel := stepContext(ctx, StepDockerTag)
+	err = step.end(run(buildCtx, "docker", args...))
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("error building image '%s': %s", images[0], err)
+	}
+
+	for _, image := range images {
+		step = beginStep(ctx, StepDockerPush, nil, "pushing %s", image)
+		pushCtx, cancel := stepContext(ctx, StepDockerPush)
+		err = step.end(run(pushCtx, "docker", "push", image))
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error pushing image '%s': %s", image, err)
+		}
+	}
+
+	return images, nil
+}
+
+// String lists the files in the release.
+func (r ReleaseResult) String() string {
+	var b bytes.Buffer
+	for _, a := range r.Archives {
+		fmt.Fprintln(&b, a.Path)
+	}
+	fmt.Fprintln(&b, r.Checksums)
+	if r.Signature != nil {
+		fmt.Fprintln(&b, r.Signature)
+	}
+	if r.ReleaseNotes != "" {
+		fmt.Fprintln(&b, r.ReleaseNotes)
+	}
+	for _, image := range r.Images {
+		fmt.Fprintln(&b, image)
+	}
+	return strings.TrimSpace(b.String())
+}
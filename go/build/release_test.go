@@ -0,0 +1,233 @@
+package build
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRelease returns a package whose release goes into a temp dir, and
+// changes into a project dir with a LICENSE and README to archive.
+func newTestRelease(t *testing.T) (Package, func()) {
+	pkg, cleanup := newTestPackage(t)
+	pkg.Archive.Dir = filepath.Join(pkg.OutDir, "dist")
+
+	project := filepath.Join(pkg.OutDir, "project")
+	if err := os.MkdirAll(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"LICENSE", "README.md", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(project, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(project); err != nil {
+		t.Fatal(err)
+	}
+
+	return pkg, func() {
+		os.Chdir(wd)
+		cleanup()
+	}
+}
+
+func tarGzNames(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, h.Name)
+	}
+	return names
+}
+
+func zipNames(t *testing.T, path string) []string {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestReleaseArchives(t *testing.T) {
+	pkg, cleanup := newTestRelease(t)
+	defer cleanup()
+
+	armv7 := PackageTarget{OS: "linux", Arch: "arm", Variant: "v7"}
+	result, err := ReleaseArchivesContext(WithRunner(context.Background(), newFakeGoRunner()), pkg,
+		TargetLinuxAmd64, TargetWindowsAmd64, armv7, TargetDarwinAmd64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Dir != pkg.Archive.Dir {
+		t.Errorf("released into %s, want %s", result.Dir, pkg.Archive.Dir)
+	}
+	var names []string
+	for _, a := range result.Archives {
+		names = append(names, filepath.Base(a.Path))
+		if filepath.Dir(a.Path) != pkg.Archive.Dir {
+			t.Errorf("wrote %s outside the release dir", a.Path)
+		}
+	}
+	want := []string{
+		"hello_1.2.3_linux_amd64.tar.gz",
+		"hello_1.2.3_windows_amd64.zip",
+		"hello_1.2.3_linux_armv7.tar.gz",
+		"hello_1.2.3_macOS_amd64.tar.gz",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got archives %q, want %q", names, want)
+	}
+
+	// the files are wrapped in a directory named like the archive
+	wantTar := []string{
+		"hello_1.2.3_linux_amd64/",
+		"hello_1.2.3_linux_amd64/hello",
+		"hello_1.2.3_linux_amd64/LICENSE",
+		"hello_1.2.3_linux_amd64/README.md",
+	}
+	if got := tarGzNames(t, result.Archives[0].Path); !reflect.DeepEqual(got, wantTar) {
+		t.Errorf("got tar entries %q, want %q", got, wantTar)
+	}
+	wantZip := []string{
+		"hello_1.2.3_windows_amd64/hello.exe",
+		"hello_1.2.3_windows_amd64/LICENSE",
+		"hello_1.2.3_windows_amd64/README.md",
+	}
+	if got := zipNames(t, result.Archives[1].Path); !reflect.DeepEqual(got, wantZip) {
+		t.Errorf("got zip entries %q, want %q", got, wantZip)
+	}
+
+	// the checksums are sorted by name
+	var sums []string
+	for _, i := range []int{0, 2, 3, 1} {
+		a := result.Archives[i]
+		data, err := ioutil.ReadFile(a.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := fmt.Sprintf("%x", sha256.Sum256(data))
+		if a.SHA256 != sum || a.Size != int64(len(data)) {
+			t.Errorf("%s has sha256 %s and size %d, want %s and %d", a.Path, a.SHA256, a.Size, sum, len(data))
+		}
+		sums = append(sums, sum+"  "+filepath.Base(a.Path)+"\n")
+	}
+	if result.Checksums != filepath.Join(pkg.Archive.Dir, "hello_1.2.3_checksums.txt") {
+		t.Errorf("got checksums file %s", result.Checksums)
+	}
+	checksums, err := ioutil.ReadFile(result.Checksums)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(sums, ""); string(checksums) != want {
+		t.Errorf("got checksums\n%s\nwant\n%s", checksums, want)
+	}
+}
+
+func TestReleaseArchivesFlat(t *testing.T) {
+	pkg, cleanup := newTestRelease(t)
+	defer cleanup()
+	pkg.Archive.Flat = true
+	pkg.Archive.Files = []string{"notes.txt"}
+	pkg.Archive.NameTemplate = "{{.Name}}-v{{.Major}}.{{.Minor}}-{{.Os}}-{{.Arch}}"
+	pkg.Archive.Replacements = map[string]string{"amd64": "x86_64"}
+	pkg.Reproducible = true
+
+	r := newFakeGoRunner()
+	r.Respond("git log -1 --format=%ct", "1577934245", nil)
+	result, err := ReleaseArchivesContext(WithRunner(context.Background(), r), pkg, TargetLinuxAmd64, TargetWindowsAmd64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := tarGzNames(t, result.Archives[0].Path), []string{"hello", "notes.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got tar entries %q, want %q", got, want)
+	}
+	if got, want := zipNames(t, result.Archives[1].Path), []string{"hello.exe", "notes.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got zip entries %q, want %q", got, want)
+	}
+	if got := filepath.Base(result.Archives[1].Path); got != "hello-v1.2-windows-x86_64.zip" {
+		t.Errorf("got archive %s", got)
+	}
+
+	// reproducible archives have the commit time
+	zr, err := zip.OpenReader(result.Archives[1].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if got, want := zr.File[0].Modified, time.Unix(1577934245, 0); !got.Equal(want) {
+		t.Errorf("got modification time %s, want %s", got, want)
+	}
+}
+
+func TestReleaseArchivesOnlyEmptiesReleaseDirs(t *testing.T) {
+	pkg, cleanup := newTestRelease(t)
+	defer cleanup()
+	ctx := WithRunner(context.Background(), newFakeGoRunner())
+
+	// a directory which a release did not write is left alone
+	if err := os.MkdirAll(pkg.Archive.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	precious := filepath.Join(pkg.Archive.Dir, "precious")
+	if err := ioutil.WriteFile(precious, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ReleaseArchivesContext(ctx, pkg, TargetLinuxAmd64)
+	if err == nil || !strings.Contains(err.Error(), "was not written by a release") {
+		t.Errorf("got error %v, want the directory refused", err)
+	}
+	if _, err = os.Stat(precious); err != nil {
+		t.Errorf("the file in the directory was removed: %s", err)
+	}
+
+	// the previous release is replaced
+	os.Remove(precious)
+	if _, err = ReleaseArchivesContext(ctx, pkg, TargetLinuxAmd64); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReleaseArchivesContext(ctx, pkg, TargetWindowsAmd64); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(pkg.Archive.Dir, "hello_1.2.3_linux_amd64.tar.gz")); !os.IsNotExist(err) {
+		t.Errorf("the previous release was not removed: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(pkg.Archive.Dir, "hello_1.2.3_windows_amd64.zip")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,463 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultReleaserConfigFile is the goreleaser config Release looks for,
+// and which InitReleaserConfig writes.
+const DefaultReleaserConfigFile = ".goreleaser.yml"
+
+// ReleaserTemplate renders a goreleaser config. It is executed with a
+// ReleaserData and may be replaced to customize every generated config.
+var ReleaserTemplate = template.Must(template.New("releaser").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+}).Parse(releaserConfig))
+
+const releaserConfig = `# .goreleaser.yml
+# Generated by github.com/naveego/ci/go/build from the {{.Name}} package,
+# for goreleaser v2.4 or later.
+version: 2
+project_name: {{.Name}}
+{{- with .Dist}}
+dist: {{quote .}}
+{{- end}}
+
+builds:
+  - id: {{.Name}}
+    main: {{quote .Main}}
+    binary: {{.Name}}
+    targets:
+{{- range .Targets}}
+      - {{.}}
+{{- end}}
+{{- with .Flags}}
+    flags:
+{{- range .}}
+      - {{quote .}}
+{{- end}}
+{{- end}}
+{{- with .Tags}}
+    tags:
+{{- range .}}
+      - {{quote .}}
+{{- end}}
+{{- end}}
+    ldflags:
+{{- range .Ldflags}}
+      - {{quote .}}
+{{- end}}
+    env:
+{{- range .Env}}
+      - {{quote .}}
+{{- end}}
+{{- with .ModTimestamp}}
+    mod_timestamp: {{quote .}}
+{{- end}}
+{{- with .Overrides}}
+    overrides:
+{{- range .}}
+      - goos: {{.OS}}
+        goarch: {{.Arch}}
+{{- with .Goarm}}
+        goarm: {{quote .}}
+{{- end}}
+{{- with .Goamd64}}
+        goamd64: {{quote .}}
+{{- end}}
+{{- with .Go386}}
+        go386: {{quote .}}
+{{- end}}
+{{- with .Goarm64}}
+        goarm64: {{quote .}}
+{{- end}}
+{{- with .Gomips}}
+        gomips: {{quote .}}
+{{- end}}
+{{- with .Goppc64}}
+        goppc64: {{quote .}}
+{{- end}}
+{{- with .Tags}}
+        tags:
+{{- range .}}
+          - {{quote .}}
+{{- end}}
+{{- end}}
+        ldflags:
+{{- range .Ldflags}}
+          - {{quote .}}
+{{- end}}
+        env:
+{{- range .Env}}
+          - {{quote .}}
+{{- end}}
+{{- end}}
+{{- end}}
+
+archives:
+  - name_template: {{quote .Archive.NameTemplate}}
+    format: tar.gz
+    format_overrides:
+      - goos: windows
+        format: zip
+    wrap_in_directory: {{.Archive.Wrap}}
+{{- with .Archive.Files}}
+    files:
+{{- range .}}
+      - {{quote .}}
+{{- end}}
+{{- end}}
+
+checksum:
+  name_template: {{quote .ChecksumNameTemplate}}
+{{- with .Dockers}}
+
+dockers:
+{{- range .}}
+  - goos: {{.OS}}
+    goarch: {{.Arch}}
+{{- with .Goarm}}
+    goarm: {{quote .}}
+{{- end}}
+    dockerfile: {{quote .Dockerfile}}
+    image_templates:
+{{- range .Images}}
+      - {{quote .}}
+{{- end}}
+{{- with .BuildFlags}}
+    build_flag_templates:
+{{- range .}}
+      - {{quote .}}
+{{- end}}
+{{- end}}
+{{- with .Files}}
+    extra_files:
+{{- range .}}
+      - {{quote .}}
+{{- end}}
+{{- end}}
+{{- end}}
+{{- end}}
+`
+
+// ReleaserData is passed to ReleaserTemplate. Values may contain goreleaser
+// template placeholders such as {{ .Version }}, which goreleaser expands.
+type ReleaserData struct {
+	Name string
+	Main string
+	// Dist is the directory goreleaser writes the release to, if not its default.
+	Dist string
+	// Targets are in goreleaser's form, such as linux_amd64 or linux_arm_7.
+	Targets              []string
+	Flags                []string
+	Tags                 []string
+	Ldflags              []string
+	Env                  []string
+	ModTimestamp         string
+	Overrides            []ReleaserOverride
+	Archive              ReleaserArchive
+	ChecksumNameTemplate string
+	Dockers              []ReleaserDocker
+}
+
+// ReleaserOverride is the build settings of a target with a TargetOverride.
+type ReleaserOverride struct {
+	OS, Arch                                        string
+	Goarm, Goamd64, Go386, Goarm64, Gomips, Goppc64 string
+	Tags                                            []string
+	Ldflags                                         []string
+	Env                                             []string
+}
+
+// ReleaserArchive is the archive settings of a goreleaser config. Goreleaser
+// has no replacements, so ArchiveOptions.Replacements are part of NameTemplate.
+type ReleaserArchive struct {
+	NameTemplate string
+	Wrap         bool
+	Files        []string
+}
+
+// ReleaserDocker is an image built by goreleaser.
+type ReleaserDocker struct {
+	OS, Arch, Goarm string
+	Dockerfile      string
+	Images          []string
+	BuildFlags      []string
+	Files           []string
+}
+
+// releaserPlaceholders returns the goreleaser equivalents of the fields of
+// ArchiveName, with replacements applied to the OS and architecture.
+func releaserPlaceholders(replacements map[string]string) ArchiveName {
+	return ArchiveName{
+		Version: "{{ .Version }}",
+		Major:   "{{ .Major }}",
+		Minor:   "{{ .Minor }}",
+		Patch:   "{{ .Patch }}",
+		Os:      releaserReplace(".Os", replacements),
+		Arch:    releaserReplace(".Arch", replacements),
+		Variant: "{{ if .Arm }}v{{ .Arm }}{{ end }}",
+	}
+}
+
+// releaserReplace returns a goreleaser template for field with replacements
+// applied, such as {{ if eq .Os "darwin" }}macOS{{ else }}{{ .Os }}{{ end }}.
+func releaserReplace(field string, replacements map[string]string) string {
+	var from []string
+	for k := range replacements {
+		from = append(from, k)
+	}
+	sort.Strings(from)
+
+	var b strings.Builder
+	for i, k := range from {
+		if i > 0 {
+			b.WriteString("{{ else if eq " + field + " " + strconv.Quote(k) + " }}")
+		} else {
+			b.WriteString("{{ if eq " + field + " " + strconv.Quote(k) + " }}")
+		}
+		b.WriteString(replacements[k])
+	}
+	if len(from) == 0 {
+		return "{{ " + field + " }}"
+	}
+	return b.String() + "{{ else }}{{ " + field + " }}{{ end }}"
+}
+
+// NewReleaserData describes a goreleaser config which builds pkg for targets
+// (or DefaultReleaseTargets) the way BuildPackage and ReleaseArchives would:
+// with the package's build args, version variables, overrides, archive
+// options and docker image.
+func NewReleaserData(pkg Package, targets ...PackageTarget) (ReleaserData, error) {
+	if len(targets) == 0 {
+		targets = DefaultReleaseTargets
+	}
+
+	data := ReleaserData{
+		Name:                 pkg.Name,
+		Main:                 pkg.Main,
+		Dist:                 pkg.Archive.Dir,
+		ChecksumNameTemplate: pkg.Name + "_{{ .Version }}_checksums.txt",
+	}
+	if data.Main == "" {
+		data.Main = "."
+	}
+
+	args, ldflags := extractFlag(pkg.BuildArgs, "ldflags")
+	args, tags := extractFlag(args, "tags")
+	data.Flags = args
+	for _, t := range tags {
+		data.Tags = append(data.Tags, strings.FieldsFunc(t, func(r rune) bool { return r == ',' || r == ' ' })...)
+	}
+
+	data.Ldflags = append([]string{"-s -w"}, releaserVersionLdflags(pkg)...)
+	if pkg.Reproducible {
+		data.Flags = append([]string{"-trimpath"}, data.Flags...)
+		data.Ldflags = append(data.Ldflags, "-buildid=")
+		data.ModTimestamp = "{{ .CommitTimestamp }}"
+	}
+	data.Ldflags = append(data.Ldflags, ldflags...)
+
+	baseEnv := map[string]string{"CGO_ENABLED": "0"}
+	if pkg.CGOEnabled {
+		baseEnv["CGO_ENABLED"] = "1"
+	}
+	data.Env = envList(baseEnv)
+
+	for _, t := range targets {
+		if t == TargetLocal {
+			continue
+		}
+		if _, _, err := t.variant(); err != nil {
+			return data, err
+		}
+		data.Targets = append(data.Targets, releaserTarget(t))
+
+		o := pkg.override(t)
+		if len(o.Tags) == 0 && len(o.Ldflags) == 0 && len(o.Env) == 0 && o.CGOEnabled == nil {
+			continue
+		}
+
+		override := ReleaserOverride{
+			OS:      t.OS,
+			Arch:    t.Arch,
+			Tags:    append(append([]string(nil), data.Tags...), o.Tags...),
+			Ldflags: append(append([]string(nil), data.Ldflags...), o.Ldflags...),
+		}
+		switch name, value := t.variantEnv(); name {
+		case "GOARM":
+			override.Goarm = value
+		case "GOAMD64":
+			override.Goamd64 = value
+		case "GO386":
+			override.Go386 = value
+		case "GOARM64":
+			override.Goarm64 = value
+		case "GOMIPS", "GOMIPS64":
+			override.Gomips = value
+		case "GOPPC64":
+			override.Goppc64 = value
+		}
+
+		env := map[string]string{}
+		for k, v := range baseEnv {
+			env[k] = v
+		}
+		if o.CGOEnabled != nil {
+			env["CGO_ENABLED"] = "0"
+			if *o.CGOEnabled {
+				env["CGO_ENABLED"] = "1"
+			}
+		}
+		for k, v := range o.Env {
+			env[k] = v
+		}
+		override.Env = envList(env)
+
+		data.Overrides = append(data.Overrides, override)
+	}
+
+	data.Archive = ReleaserArchive{
+		NameTemplate: pkg.Archive.NameTemplate,
+		Wrap:         !pkg.Archive.Flat,
+		Files:        pkg.Archive.Files,
+	}
+	if data.Archive.NameTemplate == "" {
+		data.Archive.NameTemplate = DefaultArchiveNameTemplate
+	}
+	replacements := pkg.Archive.Replacements
+	if replacements == nil {
+		replacements = DefaultArchiveReplacements
+	}
+	if data.Archive.Files == nil {
+		data.Archive.Files = DefaultArchiveFiles
+	}
+	name, err := renderReleaseName(data.Archive.NameTemplate, pkg.Name, releaserPlaceholders(replacements))
+	if err != nil {
+		return data, err
+	}
+	data.Archive.NameTemplate = name
+
+	if pkg.DockerRepo != "" {
+		d := pkg.Docker.withDefaults()
+		docker := ReleaserDocker{
+			OS:         d.Target.OS,
+			Arch:       d.Target.Arch,
+			Dockerfile: d.Dockerfile,
+			BuildFlags: d.BuildFlags,
+			Files:      d.Files,
+		}
+		if name, value := d.Target.variantEnv(); name == "GOARM" {
+			docker.Goarm = value
+		}
+		for _, tag := range d.Tags {
+			tag, err = renderReleaseName(tag, pkg.Name, releaserPlaceholders(nil))
+			if err != nil {
+				return data, err
+			}
+			docker.Images = append(docker.Images, pkg.DockerRepo+"/"+pkg.Name+":"+tag)
+		}
+		data.Dockers = append(data.Dockers, docker)
+	}
+
+	return data, nil
+}
+
+// releaserVersionLdflags sets pkg.VersionVars like versionLdflags and
+// buildDateLdflags do, using goreleaser's placeholders for the values.
+func releaserVersionLdflags(pkg Package) []string {
+	values := versionValues{
+		Version:     "{{ .Version }}",
+		BuildNumber: "{{ .Env.BUILD_NUMBER }}",
+		Commit:      "{{ .FullCommit }}",
+		Branch:      "{{ .Branch }}",
+		Dirty:       "{{ .IsGitDirty }}",
+		BuildDate:   "{{ .Date }}",
+	}
+	if pkg.Reproducible {
+		values.BuildDate = "{{ .CommitDate }}"
+	}
+
+	// each -X and its value are one entry in goreleaser's list
+	flags := pkg.VersionVars.ldflags(values)
+	var entries []string
+	for i := 0; i+1 < len(flags); i += 2 {
+		entries = append(entries, flags[i]+" "+flags[i+1])
+	}
+	return entries
+}
+
+// releaserTarget returns t in goreleaser's form, such as linux_arm_7.
+func releaserTarget(t PackageTarget) string {
+	target := t.OS + "_" + t.Arch
+	if _, value := t.variantEnv(); value != "" {
+		target += "_" + value
+	}
+	return target
+}
+
+func envList(env map[string]string) []string {
+	var list []string
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// GenerateReleaserConfig writes a goreleaser config for pkg and targets,
+// described by NewReleaserData and rendered with ReleaserTemplate, to w.
+func GenerateReleaserConfig(w io.Writer, pkg Package, targets ...PackageTarget) error {
+	data, err := NewReleaserData(pkg, targets...)
+	if err != nil {
+		return err
+	}
+	if err = ReleaserTemplate.Execute(w, data); err != nil {
+		return fmt.Errorf("could not render goreleaser config: %s", err)
+	}
+	return nil
+}
+
+// InitReleaserConfig writes a goreleaser config for pkg and targets to path
+// (or DefaultReleaserConfigFile), as a starting point to commit to the repo.
+// Once it exists Release uses goreleaser with it. An existing file is only
+// replaced if overwrite is true.
+func InitReleaserConfig(path string, pkg Package, overwrite bool, targets ...PackageTarget) (string, error) {
+	return InitReleaserConfigContext(context.Background(), path, pkg, overwrite, targets...)
+}
+
+func InitReleaserConfigContext(ctx context.Context, path string, pkg Package, overwrite bool, targets ...PackageTarget) (string, error) {
+	if path == "" {
+		path = DefaultReleaserConfigFile
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if os.IsExist(err) {
+		return path, fmt.Errorf("%s already exists", path)
+	}
+	if err != nil {
+		return path, fmt.Errorf("could not create %s: %s", path, err)
+	}
+	defer f.Close()
+
+	if err = GenerateReleaserConfig(f, pkg, targets...); err != nil {
+		return path, err
+	}
+	if err = f.Close(); err != nil {
+		return path, fmt.Errorf("could not write %s: %s", path, err)
+	}
+
+	logEvent(ctx, LevelInfo, StepRelease, nil, "wrote goreleaser config to %s", path)
+	return path, nil
+}
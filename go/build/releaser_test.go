@@ -0,0 +1,122 @@
+package build
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReleaserVersionLdflags(t *testing.T) {
+	pkg := Package{PackagePath: "example.com/hello", VersionVars: DefaultVersionVars("example.com/hello")}
+	pkg.VersionVars.Branch = ""
+
+	want := []string{
+		"-X 'example.com/hello/version.Version={{ .Version }}'",
+		"-X 'example.com/hello/version.BuildNumber={{ .Env.BUILD_NUMBER }}'",
+		"-X 'example.com/hello/version.Commit={{ .FullCommit }}'",
+		"-X 'example.com/hello/version.Dirty={{ .IsGitDirty }}'",
+		"-X 'example.com/hello/version.BuildDate={{ .Date }}'",
+	}
+	if got := releaserVersionLdflags(pkg); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	pkg.Reproducible = true
+	got := releaserVersionLdflags(pkg)
+	if last := got[len(got)-1]; last != "-X 'example.com/hello/version.BuildDate={{ .CommitDate }}'" {
+		t.Errorf("got build date flag %q, want the commit date", last)
+	}
+
+	if got := releaserVersionLdflags(Package{PackagePath: "example.com/hello"}); len(got) != 0 {
+		t.Errorf("got %q without VersionVars, want no flags", got)
+	}
+}
+
+func TestReleaserReplace(t *testing.T) {
+	tests := []struct {
+		replacements map[string]string
+		want         string
+	}{
+		{nil, "{{ .Os }}"},
+		{map[string]string{"darwin": "macOS"}, `{{ if eq .Os "darwin" }}macOS{{ else }}{{ .Os }}{{ end }}`},
+		{
+			map[string]string{"windows": "Windows", "darwin": "macOS"},
+			`{{ if eq .Os "darwin" }}macOS{{ else if eq .Os "windows" }}Windows{{ else }}{{ .Os }}{{ end }}`,
+		},
+	}
+	for _, tt := range tests {
+		if got := releaserReplace(".Os", tt.replacements); got != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.replacements, got, tt.want)
+		}
+	}
+}
+
+func TestNewReleaserDataOverrides(t *testing.T) {
+	pkg := Package{
+		Name:      "hello",
+		Overrides: []TargetOverride{{Tags: []string{"extra"}}},
+	}
+	targets := []PackageTarget{
+		{OS: "linux", Arch: "386", Variant: "softfloat"},
+		{OS: "linux", Arch: "arm64", Variant: "v8.2"},
+		{OS: "linux", Arch: "ppc64le", Variant: "power9"},
+		{OS: "linux", Arch: "arm", Variant: "v6"},
+	}
+
+	data, err := NewReleaserData(pkg, targets...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTargets := []string{"linux_386_softfloat", "linux_arm64_v8.2", "linux_ppc64le_power9", "linux_arm_6"}
+	if !reflect.DeepEqual(data.Targets, wantTargets) {
+		t.Errorf("got targets %q, want %q", data.Targets, wantTargets)
+	}
+
+	var got []ReleaserOverride
+	for _, o := range data.Overrides {
+		got = append(got, ReleaserOverride{OS: o.OS, Arch: o.Arch, Goarm: o.Goarm, Go386: o.Go386, Goarm64: o.Goarm64, Goppc64: o.Goppc64})
+	}
+	want := []ReleaserOverride{
+		{OS: "linux", Arch: "386", Go386: "softfloat"},
+		{OS: "linux", Arch: "arm64", Goarm64: "v8.2"},
+		{OS: "linux", Arch: "ppc64le", Goppc64: "power9"},
+		{OS: "linux", Arch: "arm", Goarm: "6"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got overrides %+v, want %+v", got, want)
+	}
+}
+
+func TestGenerateReleaserConfig(t *testing.T) {
+	pkg := Package{
+		Name:        "hello",
+		PackagePath: "example.com/hello",
+		VersionVars: DefaultVersionVars("example.com/hello"),
+		Overrides:   []TargetOverride{{Tags: []string{"extra"}}},
+	}
+	pkg.Archive.Dir = "release"
+
+	var buf bytes.Buffer
+	if err := GenerateReleaserConfig(&buf, pkg, PackageTarget{OS: "linux", Arch: "arm64", Variant: "v8.2"}, TargetDarwinAmd64); err != nil {
+		t.Fatal(err)
+	}
+	config := buf.String()
+
+	for _, want := range []string{
+		"\nversion: 2\n",
+		"\ndist: \"release\"\n",
+		"        goarm64: \"v8.2\"\n",
+		`{{ .IsGitDirty }}`,
+		`{{ if eq .Os \"darwin\" }}macOS{{ else }}{{ .Os }}{{ end }}`,
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("config does not contain %q:\n%s", want, config)
+		}
+	}
+	for _, old := range []string{"replacements:", "VersionBuild"} {
+		if strings.Contains(config, old) {
+			t.Errorf("config contains %q:\n%s", old, config)
+		}
+	}
+}
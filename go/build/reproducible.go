@@ -0,0 +1,91 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VerifyReproducible builds pkg for t twice with pkg.Reproducible set, and returns
+// an error if the outputs differ. Each build runs in its own copy of the module,
+// with its own empty GOCACHE, so that neither build can reuse the other's work.
+func VerifyReproducible(pkg Package, t PackageTarget) error {
+	return VerifyReproducibleContext(context.Background(), pkg, t)
+}
+
+// VerifyReproducibleContext is VerifyReproducible with a context.
+func VerifyReproducibleContext(ctx context.Context, pkg Package, t PackageTarget) error {
+	pkg.Reproducible = true
+	pkg.Force = true
+	pkg.OutTemplate = ""
+
+	root, rel, err := moduleRoot(ctx, pkg.Dir)
+	if err != nil {
+		return err
+	}
+
+	var digests []string
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", pkg.Name)
+		if err != nil {
+			return fmt.Errorf("could not create temp directory for build %d: %s", i+1, err)
+		}
+		defer os.RemoveAll(dir)
+
+		src, cache, out := filepath.Join(dir, "src"), filepath.Join(dir, "cache"), filepath.Join(dir, "out")
+		if err = os.Mkdir(src, 0755); err != nil {
+			return fmt.Errorf("could not create source directory for build %d: %s", i+1, err)
+		}
+		if err = run(ctx, "cp", "-R", root+string(filepath.Separator)+".", src); err != nil {
+			return fmt.Errorf("could not copy %s for build %d: %s", root, i+1, err)
+		}
+
+		b := pkg
+		b.OutDir = out
+		b.Dir = filepath.Join(src, rel)
+		// the override comes last, so it wins over any GOCACHE the package sets
+		b.Overrides = append(append([]TargetOverride(nil), pkg.Overrides...), TargetOverride{Env: map[string]string{"GOCACHE": cache}})
+
+		artifact, err := BuildPackageContext(ctx, b, t)
+		if err != nil {
+			return fmt.Errorf("build %d of %s failed: %s", i+1, t, err)
+		}
+		digests = append(digests, artifact.SHA256)
+	}
+
+	if digests[0] != digests[1] {
+		return fmt.Errorf("build of %s for %s is not reproducible: sha256 %s != %s", pkg.Name, t, digests[0], digests[1])
+	}
+
+	logEvent(ctx, LevelInfo, StepBuild, Fields{"target": t.String(), "sha256": digests[0]}, "build of %s is reproducible", pkg.Name)
+	return nil
+}
+
+// moduleRoot returns the root of the module containing dir, or the current
+// directory if dir is empty, and the path of dir relative to it. Outside a
+// module, dir itself is the root.
+func moduleRoot(ctx context.Context, dir string) (string, string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	goMod, err := runCommand(ctx, Command{Name: "go", Args: []string{"env", "GOMOD"}, Dir: dir})
+	if err != nil {
+		return "", "", err
+	}
+	goMod = filepath.Clean(strings.TrimSpace(goMod))
+	if goMod == "." || goMod == os.DevNull {
+		return abs, ".", nil
+	}
+
+	root := filepath.Dir(goMod)
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", "", err
+	}
+	return root, rel, nil
+}
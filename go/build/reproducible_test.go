@@ -0,0 +1,109 @@
+package build
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// TestVerifyReproducible builds a trivial program twice with the real go and git.
+// Each build starts with an empty cache, so the standard library is compiled twice.
+func TestVerifyReproducible(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the standard library twice")
+	}
+	for _, tool := range []string{"go", "git", "cp"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "reproducible")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"go.mod":  "module example.com/hello\n\ngo 1.16\n",
+		"main.go": "package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(\"hello\") }\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "hello"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s\n%s", args[0], err, out)
+		}
+	}
+
+	// the git information is read from the current directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	pkg := NewPackage("hello", *semver.New("1.2.3"))
+	pkg.Main = "."
+	if err = VerifyReproducible(pkg, TargetLinuxAmd64); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyReproducibleIsolatesBuilds(t *testing.T) {
+	pkg, cleanup := newTestPackage(t)
+	defer cleanup()
+
+	r := newFakeGoRunner()
+	r.Respond("go env GOMOD", filepath.Join(os.TempDir(), "project", "go.mod"), nil)
+	r.Respond("git log -1 --format=%ct HEAD", "1577934245", nil)
+	pkg.Dir = filepath.Join(os.TempDir(), "project", "cmd", "hello")
+
+	// the fake go writes out its command, which differs in GOCACHE and Dir
+	err := VerifyReproducibleContext(WithRunner(context.Background(), r), pkg, TargetLinuxAmd64)
+	if err == nil || !strings.Contains(err.Error(), "is not reproducible") {
+		t.Errorf("got error %v, want the builds to differ", err)
+	}
+
+	var dirs, caches []string
+	for _, cmd := range r.Commands() {
+		switch {
+		case cmd.Name == "cp":
+			if src := cmd.Args[len(cmd.Args)-2]; src != filepath.Join(os.TempDir(), "project")+string(filepath.Separator)+"." {
+				t.Errorf("copied %s, want the module root", src)
+			}
+		case cmd.Name == "go" && cmd.Args[0] == "build":
+			dirs = append(dirs, cmd.Dir)
+			caches = append(caches, cmd.Env["GOCACHE"])
+		}
+	}
+	if len(dirs) != 2 {
+		t.Fatalf("ran %d builds, want 2", len(dirs))
+	}
+	if dirs[0] == dirs[1] || caches[0] == caches[1] || caches[0] == "" {
+		t.Errorf("builds ran in %v with GOCACHE %v, want separate directories and caches", dirs, caches)
+	}
+	for _, dir := range dirs {
+		if filepath.Base(dir) != "hello" || filepath.Base(filepath.Dir(dir)) != "cmd" {
+			t.Errorf("build ran in %s, want the package's directory in the copy", dir)
+		}
+	}
+}
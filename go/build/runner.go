@@ -0,0 +1,316 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Command is an external command run by a Runner.
+type Command struct {
+	Name string
+	Args []string
+	// Env is added to the environment of the current process.
+	Env map[string]string
+	// Dir is the working directory. If empty, the current directory is used.
+	Dir string
+	// Stdout and Stderr, if set, receive the command's output as it runs.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// String returns the command as it would be typed into a shell.
+func (c Command) String() string {
+	var parts []string
+
+	var keys []string
+	for k := range c.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, k+"="+shellQuote(c.Env[k]))
+	}
+
+	parts = append(parts, c.argv())
+
+	return strings.Join(parts, " ")
+}
+
+// argv returns the command and its arguments as they would be typed into a shell.
+func (c Command) argv() string {
+	parts := []string{shellQuote(c.Name)}
+	for _, a := range c.Args {
+		parts = append(parts, shellQuote(a))
+	}
+	return strings.Join(parts, " ")
+}
+
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if strings.ContainsAny(s, " \t\n'\"$`\\|&;<>()*?[]{}!#~") {
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+	}
+	return s
+}
+
+// Runner runs the external commands used by this package.
+// The runner in use can be replaced with SetRunner, or for
+// a single operation by passing a context from WithRunner.
+type Runner interface {
+	// Run runs cmd and returns its standard output.
+	Run(ctx context.Context, cmd Command) (string, error)
+}
+
+var (
+	runnerMu      sync.RWMutex
+	currentRunner Runner = NewExecRunner()
+)
+
+// SetRunner replaces the Runner used by this package and returns the previous one.
+// Passing nil restores the default ExecRunner.
+func SetRunner(r Runner) Runner {
+	if r == nil {
+		r = NewExecRunner()
+	}
+	runnerMu.Lock()
+	defer runnerMu.Unlock()
+	previous := currentRunner
+	currentRunner = r
+	return previous
+}
+
+// CurrentRunner returns the Runner used by this package.
+func CurrentRunner() Runner {
+	runnerMu.RLock()
+	defer runnerMu.RUnlock()
+	return currentRunner
+}
+
+// isDryRun returns true if the runner for ctx does not really run commands,
+// in which case the files they would have produced will not exist.
+func isDryRun(ctx context.Context) bool {
+	d, ok := runnerFrom(ctx).(interface{ DryRun() bool })
+	return ok && d.DryRun()
+}
+
+// lookPath finds the executable name on the PATH, like exec.LookPath, using
+// the runner for ctx if it has a LookPath method so that tests can fake it.
+func lookPath(ctx context.Context, name string) (string, error) {
+	if l, ok := runnerFrom(ctx).(interface {
+		LookPath(name string) (string, error)
+	}); ok {
+		return l.LookPath(name)
+	}
+	return exec.LookPath(name)
+}
+
+// runCommand runs cmd with the runner from ctx, or the current runner.
+func runCommand(ctx context.Context, cmd Command) (string, error) {
+	return runnerFrom(ctx).Run(ctx, cmd)
+}
+
+// run runs a command, like sh.Run.
+func run(ctx context.Context, name string, args ...string) error {
+	_, err := runCommand(ctx, Command{Name: name, Args: args})
+	return err
+}
+
+// runWith runs a command with extra environment variables, like sh.RunWith.
+func runWith(ctx context.Context, env map[string]string, name string, args ...string) error {
+	_, err := runCommand(ctx, Command{Name: name, Args: args, Env: env})
+	return err
+}
+
+// output runs a command and returns its output with surrounding whitespace
+// removed, like sh.Output.
+func output(ctx context.Context, name string, args ...string) (string, error) {
+	return outputWith(ctx, nil, name, args...)
+}
+
+// outputWith runs a command with extra environment variables and returns
+// its output with surrounding whitespace removed, like sh.OutputWith.
+func outputWith(ctx context.Context, env map[string]string, name string, args ...string) (string, error) {
+	out, err := runCommand(ctx, Command{Name: name, Args: args, Env: env})
+	return strings.TrimSpace(out), err
+}
+
+// ExecRunner runs commands using os/exec.
+type ExecRunner struct {
+	// Timeout limits how long each command may run. If zero there is no limit.
+	Timeout time.Duration
+	// Verbose echoes each command and its standard output.
+	// Standard error is always written to os.Stderr.
+	Verbose bool
+}
+
+// NewExecRunner returns an ExecRunner which is verbose if mage is running
+// in verbose mode.
+func NewExecRunner() *ExecRunner {
+	verbose, _ := strconv.ParseBool(os.Getenv("MAGEFILE_VERBOSE"))
+	return &ExecRunner{Verbose: verbose}
+}
+
+func (r *ExecRunner) Run(ctx context.Context, cmd Command) (string, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	c.Dir = cmd.Dir
+	c.Env = os.Environ()
+	for k, v := range cmd.Env {
+		c.Env = append(c.Env, k+"="+v)
+	}
+
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+
+	switch {
+	case cmd.Stdout != nil:
+		c.Stdout = io.MultiWriter(&stdout, cmd.Stdout)
+	case r.Verbose:
+		c.Stdout = io.MultiWriter(&stdout, os.Stdout)
+	}
+
+	if cmd.Stderr != nil {
+		c.Stderr = io.MultiWriter(&stderr, cmd.Stderr)
+	} else {
+		c.Stderr = io.MultiWriter(&stderr, os.Stderr)
+	}
+
+	if r.Verbose {
+		logEvent(ctx, LevelInfo, "", Fields{"cmd": cmd.String()}, "exec %s", cmd.Name)
+	}
+
+	err := c.Run()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return stdout.String(), fmt.Errorf("running %q failed: %s", cmd, err)
+	}
+
+	return stdout.String(), nil
+}
+
+// DryRunner logs the commands it is asked to run without running them.
+type DryRunner struct {
+	// Out receives a line for each command. If nil, os.Stdout is used.
+	Out io.Writer
+	// Output, if set, returns the output to report for a command,
+	// e.g. to answer the git queries made while planning a release.
+	Output func(cmd Command) string
+}
+
+// DryRun returns true, to tell the build functions not to look for outputs
+// of the commands which were not run.
+func (r *DryRunner) DryRun() bool { return true }
+
+func (r *DryRunner) Run(ctx context.Context, cmd Command) (string, error) {
+	out := r.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, "[dry-run] %s\n", cmd)
+
+	if r.Output != nil {
+		return r.Output(cmd), nil
+	}
+	return "", nil
+}
+
+// RecordedResponse is the result a RecordingRunner returns for a command.
+type RecordedResponse struct {
+	Output string
+	Err    error
+}
+
+// RecordingRunner records the commands it is asked to run and returns canned
+// responses without running anything. It is intended for tests.
+type RecordingRunner struct {
+	mu        sync.Mutex
+	commands  []Command
+	responses []recordedPrefix
+	paths     map[string]string
+}
+
+type recordedPrefix struct {
+	prefix   string
+	response RecordedResponse
+}
+
+// Respond makes the runner return output and err for every command which
+// starts with prefix, ignoring its environment, e.g. "git rev-parse".
+// The most recently added matching prefix wins. Commands without
+// a response succeed with no output.
+func (r *RecordingRunner) Respond(prefix, output string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, recordedPrefix{prefix, RecordedResponse{output, err}})
+}
+
+// Install makes LookPath find the executable name at path. Executables
+// which were not installed are not found, whatever is on the PATH.
+func (r *RecordingRunner) Install(name, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paths == nil {
+		r.paths = map[string]string{}
+	}
+	r.paths[name] = path
+}
+
+// LookPath returns the path passed to Install for name.
+func (r *RecordingRunner) LookPath(name string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if path, ok := r.paths[name]; ok {
+		return path, nil
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, cmd Command) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.commands = append(r.commands, cmd)
+
+	line := cmd.argv()
+	for i := len(r.responses) - 1; i >= 0; i-- {
+		if strings.HasPrefix(line, r.responses[i].prefix) {
+			return r.responses[i].response.Output, r.responses[i].response.Err
+		}
+	}
+	return "", nil
+}
+
+// Commands returns the commands run so far.
+func (r *RecordingRunner) Commands() []Command {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Command(nil), r.commands...)
+}
+
+// Lines returns the commands run so far, formatted with Command.String.
+func (r *RecordingRunner) Lines() []string {
+	var lines []string
+	for _, c := range r.Commands() {
+		lines = append(lines, c.String())
+	}
+	return lines
+}
@@ -0,0 +1,192 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is run by the ExecRunner tests as the command being run.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("BUILD_TEST_HELPER") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	switch args[1] {
+	case "echo":
+		wd, _ := os.Getwd()
+		fmt.Printf("%s|%s|%s", strings.Join(args[2:], ","), os.Getenv("HELPER_VALUE"), wd)
+	case "fail":
+		fmt.Fprint(os.Stderr, "something broke")
+		os.Exit(3)
+	case "sleep":
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+func helperCommand(args ...string) Command {
+	return Command{
+		Name: os.Args[0],
+		Args: append([]string{"-test.run=TestHelperProcess", "--"}, args...),
+		Env:  map[string]string{"BUILD_TEST_HELPER": "1"},
+	}
+}
+
+func TestExecRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := helperCommand("echo", "a b", "c")
+	cmd.Env["HELPER_VALUE"] = "from env"
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+
+	out, err := (&ExecRunner{}).Run(context.Background(), cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a b,c|from env|" + dir; out != want {
+		t.Errorf("got output %q, want %q", out, want)
+	}
+	if stdout.String() != out {
+		t.Errorf("got stdout %q, want the output copied to it", stdout.String())
+	}
+}
+
+func TestExecRunnerFailure(t *testing.T) {
+	var stderr bytes.Buffer
+	cmd := helperCommand("fail")
+	cmd.Stderr = &stderr
+
+	_, err := (&ExecRunner{}).Run(context.Background(), cmd)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "fail") {
+		t.Errorf("got error %v, want the command and its exit status", err)
+	}
+	if stderr.String() != "something broke" {
+		t.Errorf("got stderr %q", stderr.String())
+	}
+}
+
+func TestExecRunnerTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := (&ExecRunner{Timeout: 100 * time.Millisecond}).Run(context.Background(), helperCommand("sleep"))
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("got error %v, want the deadline to be exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("took %s, want the command killed at the timeout", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = (&ExecRunner{}).Run(ctx, helperCommand("sleep"))
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("got error %v, want the command cancelled", err)
+	}
+}
+
+func TestDryRunner(t *testing.T) {
+	var out bytes.Buffer
+	r := &DryRunner{Out: &out, Output: func(cmd Command) string {
+		if cmd.Name == "git" {
+			return "v1.2.3"
+		}
+		return ""
+	}}
+	ctx := WithRunner(context.Background(), r)
+
+	if !isDryRun(ctx) {
+		t.Error("isDryRun is false for a DryRunner")
+	}
+	if isDryRun(WithRunner(context.Background(), &RecordingRunner{})) {
+		t.Error("isDryRun is true for a RecordingRunner")
+	}
+
+	got, err := outputWith(ctx, map[string]string{"B": "2", "A": "it's"}, "git", "describe", "--tags")
+	if err != nil || got != "v1.2.3" {
+		t.Errorf("got %q, %v, want the output from Output", got, err)
+	}
+	if err = run(ctx, "go", "build", "-o", "out dir/hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[dry-run] A='it'\\''s' B=2 git describe --tags\n" +
+		"[dry-run] go build -o 'out dir/hello'\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestRecordingRunnerRespond(t *testing.T) {
+	failed := errors.New("failed")
+	r := &RecordingRunner{}
+	r.Respond("git", "any git", nil)
+	r.Respond("git rev-parse", "rev-parse", nil)
+	r.Respond("git rev-parse --verify", "", failed)
+	// the most recent prefix wins, even if it is shorter
+	r.Respond("git log", "first log", nil)
+	r.Respond("git", "last git", nil)
+	r.Respond("git rev-parse", "last rev-parse", nil)
+
+	tests := []struct {
+		cmd     Command
+		want    string
+		wantErr error
+	}{
+		{Command{Name: "git", Args: []string{"rev-parse", "HEAD"}}, "last rev-parse", nil},
+		{Command{Name: "git", Args: []string{"rev-parse", "--verify", "v1"}}, "last rev-parse", nil},
+		{Command{Name: "git", Args: []string{"log", "-1"}}, "last git", nil},
+		// the environment is ignored
+		{Command{Name: "git", Args: []string{"status"}, Env: map[string]string{"A": "1"}}, "last git", nil},
+		{Command{Name: "go", Args: []string{"version"}}, "", nil},
+	}
+	for _, tt := range tests {
+		got, err := r.Run(context.Background(), tt.cmd)
+		if got != tt.want || err != tt.wantErr {
+			t.Errorf("%s: got %q, %v, want %q, %v", tt.cmd, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	r.Respond("git rev-parse --verify", "", failed)
+	if _, err := r.Run(context.Background(), Command{Name: "git", Args: []string{"rev-parse", "--verify", "v1"}}); err != failed {
+		t.Errorf("got error %v, want the newest response", err)
+	}
+
+	if want := 6; len(r.Commands()) != want {
+		t.Errorf("recorded %d commands, want %d", len(r.Commands()), want)
+	}
+	if want := "A=1 git status"; r.Lines()[3] != want {
+		t.Errorf("got line %q, want %q", r.Lines()[3], want)
+	}
+}
+
+func TestLookPathUsesRunner(t *testing.T) {
+	r := &RecordingRunner{}
+	r.Install("upx", "/opt/upx/bin/upx")
+	ctx := WithRunner(context.Background(), r)
+
+	if path, err := lookPath(ctx, "upx"); err != nil || path != "/opt/upx/bin/upx" {
+		t.Errorf("got %q, %v, want the installed path", path, err)
+	}
+	if path, err := lookPath(ctx, "go"); err == nil {
+		t.Errorf("found go at %s, want only installed tools to be found", path)
+	}
+}
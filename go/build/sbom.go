@@ -0,0 +1,405 @@
+package build
+
+import (
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// SBOM formats, as accepted by SBOMOptions.Format.
+const (
+	SBOMCycloneDX = "cyclonedx"
+	SBOMSPDX      = "spdx"
+)
+
+// SBOMOptions describes the SBOM written for a binary.
+type SBOMOptions struct {
+	// Format is SBOMCycloneDX or SBOMSPDX. If empty, SBOMCycloneDX is used.
+	Format string
+	// Name and Version describe the binary. If empty, the path and
+	// version of its main module are used.
+	Name    string
+	Version string
+	// Time is when the SBOM was created. If zero, the current time is used.
+	Time time.Time
+}
+
+// sbomModule is a module compiled into a binary.
+type sbomModule struct {
+	Path    string
+	Version string
+	// SHA256 is the hex of the module's go.sum hash, if it has one.
+	SHA256 string
+	// Replaces is the module path this module replaced, if any.
+	Replaces string
+	// LocalDir is the directory the module was replaced with, if any.
+	LocalDir string
+}
+
+// sbomInfo is what an SBOM records about a binary.
+type sbomInfo struct {
+	Name      string
+	Version   string
+	SHA256    string
+	Time      time.Time
+	Main      sbomModule
+	GoVersion string
+	Settings  []debug.BuildSetting
+	Deps      []sbomModule
+}
+
+// sbomExt returns the extension of SBOM files in format.
+func sbomExt(format string) string {
+	if format == SBOMSPDX {
+		return ".spdx.json"
+	}
+	return ".cdx.json"
+}
+
+// WriteSBOM writes an SBOM for the Go binary at path to {path}.cdx.json
+// or {path}.spdx.json, depending on the format, and returns its path.
+func WriteSBOM(path string, opts SBOMOptions) (string, error) {
+	out := path + sbomExt(opts.Format)
+
+	f, err := os.Create(out)
+	if err != nil {
+		return out, fmt.Errorf("could not create SBOM: %s", err)
+	}
+	defer f.Close()
+
+	if err = GenerateSBOM(f, path, opts); err != nil {
+		return out, err
+	}
+	return out, f.Close()
+}
+
+// GenerateSBOM reads the module build info embedded in the Go binary at path
+// and writes a CycloneDX or SPDX JSON SBOM to w. It lists the main module and
+// every dependency with its version and go.sum hash, and records the Go version
+// and build settings. The binary must not have been compressed, such as by UPX.
+func GenerateSBOM(w io.Writer, path string, opts SBOMOptions) error {
+	info, err := readSBOMInfo(path, opts)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	switch opts.Format {
+	case SBOMCycloneDX, "":
+		doc = cycloneDX(info)
+	case SBOMSPDX:
+		doc = spdx(info)
+	default:
+		return fmt.Errorf("unknown SBOM format %q (expected %s or %s)", opts.Format, SBOMCycloneDX, SBOMSPDX)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(doc); err != nil {
+		return fmt.Errorf("could not write SBOM: %s", err)
+	}
+	return nil
+}
+
+func readSBOMInfo(path string, opts SBOMOptions) (sbomInfo, error) {
+	bi, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return sbomInfo{}, fmt.Errorf("could not read the build info of %s: %s", path, err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return sbomInfo{}, err
+	}
+	sum := sha256.Sum256(data)
+
+	info := sbomInfo{
+		Name:      opts.Name,
+		Version:   opts.Version,
+		SHA256:    hex.EncodeToString(sum[:]),
+		Time:      opts.Time,
+		Main:      newSBOMModule(&bi.Main),
+		GoVersion: bi.GoVersion,
+		Settings:  bi.Settings,
+	}
+	if info.Name == "" {
+		info.Name = bi.Main.Path
+	}
+	if info.Version == "" {
+		info.Version = bi.Main.Version
+	} else if info.Main.Version == "" || info.Main.Version == "(devel)" {
+		// go build does not stamp the main module's version
+		info.Main.Version = "v" + strings.TrimPrefix(info.Version, "v")
+	}
+	if info.Time.IsZero() {
+		info.Time = time.Now()
+	}
+	for _, dep := range bi.Deps {
+		info.Deps = append(info.Deps, newSBOMModule(dep))
+	}
+	return info, nil
+}
+
+func newSBOMModule(m *debug.Module) sbomModule {
+	module := sbomModule{Path: m.Path, Version: m.Version}
+	sum := m.Sum
+	switch {
+	case m.Replace == nil:
+	case m.Replace.Version == "" || m.Replace.Version == "(devel)":
+		// a directory has no version of its own, so the module it stands in for is listed
+		module.LocalDir = m.Replace.Path
+		sum = ""
+	default:
+		module.Replaces = m.Path
+		module.Path, module.Version, sum = m.Replace.Path, m.Replace.Version, m.Replace.Sum
+	}
+	// go.sum hashes are "h1:" and the base64 of a sha256
+	if hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sum, "h1:")); err == nil && strings.HasPrefix(sum, "h1:") {
+		module.SHA256 = hex.EncodeToString(hash)
+	}
+	return module
+}
+
+// purl returns the package URL of the module.
+func (m sbomModule) purl() string {
+	if m.Version == "" || m.Version == "(devel)" {
+		return "pkg:golang/" + m.Path
+	}
+	return "pkg:golang/" + m.Path + "@" + strings.Replace(m.Version, "+", "%2B", -1)
+}
+
+// uuid returns a version 4 style UUID derived from the binary, so that
+// the SBOM of a reproducible build is also reproducible.
+func (info sbomInfo) uuid() string {
+	h := sha256.Sum256([]byte(info.SHA256 + info.Name + info.Version))
+	h[6] = h[6]&0x0f | 0x40
+	h[8] = h[8]&0x3f | 0x80
+	x := hex.EncodeToString(h[:16])
+	return x[0:8] + "-" + x[8:12] + "-" + x[12:16] + "-" + x[16:20] + "-" + x[20:32]
+}
+
+type cdxDocument struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp  string        `json:"timestamp"`
+	Tools      []cdxTool     `json:"tools"`
+	Component  cdxComponent  `json:"component"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxTool struct {
+	Vendor string `json:"vendor"`
+	Name   string `json:"name"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+func cycloneDX(info sbomInfo) cdxDocument {
+	mainRef := "pkg:golang/" + info.Main.Path
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + info.uuid(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: info.Time.UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Vendor: "naveego", Name: "github.com/naveego/ci"}},
+			Component: cdxComponent{
+				Type:    "application",
+				BOMRef:  mainRef,
+				Name:    info.Name,
+				Version: info.Version,
+				PURL:    info.Main.purl(),
+				Hashes:  []cdxHash{{Alg: "SHA-256", Content: info.SHA256}},
+			},
+			Properties: []cdxProperty{{Name: "go:version", Value: info.GoVersion}},
+		},
+		Components: []cdxComponent{},
+	}
+	for _, s := range info.Settings {
+		doc.Metadata.Properties = append(doc.Metadata.Properties, cdxProperty{Name: "go:build:" + s.Key, Value: s.Value})
+	}
+
+	main := cdxDependency{Ref: mainRef}
+	for _, dep := range info.Deps {
+		c := cdxComponent{
+			Type:    "library",
+			BOMRef:  dep.purl(),
+			Name:    dep.Path,
+			Version: dep.Version,
+			PURL:    dep.purl(),
+		}
+		if dep.SHA256 != "" {
+			c.Hashes = []cdxHash{{Alg: "SHA-256", Content: dep.SHA256}}
+		}
+		if dep.Replaces != "" {
+			c.Properties = []cdxProperty{{Name: "go:replaces", Value: dep.Replaces}}
+		}
+		if dep.LocalDir != "" {
+			c.Properties = []cdxProperty{{Name: "go:replacedBy", Value: dep.LocalDir}}
+		}
+		doc.Components = append(doc.Components, c)
+		doc.Dependencies = append(doc.Dependencies, cdxDependency{Ref: c.BOMRef})
+		main.DependsOn = append(main.DependsOn, c.BOMRef)
+	}
+	doc.Dependencies = append([]cdxDependency{main}, doc.Dependencies...)
+
+	return doc
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+	Comment          string            `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func spdx(info sbomInfo) spdxDocument {
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              strings.TrimSuffix(info.Name+"-"+info.Version, "-"),
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + info.uuid(),
+		CreationInfo: spdxCreationInfo{
+			Created:  info.Time.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: github.com/naveego/ci", "Organization: naveego"},
+		},
+	}
+
+	// the go version and build settings have no field of their own
+	comment := []string{"go version: " + info.GoVersion}
+	for _, s := range info.Settings {
+		comment = append(comment, s.Key+"="+s.Value)
+	}
+
+	mainID := "SPDXRef-Package-main"
+	doc.Packages = append(doc.Packages, spdxPackage{
+		Name:             info.Name,
+		SPDXID:           mainID,
+		VersionInfo:      info.Version,
+		DownloadLocation: "NOASSERTION",
+		Checksums:        []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: info.SHA256}},
+		LicenseConcluded: "NOASSERTION",
+		LicenseDeclared:  "NOASSERTION",
+		CopyrightText:    "NOASSERTION",
+		ExternalRefs:     []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: info.Main.purl()}},
+		Comment:          strings.Join(comment, "\n"),
+	})
+	doc.Relationships = append(doc.Relationships, spdxRelationship{
+		SPDXElementID:      doc.SPDXID,
+		RelationshipType:   "DESCRIBES",
+		RelatedSPDXElement: mainID,
+	})
+
+	for i, dep := range info.Deps {
+		p := spdxPackage{
+			Name:             dep.Path,
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i+1),
+			VersionInfo:      dep.Version,
+			DownloadLocation: "https://proxy.golang.org/" + dep.Path + "/@v/" + dep.Version + ".zip",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+			ExternalRefs:     []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: dep.purl()}},
+		}
+		if dep.SHA256 != "" {
+			p.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: dep.SHA256}}
+		}
+		if dep.LocalDir != "" {
+			p.DownloadLocation = "NOASSERTION"
+			p.Comment = "replaced by the directory " + dep.LocalDir
+		}
+		if dep.Replaces != "" {
+			p.Comment = "replaces " + dep.Replaces
+		}
+		doc.Packages = append(doc.Packages, p)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      mainID,
+			RelationshipType:   "DEPENDS_ON",
+			RelatedSPDXElement: p.SPDXID,
+		})
+	}
+
+	return doc
+}
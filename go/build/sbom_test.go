@@ -0,0 +1,231 @@
+package build
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildTinyBinary builds a program whose module requires example.com/dep,
+// which is replaced by a directory, and returns the path of the binary.
+func buildTinyBinary(t *testing.T, dir string) string {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	files := map[string]string{
+		"go.mod":       "module example.com/tiny\n\ngo 1.16\n\nrequire example.com/dep v1.0.0\n\nreplace example.com/dep => ./dep\n",
+		"main.go":      "package main\n\nimport \"example.com/dep\"\n\nfunc main() { dep.Hello() }\n",
+		"dep/go.mod":   "module example.com/dep\n\ngo 1.16\n",
+		"dep/hello.go": "package dep\n\nimport \"fmt\"\n\nfunc Hello() { fmt.Println(\"hello\") }\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(dir, "tiny")
+	cmd := exec.Command("go", "build", "-o", out, ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off", "CGO_ENABLED=0")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("could not build the binary: %s\n%s", err, output)
+	}
+	return out
+}
+
+func TestGenerateSBOM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sbom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary := buildTinyBinary(t, dir)
+	data, err := ioutil.ReadFile(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	binarySum := hex.EncodeToString(sum[:])
+	opts := SBOMOptions{Version: "1.2.3", Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	t.Run("cyclonedx", func(t *testing.T) {
+		path, err := WriteSBOM(binary, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != binary+".cdx.json" {
+			t.Errorf("wrote %s, want %s.cdx.json", path, binary)
+		}
+		var doc cdxDocument
+		readJSON(t, path, &doc)
+
+		if doc.BOMFormat != "CycloneDX" || doc.Metadata.Timestamp != "2020-01-02T03:04:05Z" {
+			t.Errorf("got format %s at %s", doc.BOMFormat, doc.Metadata.Timestamp)
+		}
+		wantMain := cdxComponent{
+			Type:    "application",
+			BOMRef:  "pkg:golang/example.com/tiny",
+			Name:    "example.com/tiny",
+			Version: "1.2.3",
+			PURL:    "pkg:golang/example.com/tiny@v1.2.3",
+			Hashes:  []cdxHash{{Alg: "SHA-256", Content: binarySum}},
+		}
+		if !reflect.DeepEqual(doc.Metadata.Component, wantMain) {
+			t.Errorf("got component %+v, want %+v", doc.Metadata.Component, wantMain)
+		}
+		if p := doc.Metadata.Properties[0]; p.Name != "go:version" || !strings.HasPrefix(p.Value, "go") {
+			t.Errorf("got property %+v, want the go version", p)
+		}
+
+		wantDeps := []cdxComponent{{
+			Type:       "library",
+			BOMRef:     "pkg:golang/example.com/dep@v1.0.0",
+			Name:       "example.com/dep",
+			Version:    "v1.0.0",
+			PURL:       "pkg:golang/example.com/dep@v1.0.0",
+			Properties: []cdxProperty{{Name: "go:replacedBy", Value: "./dep"}},
+		}}
+		if !reflect.DeepEqual(doc.Components, wantDeps) {
+			t.Errorf("got components %+v, want %+v", doc.Components, wantDeps)
+		}
+		wantDependencies := []cdxDependency{
+			{Ref: "pkg:golang/example.com/tiny", DependsOn: []string{"pkg:golang/example.com/dep@v1.0.0"}},
+			{Ref: "pkg:golang/example.com/dep@v1.0.0"},
+		}
+		if !reflect.DeepEqual(doc.Dependencies, wantDependencies) {
+			t.Errorf("got dependencies %+v, want %+v", doc.Dependencies, wantDependencies)
+		}
+	})
+
+	t.Run("spdx", func(t *testing.T) {
+		opts := opts
+		opts.Format = SBOMSPDX
+		opts.Name = "tiny"
+		path, err := WriteSBOM(binary, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != binary+".spdx.json" {
+			t.Errorf("wrote %s, want %s.spdx.json", path, binary)
+		}
+		var doc spdxDocument
+		readJSON(t, path, &doc)
+
+		if doc.SPDXVersion != "SPDX-2.3" || doc.Name != "tiny-1.2.3" || doc.CreationInfo.Created != "2020-01-02T03:04:05Z" {
+			t.Errorf("got %s document %s created %s", doc.SPDXVersion, doc.Name, doc.CreationInfo.Created)
+		}
+		if len(doc.Packages) != 2 {
+			t.Fatalf("got %d packages, want the binary and its dependency", len(doc.Packages))
+		}
+		main := doc.Packages[0]
+		if main.Name != "tiny" || main.VersionInfo != "1.2.3" || !reflect.DeepEqual(main.Checksums, []spdxChecksum{{"SHA256", binarySum}}) {
+			t.Errorf("got main package %+v", main)
+		}
+		if !strings.HasPrefix(main.Comment, "go version: go") {
+			t.Errorf("got comment %q, want the go version", main.Comment)
+		}
+
+		dep := doc.Packages[1]
+		if dep.Name != "example.com/dep" || dep.DownloadLocation != "NOASSERTION" || dep.Comment != "replaced by the directory ./dep" {
+			t.Errorf("got dependency %+v, want the directory it was replaced by", dep)
+		}
+		wantRelationships := []spdxRelationship{
+			{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Package-main"},
+			{"SPDXRef-Package-main", "DEPENDS_ON", "SPDXRef-Package-1"},
+		}
+		if !reflect.DeepEqual(doc.Relationships, wantRelationships) {
+			t.Errorf("got relationships %+v, want %+v", doc.Relationships, wantRelationships)
+		}
+	})
+
+	// the SBOM of the same binary is the same
+	var first, second bytes.Buffer
+	if err = GenerateSBOM(&first, binary, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err = GenerateSBOM(&second, binary, opts); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() {
+		t.Error("got different SBOMs for the same binary")
+	}
+
+	if err = GenerateSBOM(&first, binary, SBOMOptions{Format: "swid"}); err == nil || !strings.Contains(err.Error(), `unknown SBOM format "swid"`) {
+		t.Errorf("got error %v, want the format to be unknown", err)
+	}
+}
+
+func readJSON(t *testing.T, path string, v interface{}) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		t.Fatalf("%s is not valid JSON: %s", path, err)
+	}
+}
+
+func TestNewSBOMModule(t *testing.T) {
+	// the sha256 of "hello", as a go.sum hash
+	const sum = "h1:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	const hash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	tests := []struct {
+		name   string
+		module debug.Module
+		want   sbomModule
+	}{
+		{
+			"not replaced",
+			debug.Module{Path: "example.com/a", Version: "v1.0.0", Sum: sum},
+			sbomModule{Path: "example.com/a", Version: "v1.0.0", SHA256: hash},
+		},
+		{
+			"replaced by a module",
+			debug.Module{Path: "example.com/a", Version: "v1.0.0", Sum: "h1:ignored=", Replace: &debug.Module{Path: "example.com/fork", Version: "v1.0.1", Sum: sum}},
+			sbomModule{Path: "example.com/fork", Version: "v1.0.1", SHA256: hash, Replaces: "example.com/a"},
+		},
+		{
+			"replaced by a directory",
+			debug.Module{Path: "example.com/a", Version: "v1.0.0", Sum: sum, Replace: &debug.Module{Path: "../a"}},
+			sbomModule{Path: "example.com/a", Version: "v1.0.0", LocalDir: "../a"},
+		},
+		{
+			"replaced by a devel directory",
+			debug.Module{Path: "example.com/a", Version: "v1.0.0", Replace: &debug.Module{Path: "/src/a", Version: "(devel)"}},
+			sbomModule{Path: "example.com/a", Version: "v1.0.0", LocalDir: "/src/a"},
+		},
+		{
+			"not a go.sum hash",
+			debug.Module{Path: "example.com/a", Version: "v1.0.0", Sum: "h2:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="},
+			sbomModule{Path: "example.com/a", Version: "v1.0.0"},
+		},
+	}
+	for _, tt := range tests {
+		if got := newSBOMModule(&tt.module); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	m := sbomModule{Path: "example.com/a", Version: "v1.0.0+incompatible"}
+	if got, want := m.purl(), "pkg:golang/example.com/a@v1.0.0%2Bincompatible"; got != want {
+		t.Errorf("got purl %s, want %s", got, want)
+	}
+}
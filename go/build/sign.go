@@ -0,0 +1,372 @@
+package build
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultSumsFile is the name of the checksums file written by SignArtifacts.
+	DefaultSumsFile = "SHA256SUMS"
+	// SignatureExt is appended to the name of a file to name its detached signature.
+	SignatureExt = ".sig"
+
+	// SigningKeyEnv holds the signing key if SignOptions does not provide one.
+	SigningKeyEnv = "CI_SIGNING_KEY"
+	// SigningKeyFileEnv names a file holding the signing key if SigningKeyEnv is not set.
+	SigningKeyFileEnv = "CI_SIGNING_KEY_FILE"
+	// VerifyKeyEnv holds the public key if VerifyOptions does not provide one.
+	VerifyKeyEnv = "CI_VERIFY_KEY"
+	// VerifyKeyFileEnv names a file holding the public key if VerifyKeyEnv is not set.
+	VerifyKeyFileEnv = "CI_VERIFY_KEY_FILE"
+)
+
+// SignOptions selects the key SignArtifacts uses and where it writes the checksums.
+//
+// Keys are ed25519 private keys, either PEM encoded PKCS #8 as written by
+// `openssl genpkey -algorithm ed25519`, or the base64 of a 32 byte seed
+// or a 64 byte private key.
+type SignOptions struct {
+	// Dir is where SHA256SUMS and its signature are written. The paths in it
+	// are relative to Dir. If empty, the closest directory containing every
+	// artifact is used.
+	Dir string
+	// Key is the private key. If Key and KeyFile are empty,
+	// $CI_SIGNING_KEY or the file named by $CI_SIGNING_KEY_FILE is used.
+	Key string
+	// KeyFile is a file containing the private key.
+	KeyFile string
+}
+
+// SignResult lists the files written by SignArtifacts.
+type SignResult struct {
+	SumsFile string `json:"sumsFile"`
+	// Signatures are the detached signature of each artifact, and of SumsFile.
+	Signatures []string `json:"signatures"`
+	// PublicKey is the base64 public key which verifies the signatures.
+	PublicKey string `json:"publicKey"`
+}
+
+// String lists the files written.
+func (r SignResult) String() string {
+	return strings.Join(append([]string{r.SumsFile}, r.Signatures...), "\n")
+}
+
+// SigningKeyConfigured returns true if $CI_SIGNING_KEY or $CI_SIGNING_KEY_FILE is set.
+func SigningKeyConfigured() bool {
+	return os.Getenv(SigningKeyEnv) != "" || os.Getenv(SigningKeyFileEnv) != ""
+}
+
+// SignArtifacts writes the sha256 of each file in paths to SHA256SUMS, in the
+// format of sha256sum, then writes a detached ed25519 signature of each file
+// and of SHA256SUMS next to it, named with SignatureExt. The signatures are
+// the raw 64 bytes, so they can also be checked with
+// `openssl pkeyutl -verify -pubin -inkey key.pem -rawin -in file -sigfile file.sig`.
+func SignArtifacts(opts SignOptions, paths ...string) (SignResult, error) {
+	return SignArtifactsContext(context.Background(), opts, paths...)
+}
+
+func SignArtifactsContext(ctx context.Context, opts SignOptions, paths ...string) (SignResult, error) {
+	var result SignResult
+
+	if len(paths) == 0 {
+		return result, fmt.Errorf("there are no artifacts to sign")
+	}
+
+	key, err := loadSigningKey(opts)
+	if err != nil {
+		return result, err
+	}
+	result.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+
+	dir := opts.Dir
+	if dir == "" {
+		dir = commonDir(paths)
+	}
+	result.SumsFile = filepath.Join(dir, DefaultSumsFile)
+
+	step := beginStep(ctx, StepSign, Fields{"artifacts": len(paths)}, "signing artifacts into %s", result.SumsFile)
+
+	if isDryRun(ctx) {
+		return result, step.end(nil)
+	}
+
+	var sums []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return result, step.end(fmt.Errorf("%s is not in %s", path, dir))
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return result, step.end(fmt.Errorf("could not read artifact: %s", err))
+		}
+		sum := sha256.Sum256(data)
+		sums = append(sums, fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), filepath.ToSlash(rel)))
+
+		sigFile, err := writeSignature(key, path, data)
+		if err != nil {
+			return result, step.end(err)
+		}
+		result.Signatures = append(result.Signatures, sigFile)
+	}
+	// order by file name, which follows the 64 hex digits and two spaces
+	sort.Slice(sums, func(i, j int) bool {
+		return sums[i][66:] < sums[j][66:]
+	})
+
+	sumsData := []byte(strings.Join(sums, ""))
+	if err = ioutil.WriteFile(result.SumsFile, sumsData, 0644); err != nil {
+		return result, step.end(fmt.Errorf("could not write %s: %s", result.SumsFile, err))
+	}
+	sigFile, err := writeSignature(key, result.SumsFile, sumsData)
+	if err != nil {
+		return result, step.end(err)
+	}
+	result.Signatures = append(result.Signatures, sigFile)
+
+	return result, step.endWith(Fields{"publicKey": result.PublicKey}, nil)
+}
+
+func writeSignature(key ed25519.PrivateKey, path string, data []byte) (string, error) {
+	sigFile := path + SignatureExt
+	if err := ioutil.WriteFile(sigFile, ed25519.Sign(key, data), 0644); err != nil {
+		return sigFile, fmt.Errorf("could not write signature: %s", err)
+	}
+	return sigFile, nil
+}
+
+// commonDir returns the closest directory containing every path.
+func commonDir(paths []string) string {
+	dir := filepath.Dir(filepath.Clean(paths[0]))
+	for _, path := range paths[1:] {
+		path = filepath.Clean(path)
+		for dir != "." && dir != string(filepath.Separator) && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
+}
+
+func loadSigningKey(opts SignOptions) (ed25519.PrivateKey, error) {
+	data, source, err := readKey(opts.Key, opts.KeyFile, SigningKeyEnv, SigningKeyFileEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the signing key from %s: %s", source, err)
+		}
+		key, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("the signing key from %s is a %T, not an ed25519 key", source, parsed)
+		}
+		return key, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("the signing key from %s is neither PEM nor base64: %s", source, err)
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, fmt.Errorf("the signing key from %s is %d bytes, expected a %d byte seed or %d byte key", source, len(raw), ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// ParsePublicKey parses an ed25519 public key, either PEM encoded PKIX
+// as written by `openssl pkey -pubout`, or the base64 of its 32 bytes.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse public key: %s", err)
+		}
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("the public key is a %T, not an ed25519 key", parsed)
+		}
+		return key, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("the public key is neither PEM nor base64: %s", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("the public key is %d bytes, expected %d", len(raw), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// readKey returns the key given directly, or read from keyFile, or else from
+// the environment variables, with a description of where it came from.
+func readKey(key, keyFile, keyEnv, keyFileEnv string) ([]byte, string, error) {
+	switch {
+	case key != "":
+		return []byte(key), "the given key", nil
+	case keyFile != "":
+	case os.Getenv(keyEnv) != "":
+		return []byte(os.Getenv(keyEnv)), "$" + keyEnv, nil
+	case os.Getenv(keyFileEnv) != "":
+		keyFile = os.Getenv(keyFileEnv)
+	default:
+		return nil, "", fmt.Errorf("no key was given and neither $%s nor $%s is set", keyEnv, keyFileEnv)
+	}
+
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read key: %s", err)
+	}
+	return data, keyFile, nil
+}
+
+// VerifyOptions selects the checksums and public key VerifyArtifacts uses.
+type VerifyOptions struct {
+	// SumsFile is the SHA256SUMS file. If empty, the one in the directory
+	// of the first artifact is used.
+	SumsFile string
+	// PublicKey is the public key, in a form accepted by ParsePublicKey.
+	// If PublicKey and PublicKeyFile are empty, $CI_VERIFY_KEY or the
+	// file named by $CI_VERIFY_KEY_FILE is used.
+	PublicKey string
+	// PublicKeyFile is a file containing the public key.
+	PublicKeyFile string
+}
+
+// VerifyResult lists the artifacts which were verified, and those which were not.
+type VerifyResult struct {
+	SumsFile string            `json:"sumsFile"`
+	Verified []string          `json:"verified"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+// String lists the verified artifacts and the reason the others failed.
+func (r VerifyResult) String() string {
+	var b strings.Builder
+	for _, path := range r.Verified {
+		fmt.Fprintf(&b, "OK      %s\n", path)
+	}
+	var failed []string
+	for path := range r.Failed {
+		failed = append(failed, path)
+	}
+	sort.Strings(failed)
+	for _, path := range failed {
+		fmt.Fprintf(&b, "FAILED  %s: %s\n", path, r.Failed[path])
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// VerifyArtifacts checks the signature of the SHA256SUMS file, then checks
+// the sha256 and detached signature of each artifact in paths against it.
+// If no paths are given, every artifact listed in SHA256SUMS is checked.
+// An error is returned if any artifact fails, and the result says why.
+func VerifyArtifacts(opts VerifyOptions, paths ...string) (VerifyResult, error) {
+	result := VerifyResult{SumsFile: opts.SumsFile, Failed: map[string]string{}}
+
+	if result.SumsFile == "" {
+		if len(paths) == 0 {
+			return result, fmt.Errorf("a checksums file or the artifacts to verify are required")
+		}
+		result.SumsFile = filepath.Join(filepath.Dir(paths[0]), DefaultSumsFile)
+	}
+
+	data, source, err := readKey(opts.PublicKey, opts.PublicKeyFile, VerifyKeyEnv, VerifyKeyFileEnv)
+	if err != nil {
+		return result, err
+	}
+	key, err := ParsePublicKey(data)
+	if err != nil {
+		return result, fmt.Errorf("%s (from %s)", err, source)
+	}
+
+	sumsData, err := ioutil.ReadFile(result.SumsFile)
+	if err != nil {
+		return result, fmt.Errorf("could not read checksums: %s", err)
+	}
+	if err = verifySignature(key, result.SumsFile, sumsData); err != nil {
+		return result, err
+	}
+
+	dir := filepath.Dir(result.SumsFile)
+	sums := map[string]string{}
+	var listed []string
+	for i, line := range strings.Split(strings.TrimSpace(string(sumsData)), "\n") {
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) != 2 {
+			return result, fmt.Errorf("%s:%d: expected a sha256 and a file name", result.SumsFile, i+1)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(fields[1]))
+		sums[path] = fields[0]
+		listed = append(listed, path)
+	}
+
+	if len(paths) == 0 {
+		paths = listed
+	}
+
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		sum, ok := sums[path]
+		if !ok {
+			result.Failed[path] = fmt.Sprintf("not listed in %s", result.SumsFile)
+			continue
+		}
+		if err = verifyArtifact(key, path, sum); err != nil {
+			result.Failed[path] = err.Error()
+			continue
+		}
+		result.Verified = append(result.Verified, path)
+	}
+
+	if len(result.Failed) > 0 {
+		var reasons []string
+		for path, reason := range result.Failed {
+			reasons = append(reasons, path+": "+reason)
+		}
+		sort.Strings(reasons)
+		return result, fmt.Errorf("%d of %d artifacts failed verification:\n%s", len(result.Failed), len(paths), strings.Join(reasons, "\n"))
+	}
+	return result, nil
+}
+
+func verifyArtifact(key ed25519.PublicKey, path, sum string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	actual := sha256.Sum256(data)
+	if hex.EncodeToString(actual[:]) != strings.ToLower(sum) {
+		return fmt.Errorf("sha256 is %x, expected %s", actual, sum)
+	}
+	return verifySignature(key, path, data)
+}
+
+func verifySignature(key ed25519.PublicKey, path string, data []byte) error {
+	sig, err := ioutil.ReadFile(path + SignatureExt)
+	if err != nil {
+		return fmt.Errorf("could not read signature: %s", err)
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return fmt.Errorf("the signature of %s is not valid", path)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package build
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSignAndVerifyArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	for name, content := range map[string]string{
+		"hello-linux-amd64.tar.gz":   "linux",
+		"hello-windows-amd64.zip":    "windows",
+		"plugins/hello-manifest.zip": "plugin",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := base64.StdEncoding.EncodeToString(private.Seed())
+	publicKey := base64.StdEncoding.EncodeToString(public)
+
+	signed, err := SignArtifacts(SignOptions{Key: seed}, paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.SumsFile != filepath.Join(dir, DefaultSumsFile) {
+		t.Errorf("sums file is %s, want it in %s", signed.SumsFile, dir)
+	}
+	if signed.PublicKey != publicKey {
+		t.Errorf("public key is %s, want %s", signed.PublicKey, publicKey)
+	}
+	if len(signed.Signatures) != len(paths)+1 {
+		t.Errorf("got signatures %v, want one per artifact and one for %s", signed.Signatures, DefaultSumsFile)
+	}
+
+	sums, err := ioutil.ReadFile(signed.SumsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(sums), "  plugins/hello-manifest.zip\n") {
+		t.Errorf("sums do not list the nested artifact by its relative path:\n%s", sums)
+	}
+
+	// every listed artifact is checked if none are given
+	verified, err := VerifyArtifacts(VerifyOptions{SumsFile: signed.SumsFile, PublicKey: signed.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verified.Verified) != len(paths) {
+		t.Errorf("verified %v, want %v", verified.Verified, paths)
+	}
+
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := base64.StdEncoding.EncodeToString(other.Public().(ed25519.PublicKey))
+	if _, err = VerifyArtifacts(VerifyOptions{PublicKey: otherKey}, paths[0]); err == nil {
+		t.Error("verified with the wrong public key")
+	}
+
+	if err = ioutil.WriteFile(paths[0], []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	verified, err = VerifyArtifacts(VerifyOptions{PublicKey: publicKey}, paths...)
+	if err == nil {
+		t.Fatal("verified a tampered artifact")
+	}
+	if len(verified.Verified) != len(paths)-1 || !strings.HasPrefix(verified.Failed[paths[0]], "sha256 is ") {
+		t.Errorf("got verified %v and failed %v, want only %s to fail its sha256", verified.Verified, verified.Failed, paths[0])
+	}
+
+	unlisted := filepath.Join(dir, "unlisted.zip")
+	if err = ioutil.WriteFile(unlisted, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	verified, _ = VerifyArtifacts(VerifyOptions{SumsFile: signed.SumsFile, PublicKey: publicKey}, unlisted)
+	if !strings.HasPrefix(verified.Failed[unlisted], "not listed in ") {
+		t.Errorf("got failed %v, want %s not listed", verified.Failed, unlisted)
+	}
+}
+
+func TestSignArtifactsKeys(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"seed", base64.StdEncoding.EncodeToString(private.Seed()), false},
+		{"private key", base64.StdEncoding.EncodeToString(private), false},
+		{"PEM", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), false},
+		{"wrong length", base64.StdEncoding.EncodeToString([]byte("short")), true},
+		{"not base64 or PEM", "not a key!", true},
+	}
+
+	dir, err := ioutil.TempDir("", "sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "artifact.zip")
+	if err = ioutil.WriteFile(path, []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		_, err := SignArtifacts(SignOptions{Key: tt.key}, path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %t", tt.name, err, tt.wantErr)
+		}
+	}
+}
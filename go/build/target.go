@@ -0,0 +1,203 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// targetVariants maps each architecture which supports variants to the
+// environment variable selecting the variant, and the accepted values.
+var targetVariants = map[string]struct {
+	name   string
+	values map[string]string
+}{
+	"arm":      {"GOARM", map[string]string{"v5": "5", "v6": "6", "v7": "7"}},
+	"amd64":    {"GOAMD64", map[string]string{"v1": "v1", "v2": "v2", "v3": "v3", "v4": "v4"}},
+	"386":      {"GO386", map[string]string{"sse2": "sse2", "softfloat": "softfloat"}},
+	"arm64":    {"GOARM64", map[string]string{"v8.0": "v8.0", "v8.1": "v8.1", "v8.2": "v8.2", "v9.0": "v9.0"}},
+	"mips":     {"GOMIPS", map[string]string{"hardfloat": "hardfloat", "softfloat": "softfloat"}},
+	"mipsle":   {"GOMIPS", map[string]string{"hardfloat": "hardfloat", "softfloat": "softfloat"}},
+	"mips64":   {"GOMIPS64", map[string]string{"hardfloat": "hardfloat", "softfloat": "softfloat"}},
+	"mips64le": {"GOMIPS64", map[string]string{"hardfloat": "hardfloat", "softfloat": "softfloat"}},
+	"ppc64":    {"GOPPC64", map[string]string{"power8": "power8", "power9": "power9", "power10": "power10"}},
+	"ppc64le":  {"GOPPC64", map[string]string{"power8": "power8", "power9": "power9", "power10": "power10"}},
+}
+
+// ParseTarget parses a target in the form "os/arch" or "os/arch/variant",
+// such as "darwin/arm64" or "linux/arm/v7". The variants are
+// v5, v6 and v7 for arm (GOARM); v1 to v4 for amd64 (GOAMD64);
+// sse2 and softfloat for 386 (GO386); v8.0 to v9.0 for arm64 (GOARM64);
+// hardfloat and softfloat for the mips family (GOMIPS, GOMIPS64);
+// and power8 to power10 for ppc64 and ppc64le (GOPPC64).
+func ParseTarget(s string) (PackageTarget, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return PackageTarget{}, fmt.Errorf("invalid target %q: expected os/arch or os/arch/variant", s)
+	}
+
+	t := PackageTarget{OS: parts[0], Arch: parts[1]}
+	if len(parts) == 3 {
+		t.Variant = parts[2]
+	}
+
+	if _, _, err := t.variant(); err != nil {
+		return PackageTarget{}, err
+	}
+
+	return t, nil
+}
+
+// ParseTargets parses each of the targets using ParseTarget.
+func ParseTargets(targets ...string) ([]PackageTarget, error) {
+	var out []PackageTarget
+	for _, s := range targets {
+		t, err := ParseTarget(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// Platform returns the target in the form accepted by ParseTarget.
+func (t PackageTarget) Platform() string {
+	if t.Variant != "" {
+		return t.OS + "/" + t.Arch + "/" + t.Variant
+	}
+	return t.OS + "/" + t.Arch
+}
+
+// ArchVariant returns the architecture followed by the variant, if any,
+// such as "armv7". This is the form used in plugin manifests and archive names.
+func (t PackageTarget) ArchVariant() string {
+	return t.Arch + t.Variant
+}
+
+// Matches returns true if the target matches pattern, which has the form
+// "os", "os/arch" or "os/arch/variant". Each part may use the wildcards
+// supported by path.Match, e.g. "linux/*", "*/amd64" or "linux/arm/v*".
+// A pattern without a variant matches every variant of the architecture.
+func (t PackageTarget) Matches(pattern string) bool {
+	switch strings.Count(pattern, "/") {
+	case 0:
+		pattern += "/*"
+		fallthrough
+	case 1:
+		ok, _ := path.Match(pattern, t.OS+"/"+t.Arch)
+		return ok
+	default:
+		ok, _ := path.Match(pattern, t.OS+"/"+t.Arch+"/"+t.Variant)
+		return ok
+	}
+}
+
+// TargetPatterns is a list of patterns accepted by PackageTarget.Matches.
+type TargetPatterns []string
+
+// Match returns true if p is empty or any pattern in p matches t.
+func (p TargetPatterns) Match(t PackageTarget) bool {
+	if len(p) == 0 {
+		return true
+	}
+	for _, pattern := range p {
+		if t.Matches(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// variant returns the environment variable and value which select t.Variant.
+func (t PackageTarget) variant() (name, value string, err error) {
+	if t.Variant == "" {
+		return "", "", nil
+	}
+	env, ok := targetVariants[t.Arch]
+	if !ok {
+		return "", "", fmt.Errorf("invalid target %s: architecture %q does not support variants", t.Platform(), t.Arch)
+	}
+	value, ok = env.values[t.Variant]
+	if !ok {
+		return "", "", fmt.Errorf("invalid target %s: unknown variant %q for architecture %q", t.Platform(), t.Variant, t.Arch)
+	}
+	return env.name, value, nil
+}
+
+// variantEnv returns the environment variable and value which select t.Variant,
+// or empty strings if there is no variant or it is invalid.
+func (t PackageTarget) variantEnv() (string, string) {
+	name, value, _ := t.variant()
+	return name, value
+}
+
+var (
+	supportedPlatforms   map[string]bool
+	supportedPlatformsMu sync.Mutex
+)
+
+// listSupportedPlatforms returns the platforms supported by the installed go
+// toolchain. Only a successful listing is cached, so a failure is retried.
+func listSupportedPlatforms(ctx context.Context) (map[string]bool, error) {
+	supportedPlatformsMu.Lock()
+	defer supportedPlatformsMu.Unlock()
+
+	if supportedPlatforms != nil {
+		return supportedPlatforms, nil
+	}
+
+	out, err := output(ctx, "go", "tool", "dist", "list")
+	if err != nil {
+		return nil, fmt.Errorf("could not list platforms supported by go: %s", err)
+	}
+	platforms := map[string]bool{}
+	for _, p := range strings.Fields(out) {
+		platforms[p] = true
+	}
+	supportedPlatforms = platforms
+	return platforms, nil
+}
+
+// ValidateTargets checks that each target is supported by the installed go toolchain,
+// according to `go tool dist list`, and that its variant is valid.
+// TargetLocal is always valid. When dry running only the variants are checked.
+func ValidateTargets(targets ...PackageTarget) error {
+	return ValidateTargetsContext(context.Background(), targets...)
+}
+
+// ValidateTargetsContext is ValidateTargets with a context, which determines
+// whether a dry run is in progress and the Runner used to list the platforms.
+func ValidateTargetsContext(ctx context.Context, targets ...PackageTarget) error {
+	if isDryRun(ctx) {
+		for _, t := range targets {
+			if _, _, err := t.variant(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var platforms map[string]bool
+	for _, t := range targets {
+		if t == TargetLocal {
+			continue
+		}
+		if _, _, err := t.variant(); err != nil {
+			return err
+		}
+		if platforms == nil {
+			var err error
+			if platforms, err = listSupportedPlatforms(ctx); err != nil {
+				return err
+			}
+		}
+		if !platforms[t.OS+"/"+t.Arch] {
+			return fmt.Errorf("invalid target %s: %s/%s is not supported by the installed go toolchain", t.Platform(), t.OS, t.Arch)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package build
+
+import (
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in   string
+		want PackageTarget
+		err  bool
+	}{
+		{in: "linux/amd64", want: PackageTarget{OS: "linux", Arch: "amd64"}},
+		{in: "darwin/arm64", want: PackageTarget{OS: "darwin", Arch: "arm64"}},
+		{in: "linux/arm/v7", want: PackageTarget{OS: "linux", Arch: "arm", Variant: "v7"}},
+		{in: "linux/amd64/v3", want: PackageTarget{OS: "linux", Arch: "amd64", Variant: "v3"}},
+		{in: "linux/386/softfloat", want: PackageTarget{OS: "linux", Arch: "386", Variant: "softfloat"}},
+		{in: "linux", err: true},
+		{in: "linux/", err: true},
+		{in: "/amd64", err: true},
+		{in: "linux/arm/v7/extra", err: true},
+		{in: "linux/arm/v9", err: true},
+		{in: "linux/riscv64/v1", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseTarget(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTarget(%q) failed: %s", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTarget(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+		if got.Platform() != tt.in {
+			t.Errorf("ParseTarget(%q).Platform() = %q", tt.in, got.Platform())
+		}
+	}
+}
+
+func TestTargetPatternsMatch(t *testing.T) {
+	armV7 := PackageTarget{OS: "linux", Arch: "arm", Variant: "v7"}
+	amd64 := PackageTarget{OS: "linux", Arch: "amd64"}
+	windows := PackageTarget{OS: "windows", Arch: "amd64"}
+
+	tests := []struct {
+		patterns TargetPatterns
+		target   PackageTarget
+		want     bool
+	}{
+		{nil, windows, true},
+		{TargetPatterns{"linux"}, amd64, true},
+		{TargetPatterns{"linux"}, windows, false},
+		{TargetPatterns{"linux/*"}, armV7, true},
+		{TargetPatterns{"*/amd64"}, windows, true},
+		{TargetPatterns{"*/amd64"}, armV7, false},
+		{TargetPatterns{"linux/arm"}, armV7, true},
+		{TargetPatterns{"linux/arm/v*"}, armV7, true},
+		{TargetPatterns{"linux/arm/v6"}, armV7, false},
+		{TargetPatterns{"linux/amd64/v3"}, amd64, false},
+		{TargetPatterns{"darwin", "windows"}, windows, true},
+		{TargetPatterns{"darwin", "linux/386"}, windows, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.patterns.Match(tt.target); got != tt.want {
+			t.Errorf("%q.Match(%s) = %t, want %t", tt.patterns, tt.target.Platform(), got, tt.want)
+		}
+	}
+}
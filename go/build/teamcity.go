@@ -1,10 +1,11 @@
 package build
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
-	"regexp"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/onsi/ginkgo"
@@ -59,29 +60,68 @@ func CIProgress(message string) {
 
 // CIMessage writes a message out in a format TeamCity can understand.
 // The data parameter can be a string or a map[string]string.
+// When not running on TeamCity the message is logged instead.
 func CIMessage(messageType string, data interface{}) {
 	if RunningOnTeamCity() {
-		message := "##teamcity[" + messageType
-
+		var message string
 		switch d := data.(type) {
 		case string:
-			escaped := ciEscape(d)
-			message += fmt.Sprintf(" '%s'", escaped)
+			message = "##teamcity[" + messageType + " '" + ciEscape(d) + "']"
 		case map[string]string:
-			for k, v := range d {
-				escaped := ciEscape(v)
-				message += fmt.Sprintf(" %s='%s'", k, escaped)
-			}
+			message = teamCityMessage(messageType, d)
+		default:
+			message = "##teamcity[" + messageType + "]"
 		}
-		message += "]"
-		log.Println(message)
-	} else {
-		log.Printf("%s: %#v", messageType, data)
+		fmt.Println(message)
+		return
 	}
+
+	fields := Fields{}
+	switch d := data.(type) {
+	case string:
+		fields["value"] = d
+	case map[string]string:
+		for k, v := range d {
+			fields[k] = v
+		}
+	}
+	level := LevelInfo
+	if messageType == "buildProblem" {
+		level = LevelError
+	}
+	logEvent(context.Background(), level, "", fields, "%s", messageType)
 }
 
-var ciEscaper = regexp.MustCompile(`(['\n\r\|\]\[])`)
+// teamCityMessage formats a service message with the given attributes,
+// sorted by name. Attributes with empty values are left out.
+func teamCityMessage(messageType string, attrs map[string]string) string {
+	var keys []string
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	message := "##teamcity[" + messageType
+	for _, k := range keys {
+		if attrs[k] != "" {
+			message += fmt.Sprintf(" %s='%s'", k, ciEscape(attrs[k]))
+		}
+	}
+	return message + "]"
+}
+
+var ciEscaper = strings.NewReplacer(
+	"|", "||",
+	"'", "|'",
+	"\n", "|n",
+	"\r", "|r",
+	"[", "|[",
+	"]", "|]",
+	"\u0085", "|x",
+	"\u2028", "|l",
+	"\u2029", "|p",
+)
 
 func ciEscape(s string) string {
-	return ciEscaper.ReplaceAllString(s, "|$1")
+	return ciEscaper.Replace(s)
 }
@@ -1,24 +1,29 @@
 package build
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
-
-	"github.com/magefile/mage/sh"
+	"time"
 )
 
+// cleanUpTimeout limits how long cleaning up after integration tests may take.
+// Clean up runs even if the tests were cancelled, so it cannot use their context.
+const cleanUpTimeout = 2 * time.Minute
+
 // RunUnitTests runs unit tests recursively
 func RunUnitTests(tags []string) error {
-	// make sure we have ginkgo
-	err := sh.Run("go", "get", "github.com/onsi/ginkgo/ginkgo")
-	if err != nil {
-		return fmt.Errorf("Could not install ginkgo, %v", err)
-	}
-	err = sh.Run("go", "get", "github.com/onsi/gomega/...")
+	return RunUnitTestsContext(context.Background(), tags)
+}
+
+// RunUnitTestsContext is RunUnitTests with a context.
+// The test run is limited by the StepTest timeout.
+func RunUnitTestsContext(ctx context.Context, tags []string) error {
+	// gomega is a library, so the tests' own module provides it
+	ginkgo, err := EnsureToolContext(ctx, "ginkgo")
 	if err != nil {
-		return fmt.Errorf("Could not install gomega, %v", err)
+		return err
 	}
 
 	args := []string{
@@ -37,41 +42,60 @@ func RunUnitTests(tags []string) error {
 		args = append(args, strings.Join(tags, " "))
 	}
 
-	return sh.Run("ginkgo", args...)
+	step := beginStep(ctx, StepTest, Fields{"tags": strings.Join(tags, " ")}, "running unit tests")
+	ctx, cancel := stepContext(ctx, StepTest)
+	defer cancel()
+
+	return step.end(run(ctx, ginkgo, args...))
 }
 
 // RunIntegrationTestsInDocker executes integration tests using docker-compose.
 func RunIntegrationTestsInDocker(name, dockerComposePath string) error {
-	defer cleanUpAfterIntegrationTests(name, dockerComposePath)
+	return RunIntegrationTestsInDockerContext(context.Background(), name, dockerComposePath)
+}
+
+// RunIntegrationTestsInDockerContext is RunIntegrationTestsInDocker with a context.
+// The whole run is limited by the StepIntegrationTest timeout. The containers
+// are cleaned up even if ctx is cancelled.
+func RunIntegrationTestsInDockerContext(ctx context.Context, name, dockerComposePath string) error {
+	defer cleanUpAfterIntegrationTests(runnerFrom(ctx), loggerFrom(ctx), name, dockerComposePath)
 
-	fmt.Println("Running Integration Tests...")
+	step := beginStep(ctx, StepIntegrationTest, Fields{"project": name, "compose": dockerComposePath}, "running integration tests")
 
-	fmt.Println("Building Docker Compose Images...")
-	err := sh.Run("docker-compose", "-p", name, "-f", dockerComposePath, "build")
+	ctx, cancel := stepContext(ctx, StepIntegrationTest)
+	defer cancel()
+
+	logEvent(ctx, LevelInfo, StepIntegrationTest, nil, "building docker compose images")
+	err := run(ctx, "docker-compose", "-p", name, "-f", dockerComposePath, "build")
 	if err != nil {
-		return err
+		return step.end(err)
 	}
 
-	fmt.Println("Running Docker Compose Images...")
-	err = sh.Run("docker-compose", "-p", name, "-f", dockerComposePath, "up", "-d")
+	logEvent(ctx, LevelInfo, StepIntegrationTest, nil, "running docker compose images")
+	err = run(ctx, "docker-compose", "-p", name, "-f", dockerComposePath, "up", "-d")
 	if err != nil {
-		return err
+		return step.end(err)
 	}
 
-	runCmd := exec.Command("docker-compose", "-p", name, "-f", dockerComposePath, "run", "sut", "ginkgo", "-tags", "integration", "-r", "--progress", "--randomizeAllSpecs", "--randomizeSuites", "--cover", "--trace", "--race", "-keepGoing")
-	runCmd.Stdout = os.Stdout
-	runCmd.Stderr = os.Stderr
-
-	err = runCmd.Run()
+	_, err = runCommand(ctx, Command{
+		Name:   "docker-compose",
+		Args:   []string{"-p", name, "-f", dockerComposePath, "run", "sut", "ginkgo", "-tags", "integration", "-r", "--progress", "--randomizeAllSpecs", "--randomizeSuites", "--cover", "--trace", "--race", "-keepGoing"},
+		Stdout: os.Stdout,
+	})
 	if err != nil {
-		return fmt.Errorf("Tests failed: %v", err)
+		return step.end(fmt.Errorf("Tests failed: %v", err))
 	}
 
-	return nil
+	return step.end(nil)
 
 }
 
-func cleanUpAfterIntegrationTests(name, dockerComposePath string) {
-	sh.Run("docker-compose", "-p", name, "-f", dockerComposePath, "kill")
-	sh.Run("docker-compose", "-p", name, "-f", dockerComposePath, "rm", "-f")
+func cleanUpAfterIntegrationTests(runner Runner, logger Logger, name, dockerComposePath string) {
+	ctx, cancel := context.WithTimeout(WithLogger(WithRunner(context.Background(), runner), logger), cleanUpTimeout)
+	defer cancel()
+
+	logEvent(ctx, LevelInfo, StepIntegrationTest, Fields{"project": name}, "cleaning up docker compose containers")
+
+	run(ctx, "docker-compose", "-p", name, "-f", dockerComposePath, "kill")
+	run(ctx, "docker-compose", "-p", name, "-f", dockerComposePath, "rm", "-f")
 }
@@ -0,0 +1,356 @@
+package build
+
+import (
+	"bufio"
+	"context"
+	"debug/buildinfo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultToolDir is where tools are installed if CI_TOOL_DIR is not set.
+const DefaultToolDir = "./.bin"
+
+// Tool is an external tool used by this package, with the version it requires.
+type Tool struct {
+	Name string `json:"name"`
+	// Package is the main package of a tool written in Go. Such tools are
+	// installed into ToolDir from the project's vendor directory if it has
+	// Module at Version, or else with go install, which uses the module cache.
+	Package string `json:"package,omitempty"`
+	// Module is the module containing Package.
+	Module string `json:"module,omitempty"`
+	// Version is the exact module version required for a Go tool.
+	// If empty, any version is accepted and the latest is installed.
+	Version string `json:"version,omitempty"`
+	// MinVersion is the lowest version accepted for a tool which is not
+	// installed from Go source, as reported by running it with VersionArgs.
+	MinVersion  string   `json:"minVersion,omitempty"`
+	VersionArgs []string `json:"versionArgs,omitempty"`
+	// Install explains how to install a tool which is not installed from Go source.
+	Install string `json:"install,omitempty"`
+}
+
+// Tools are the tools this package uses, by name. Entries may be replaced
+// to pin different versions.
+var Tools = map[string]Tool{
+	"goreleaser": {
+		Name:    "goreleaser",
+		Package: "github.com/goreleaser/goreleaser/v2",
+		Module:  "github.com/goreleaser/goreleaser/v2",
+		// v2.4 added the go386, goarm64 and goppc64 build overrides GenerateReleaserConfig uses
+		Version: "v2.5.0",
+	},
+	"ginkgo": {
+		Name:    "ginkgo",
+		Package: "github.com/onsi/ginkgo/ginkgo",
+		Module:  "github.com/onsi/ginkgo",
+		Version: "v1.16.5",
+	},
+	"between": {
+		Name:    "between",
+		Package: "github.com/naveegoinc/go-between/cmd/between",
+		Module:  "github.com/naveegoinc/go-between",
+		// installed by Upload for its dev upload-plugin command
+		Version: "v1.0.0",
+	},
+	"strip": {
+		Name:        "strip",
+		VersionArgs: []string{"--version"},
+		Install:     "install binutils, such as with `apt-get install binutils`",
+	},
+	"objcopy": {
+		Name:        "objcopy",
+		VersionArgs: []string{"--version"},
+		Install:     "install binutils, such as with `apt-get install binutils`",
+	},
+	"upx": {
+		Name:        "upx",
+		MinVersion:  "3.94",
+		VersionArgs: []string{"--version"},
+		Install:     "download it from https://github.com/upx/upx/releases, or install it with `apt-get install upx-ucl`",
+	},
+}
+
+// ToolDir returns the absolute path of the project-local directory tools are
+// installed into, which is $CI_TOOL_DIR or DefaultToolDir.
+func ToolDir() string {
+	dir := os.Getenv("CI_TOOL_DIR")
+	if dir == "" {
+		dir = DefaultToolDir
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// ToolStatus describes the installed version of a tool.
+type ToolStatus struct {
+	Tool
+	// Path is the executable which was found, if any.
+	Path string `json:"path,omitempty"`
+	// Installed is the version of the executable at Path.
+	Installed string `json:"installed,omitempty"`
+	// OK is true if the executable at Path has the required version.
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// CheckTools reports whether each of the named tools (or all of Tools)
+// is installed with the required version. Nothing is installed.
+func CheckTools(names ...string) ([]ToolStatus, error) {
+	return CheckToolsContext(context.Background(), names...)
+}
+
+func CheckToolsContext(ctx context.Context, names ...string) ([]ToolStatus, error) {
+	if len(names) == 0 {
+		for name := range Tools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var statuses []ToolStatus
+	for _, name := range names {
+		t, ok := Tools[name]
+		if !ok {
+			return statuses, fmt.Errorf("unknown tool %q", name)
+		}
+		status := findTool(ctx, t)
+		if !status.OK {
+			status.Error = t.missing(status).Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// EnsureTool returns the path of the named tool, installing a Go tool into
+// ToolDir if the required version is not already installed there or on the PATH.
+// If the tool cannot be found or installed the error says how to install it.
+func EnsureTool(name string) (string, error) {
+	return EnsureToolContext(context.Background(), name)
+}
+
+func EnsureToolContext(ctx context.Context, name string) (string, error) {
+	t, ok := Tools[name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q", name)
+	}
+
+	status := findTool(ctx, t)
+	if status.OK {
+		return status.Path, nil
+	}
+
+	if isDryRun(ctx) {
+		// the dry run runner cannot report versions, so whatever was found is used
+		if status.Path != "" {
+			return status.Path, nil
+		}
+		logEvent(ctx, LevelInfo, StepTools, Fields{"tool": t.Name}, "dry run: not installing %s", t.requirement())
+		return t.Name, nil
+	}
+
+	if t.Package == "" {
+		return "", t.missing(status)
+	}
+
+	if err := installTool(ctx, t); err != nil {
+		return "", fmt.Errorf("could not install %s: %s; %s", t.requirement(), err, t.installHint())
+	}
+
+	status = findTool(ctx, t)
+	if !status.OK {
+		return "", t.missing(status)
+	}
+	return status.Path, nil
+}
+
+// findTool looks for t in ToolDir and then on the PATH, and returns the first
+// executable with the required version, or else the first which was found.
+func findTool(ctx context.Context, t Tool) ToolStatus {
+	var candidates []string
+	local := filepath.Join(ToolDir(), t.Name)
+	if runtime.GOOS == "windows" {
+		local += ".exe"
+	}
+	if info, err := os.Stat(local); err == nil && !info.IsDir() {
+		candidates = append(candidates, local)
+	}
+	if path, err := lookPath(ctx, t.Name); err == nil {
+		candidates = append(candidates, path)
+	}
+
+	status := ToolStatus{Tool: t}
+	for _, path := range candidates {
+		version, err := t.installedVersion(ctx, path)
+		// a tool without a required version is accepted even if its version is unknown
+		if (err == nil && t.satisfiedBy(version)) || (t.Version == "" && t.MinVersion == "") {
+			return ToolStatus{Tool: t, Path: path, Installed: version, OK: true}
+		}
+		if status.Path == "" {
+			status.Path, status.Installed = path, version
+		}
+	}
+	return status
+}
+
+// installedVersion returns the version of the executable at path. The module
+// version recorded in a Go binary is used, so that it does not need to be run.
+func (t Tool) installedVersion(ctx context.Context, path string) (string, error) {
+	if t.Package != "" {
+		info, err := buildinfo.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("could not read the module version of %s: %s", path, err)
+		}
+		if info.Main.Path == t.Module {
+			return info.Main.Version, nil
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == t.Module {
+				return dep.Version, nil
+			}
+		}
+		return "", fmt.Errorf("%s was not built from %s", path, t.Module)
+	}
+
+	if t.MinVersion == "" {
+		return "", nil
+	}
+
+	out, err := output(ctx, path, t.VersionArgs...)
+	if err != nil {
+		return "", fmt.Errorf("could not get the version of %s: %s", path, err)
+	}
+	version := toolVersionPattern.FindString(out)
+	if version == "" {
+		return "", fmt.Errorf("could not find a version in the output of %s %s", path, strings.Join(t.VersionArgs, " "))
+	}
+	return version, nil
+}
+
+var toolVersionPattern = regexp.MustCompile(`\d+\.\d+(\.\d+)?`)
+
+func (t Tool) satisfiedBy(version string) bool {
+	switch {
+	case t.Version != "":
+		return strings.TrimPrefix(version, "v") == strings.TrimPrefix(t.Version, "v")
+	case t.MinVersion != "":
+		return compareToolVersions(version, t.MinVersion) >= 0
+	}
+	return true
+}
+
+// compareToolVersions compares dotted numeric versions such as 3.96 and 4.2.1.
+func compareToolVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// requirement describes the tool and the version it requires, such as "ginkgo v1.16.5".
+func (t Tool) requirement() string {
+	switch {
+	case t.Version != "":
+		return t.Name + " " + t.Version
+	case t.MinVersion != "":
+		return t.Name + " " + t.MinVersion + " or later"
+	}
+	return t.Name
+}
+
+// installHint says how to install the tool by hand.
+func (t Tool) installHint() string {
+	if t.Package == "" {
+		return t.Install
+	}
+	version := t.Version
+	if version == "" {
+		version = "latest"
+	}
+	return fmt.Sprintf("install it with `GOBIN=%s go install %s@%s`", ToolDir(), t.Package, version)
+}
+
+// missing returns the error for a tool which was not found with the required version.
+func (t Tool) missing(status ToolStatus) error {
+	if status.Path == "" {
+		return fmt.Errorf("%s is not installed in %s or on the PATH: %s", t.requirement(), ToolDir(), t.installHint())
+	}
+	installed := status.Installed
+	if installed == "" {
+		installed = "an unknown version"
+	}
+	return fmt.Errorf("%s is required but %s is %s: %s", t.requirement(), status.Path, installed, t.installHint())
+}
+
+// installTool installs a Go tool into ToolDir, building it from the vendor
+// directory if the project vendors the required version of its module.
+func installTool(ctx context.Context, t Tool) error {
+	dir := ToolDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if t.Version != "" && vendoredModuleVersion(t.Module) == t.Version {
+		out := filepath.Join(dir, t.Name)
+		if runtime.GOOS == "windows" {
+			out += ".exe"
+		}
+		step := beginStep(ctx, StepTools, Fields{"tool": t.Name, "version": t.Version}, "building %s from the vendor directory", t.requirement())
+		ctx, cancel := stepContext(ctx, StepTools)
+		defer cancel()
+		return step.end(run(ctx, "go", "build", "-mod=vendor", "-o", out, t.Package))
+	}
+
+	version := t.Version
+	if version == "" {
+		version = "latest"
+	}
+	step := beginStep(ctx, StepTools, Fields{"tool": t.Name, "version": version}, "installing %s into %s", t.requirement(), dir)
+	ctx, cancel := stepContext(ctx, StepTools)
+	defer cancel()
+	return step.end(runWith(ctx, map[string]string{"GOBIN": dir}, "go", "install", t.Package+"@"+version))
+}
+
+// vendoredModuleVersion returns the version of module listed in
+// vendor/modules.txt, or an empty string if it is not vendored.
+func vendoredModuleVersion(module string) string {
+	f, err := os.Open(filepath.Join("vendor", "modules.txt"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// module lines look like "# github.com/onsi/ginkgo v1.16.5"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[0] == "#" && fields[1] == module {
+			return fields[2]
+		}
+	}
+	return ""
+}
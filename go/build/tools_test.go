@@ -0,0 +1,224 @@
+package build
+
+import (
+	"context"
+	"debug/buildinfo"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// installRunner records commands like a RecordingRunner, and installs a copy
+// of the test binary for go install, as a Go tool which was built from the
+// test's module.
+type installRunner struct {
+	RecordingRunner
+	t *testing.T
+}
+
+func (r *installRunner) Run(ctx context.Context, cmd Command) (string, error) {
+	out, err := r.RecordingRunner.Run(ctx, cmd)
+	if cmd.Name != "go" || len(cmd.Args) < 2 || cmd.Args[0] != "install" {
+		return out, err
+	}
+	name := filepath.Base(strings.SplitN(cmd.Args[1], "@", 2)[0])
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	data, readErr := ioutil.ReadFile(os.Args[0])
+	if readErr != nil {
+		r.t.Fatal(readErr)
+	}
+	if writeErr := ioutil.WriteFile(filepath.Join(cmd.Env["GOBIN"], name), data, 0755); writeErr != nil {
+		r.t.Fatal(writeErr)
+	}
+	return out, err
+}
+
+// withTestTools uses a temp dir as the ToolDir and replaces Tools with tools
+// until the returned func is called.
+func withTestTools(t *testing.T, tools ...Tool) (string, func()) {
+	dir, err := ioutil.TempDir("", "tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousDir, hadDir := os.LookupEnv("CI_TOOL_DIR")
+	os.Setenv("CI_TOOL_DIR", dir)
+
+	previous := Tools
+	Tools = map[string]Tool{}
+	for _, tool := range tools {
+		Tools[tool.Name] = tool
+	}
+
+	return dir, func() {
+		Tools = previous
+		if hadDir {
+			os.Setenv("CI_TOOL_DIR", previousDir)
+		} else {
+			os.Unsetenv("CI_TOOL_DIR")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCompareToolVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"3.94", "3.94", 0},
+		{"3.96", "3.94", 1},
+		{"3.9", "3.94", -1},
+		{"4.2.1", "3.94", 1},
+		{"v1.16.5", "1.16.5", 0},
+		{"1.16", "1.16.0", 0},
+		{"1.16.1", "1.16", 1},
+	}
+	for _, tt := range tests {
+		if got := compareToolVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareToolVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestToolSatisfiedBy(t *testing.T) {
+	tests := []struct {
+		tool    Tool
+		version string
+		want    bool
+	}{
+		{Tool{Version: "v1.16.5"}, "v1.16.5", true},
+		{Tool{Version: "v1.16.5"}, "1.16.5", true},
+		{Tool{Version: "v1.16.5"}, "v1.16.6", false},
+		{Tool{MinVersion: "3.94"}, "3.94", true},
+		{Tool{MinVersion: "3.94"}, "4.0", true},
+		{Tool{MinVersion: "3.94"}, "3.91", false},
+		{Tool{}, "", true},
+	}
+	for _, tt := range tests {
+		if got := tt.tool.satisfiedBy(tt.version); got != tt.want {
+			t.Errorf("%s satisfied by %q: got %t, want %t", tt.tool.requirement(), tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestCheckToolsMinVersion(t *testing.T) {
+	_, cleanup := withTestTools(t,
+		Tool{Name: "upx", MinVersion: "3.94", VersionArgs: []string{"--version"}, Install: "get upx"},
+		Tool{Name: "strip", VersionArgs: []string{"--version"}, Install: "get binutils"},
+	)
+	defer cleanup()
+
+	r := &RecordingRunner{}
+	r.Install("upx", "/opt/bin/upx")
+	r.Respond("/opt/bin/upx --version", "upx 3.91\nUCL data compression library 1.03\n", nil)
+	ctx := WithRunner(context.Background(), r)
+
+	statuses, err := CheckToolsContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("got %d statuses, want 2", len(statuses))
+	}
+
+	strip := statuses[0]
+	if strip.OK || strip.Path != "" {
+		t.Errorf("got strip %+v, want it missing", strip)
+	}
+	if want := "get binutils"; !strings.Contains(strip.Error, want) {
+		t.Errorf("got error %q, want it to say %q", strip.Error, want)
+	}
+
+	upx := statuses[1]
+	if upx.OK || upx.Path != "/opt/bin/upx" || upx.Installed != "3.91" {
+		t.Errorf("got upx %+v, want version 3.91 found and rejected", upx)
+	}
+	if want := "upx 3.94 or later is required but /opt/bin/upx is 3.91"; !strings.Contains(upx.Error, want) {
+		t.Errorf("got error %q, want it to say %q", upx.Error, want)
+	}
+
+	r.Respond("/opt/bin/upx --version", "upx 3.96\n", nil)
+	if path, err := EnsureToolContext(ctx, "upx"); err != nil || path != "/opt/bin/upx" {
+		t.Errorf("got %q, %v, want the upx on the PATH", path, err)
+	}
+
+	if _, err = CheckToolsContext(ctx, "nope"); err == nil || !strings.Contains(err.Error(), `unknown tool "nope"`) {
+		t.Errorf("got error %v, want the tool to be unknown", err)
+	}
+}
+
+func TestEnsureToolInstallsGoTool(t *testing.T) {
+	info, err := buildinfo.ReadFile(os.Args[0])
+	if err != nil {
+		t.Skipf("the test binary has no module version: %s", err)
+	}
+	tool := Tool{
+		Name:    "hello",
+		Package: info.Main.Path + "/cmd/hello",
+		Module:  info.Main.Path,
+		Version: info.Main.Version,
+	}
+	dir, cleanup := withTestTools(t, tool)
+	defer cleanup()
+
+	r := &installRunner{t: t}
+	ctx := WithRunner(context.Background(), r)
+
+	path, err := EnsureToolContext(ctx, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != dir {
+		t.Errorf("got %s, want the tool installed into %s", path, dir)
+	}
+	want := Command{Name: "go", Args: []string{"install", tool.Package + "@" + tool.Version}, Env: map[string]string{"GOBIN": dir}}
+	if cmds := r.Commands(); len(cmds) != 1 || !reflect.DeepEqual(cmds[0], want) {
+		t.Errorf("ran %q, want %s", r.Lines(), want)
+	}
+
+	// the installed version is found without installing it again
+	if _, err = EnsureToolContext(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Lines()) != 1 {
+		t.Errorf("ran %q, want the installed tool to be used", r.Lines())
+	}
+
+	// a different pinned version is installed over it, and still rejected
+	// because go install did not install the version which was asked for
+	tool.Version = "v9.9.9"
+	Tools["hello"] = tool
+	_, err = EnsureToolContext(ctx, "hello")
+	if err == nil || !strings.Contains(err.Error(), "hello v9.9.9 is required but "+path+" is "+info.Main.Version) {
+		t.Errorf("got error %v, want the wrong version reported", err)
+	}
+	cmds := r.Commands()
+	if args := cmds[len(cmds)-1].Args; !reflect.DeepEqual(args, []string{"install", tool.Package + "@v9.9.9"}) {
+		t.Errorf("ran go %q, want the pinned version installed", args)
+	}
+}
+
+func TestEnsureToolDryRun(t *testing.T) {
+	_, cleanup := withTestTools(t, Tool{Name: "hello", Package: "example.com/hello", Module: "example.com/hello", Version: "v1.0.0"})
+	defer cleanup()
+
+	r := &DryRunner{Out: ioutil.Discard}
+	path, err := EnsureToolContext(WithRunner(context.Background(), r), "hello")
+	if err != nil || path != "hello" {
+		t.Errorf("got %q, %v, want the tool's name without installing it", path, err)
+	}
+}
+
+func TestToolsArePinned(t *testing.T) {
+	for name, tool := range Tools {
+		if tool.Package != "" && tool.Version == "" {
+			t.Errorf("%s is installed from Go source without a pinned version", name)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// VersionVars names the variables which BuildPackage sets using -X linker flags.
+// Each field holds the fully qualified name of a string variable, such as
+// "github.com/naveegoinc/helloworld/version.Version". Empty fields are not set.
+type VersionVars struct {
+	Version     string
+	BuildNumber string
+	Commit      string
+	Branch      string
+	Dirty       string
+	BuildDate   string
+}
+
+// DefaultVersionVars returns the VersionVars for the version package
+// at {packagePath}/version.
+func DefaultVersionVars(packagePath string) VersionVars {
+	prefix := packagePath + "/version."
+	return VersionVars{
+		Version:     prefix + "Version",
+		BuildNumber: prefix + "BuildNumber",
+		Commit:      prefix + "Commit",
+		Branch:      prefix + "Branch",
+		Dirty:       prefix + "Dirty",
+		BuildDate:   prefix + "BuildDate",
+	}
+}
+
+// versionValues are the values given to the variables named by VersionVars.
+type versionValues struct {
+	Version     string
+	BuildNumber string
+	Commit      string
+	Branch      string
+	Dirty       string
+	BuildDate   string
+}
+
+// ldflags returns the -X flags which set the variables in vars to values.
+// Variables without a name or a value are not set.
+func (vars VersionVars) ldflags(values versionValues) []string {
+	var flags []string
+	for _, v := range []struct{ name, value string }{
+		{vars.Version, values.Version},
+		{vars.BuildNumber, values.BuildNumber},
+		{vars.Commit, values.Commit},
+		{vars.Branch, values.Branch},
+		{vars.Dirty, values.Dirty},
+		{vars.BuildDate, values.BuildDate},
+	} {
+		flags = append(flags, xflag(v.name, v.value)...)
+	}
+	return flags
+}
+
+// versionLdflags returns the -X flags which inject the version information
+// described by pkg.VersionVars, except for the build date which changes on
+// every build and is added by buildDateLdflags. Values which cannot be determined,
+// such as git information when building outside a repository, are left unset.
+func versionLdflags(ctx context.Context, pkg Package) []string {
+	vars := pkg.VersionVars
+	values := versionValues{
+		Version:     pkg.VersionString,
+		BuildNumber: os.Getenv("BUILD_NUMBER"),
+	}
+
+	if vars.Commit != "" {
+		if commit, err := GitHashContext(ctx); err == nil {
+			values.Commit = commit
+		} else {
+			logEvent(ctx, LevelWarn, StepGit, Fields{"error": err.Error()}, "could not determine git commit for %s", vars.Commit)
+		}
+	}
+
+	if vars.Branch != "" {
+		if branch, err := GitBranchContext(ctx); err == nil {
+			values.Branch = branch
+		} else {
+			logEvent(ctx, LevelWarn, StepGit, Fields{"error": err.Error()}, "could not determine git branch for %s", vars.Branch)
+		}
+	}
+
+	if vars.Dirty != "" {
+		if dirty, err := GitDirtyContext(ctx); err == nil {
+			values.Dirty = strconv.FormatBool(dirty)
+		} else {
+			logEvent(ctx, LevelWarn, StepGit, Fields{"error": err.Error()}, "could not determine git status for %s", vars.Dirty)
+		}
+	}
+
+	return vars.ldflags(values)
+}
+
+// buildDateLdflags returns the -X flags which set pkg.VersionVars.BuildDate to date.
+func buildDateLdflags(pkg Package, date time.Time) []string {
+	return xflag(pkg.VersionVars.BuildDate, date.UTC().Format(time.RFC3339))
+}
+
+// xflag returns the -X linker flag setting name to value,
+// or nothing if either is empty.
+func xflag(name, value string) []string {
+	if name == "" || value == "" {
+		return nil
+	}
+	flag := name + "=" + value
+	if strings.ContainsAny(value, " \t") {
+		flag = "'" + flag + "'"
+	}
+	return []string{"-X", flag}
+}
+
+// mergeLdflags removes any -ldflags from args and returns args with a single
+// -ldflags containing before, the flags the caller supplied, then after.
+// Later flags take precedence, so the caller's values override before.
+func mergeLdflags(args []string, before, after []string) []string {
+	if len(before) == 0 && len(after) == 0 {
+		return args
+	}
+
+	out, values := extractFlag(args, "ldflags")
+
+	var merged []string
+	merged = append(merged, before...)
+	merged = append(merged, values...)
+	merged = append(merged, after...)
+
+	return append(out, "-ldflags="+strings.Join(merged, " "))
+}
+
+// extractFlag removes every occurrence of the go build flag name from args,
+// in any of the forms -name value, --name value, -name=value or --name=value,
+// and returns the remaining args and the values which were removed.
+func extractFlag(args []string, name string) (out []string, values []string) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		switch {
+		case trimmed == name && trimmed != a:
+			if i+1 < len(args) {
+				i++
+				values = append(values, args[i])
+			}
+		case strings.HasPrefix(trimmed, name+"=") && trimmed != a:
+			values = append(values, trimmed[len(name)+1:])
+		default:
+			out = append(out, a)
+		}
+	}
+	return out, values
+}
+
+var versionPackageTemplate = template.Must(template.New("version").Parse(`// Code generated by github.com/naveego/ci/go/build. DO NOT EDIT.
+
+// Package version holds the version information of {{.Name}}.
+// The variables are set at build time by BuildPackage or goreleaser
+// using -X linker flags.
+package version
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	Version     = "0.0.0-dev"
+	BuildNumber = ""
+	Commit      = ""
+	Branch      = ""
+	Dirty       = ""
+	BuildDate   = ""
+)
+
+// Info is the typed form of the version variables.
+type Info struct {
+	Version     string    ` + "`" + `json:"version"` + "`" + `
+	BuildNumber string    ` + "`" + `json:"buildNumber,omitempty"` + "`" + `
+	Commit      string    ` + "`" + `json:"commit,omitempty"` + "`" + `
+	Branch      string    ` + "`" + `json:"branch,omitempty"` + "`" + `
+	Dirty       bool      ` + "`" + `json:"dirty"` + "`" + `
+	BuildDate   time.Time ` + "`" + `json:"buildDate,omitempty"` + "`" + `
+}
+
+// Get returns the version information compiled into the binary.
+func Get() Info {
+	info := Info{
+		Version:     Version,
+		BuildNumber: BuildNumber,
+		Commit:      Commit,
+		Branch:      Branch,
+	}
+	info.Dirty, _ = strconv.ParseBool(Dirty)
+	info.BuildDate, _ = time.Parse(time.RFC3339, BuildDate)
+	return info
+}
+
+// String returns a one line description of the version.
+func String() string {
+	info := Get()
+	s := info.Version
+	if info.BuildNumber != "" {
+		s += " build " + info.BuildNumber
+	}
+	if info.Commit != "" {
+		s += " (" + info.Commit
+		if info.Dirty {
+			s += "-dirty"
+		}
+		s += ")"
+	}
+	if !info.BuildDate.IsZero() {
+		s += " built " + info.BuildDate.Format(time.RFC3339)
+	}
+	return s
+}
+
+// Print writes the version information to w.
+func Print(w io.Writer) {
+	fmt.Fprintf(w, "{{.Name}} %s\n", String())
+}
+`))
+
+// GenerateVersionPackage writes a version package for pkg into dir, which
+// defaults to "./version". The variables it declares match the names in
+// DefaultVersionVars, which are set by BuildPackage and the goreleaser config.
+// The package path should therefore be {pkg.PackagePath}/version.
+func GenerateVersionPackage(pkg Package, dir string) error {
+	if dir == "" {
+		dir = "./version"
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create version package directory %q: %s", dir, err)
+	}
+
+	path := filepath.Join(dir, "version.go")
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create %q: %s", path, err)
+	}
+	defer f.Close()
+
+	if err = versionPackageTemplate.Execute(f, pkg); err != nil {
+		return fmt.Errorf("could not write %q: %s", path, err)
+	}
+
+	logEvent(context.Background(), LevelInfo, "", Fields{"path": path}, "generated version package in %s", dir)
+	return nil
+}
@@ -0,0 +1,111 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// TestGenerateVersionPackageCompiles builds a program using the generated
+// version package, setting its variables with -X linker flags.
+func TestGenerateVersionPackageCompiles(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkg := NewPackage("hello", *semver.New("1.2.3"))
+	pkg.PackagePath = "example.com/hello"
+	pkg.VersionVars = DefaultVersionVars(pkg.PackagePath)
+
+	if err = GenerateVersionPackage(pkg, filepath.Join(dir, "version")); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"go.mod":  "module example.com/hello\n\ngo 1.16\n",
+		"main.go": "package main\n\nimport (\n\t\"os\"\n\n\t\"example.com/hello/version\"\n)\n\nfunc main() { version.Print(os.Stdout) }\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ldflags := []string{
+		"-X", pkg.VersionVars.Version + "=" + pkg.VersionString,
+		"-X", pkg.VersionVars.BuildDate + "=" + time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Format(time.RFC3339),
+	}
+	cmd := exec.Command("go", "run", "-ldflags="+strings.Join(ldflags, " "), ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("generated version package does not build: %s\n%s", err, out)
+	}
+
+	want := "hello 1.2.3 built 2020-01-02T03:04:05Z\n"
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestExtractFlag(t *testing.T) {
+	tests := []struct {
+		args       []string
+		wantArgs   []string
+		wantValues []string
+	}{
+		{[]string{"-v"}, []string{"-v"}, nil},
+		{[]string{"-ldflags", "-s -w", "-v"}, []string{"-v"}, []string{"-s -w"}},
+		{[]string{"--ldflags", "-s"}, nil, []string{"-s"}},
+		{[]string{"-ldflags=-s", "-tags", "x", "--ldflags=-w"}, []string{"-tags", "x"}, []string{"-s", "-w"}},
+		// a trailing flag without a value is dropped
+		{[]string{"-v", "-ldflags"}, []string{"-v"}, nil},
+		// only whole flag names match
+		{[]string{"-ldflagsx", "ldflags"}, []string{"-ldflagsx", "ldflags"}, nil},
+	}
+
+	for _, tt := range tests {
+		args, values := extractFlag(tt.args, "ldflags")
+		if !reflect.DeepEqual(args, tt.wantArgs) || !reflect.DeepEqual(values, tt.wantValues) {
+			t.Errorf("extractFlag(%q) = %q, %q, want %q, %q", tt.args, args, values, tt.wantArgs, tt.wantValues)
+		}
+	}
+}
+
+func TestMergeLdflags(t *testing.T) {
+	tests := []struct {
+		args   []string
+		before []string
+		after  []string
+		want   []string
+	}{
+		{[]string{"-v"}, nil, nil, []string{"-v"}},
+		{[]string{"-v"}, []string{"-X", "a=1"}, nil, []string{"-v", "-ldflags=-X a=1"}},
+		{
+			// the caller's flags come after before, so they override it
+			args:   []string{"-ldflags", "-X a=2", "-v", "-ldflags=-s"},
+			before: []string{"-X", "a=1"},
+			after:  []string{"-X", "b=3"},
+			want:   []string{"-v", "-ldflags=-X a=1 -X a=2 -s -X b=3"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := mergeLdflags(tt.args, tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeLdflags(%q, %q, %q) = %q, want %q", tt.args, tt.before, tt.after, got, tt.want)
+		}
+	}
+}
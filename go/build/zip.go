@@ -7,12 +7,20 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // ZipFiles compresses one or many files into a single zip archive file.
 // Param 1: filename is the output zip file's name.
 // Param 2: files is a list of files to add to the zip.
 func ZipFiles(filename string, files []string) error {
+	return ZipFilesWithModTime(filename, files, time.Time{})
+}
+
+// ZipFilesWithModTime works like ZipFiles, but records modTime as the
+// modification time of every entry so that the archive does not depend on
+// when the files were written. If modTime is zero, the files' own times are used.
+func ZipFilesWithModTime(filename string, files []string, modTime time.Time) error {
 	newZipFile, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -50,6 +58,10 @@ func ZipFiles(filename string, files []string) error {
 		// see http://golang.org/pkg/archive/zip/#pkg-constants
 		header.Method = zip.Deflate
 
+		if !modTime.IsZero() {
+			header.Modified = modTime.UTC()
+		}
+
 		writer, err := zipWriter.CreateHeader(header)
 		if err != nil {
 			return err
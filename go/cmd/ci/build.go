@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/naveego/ci/go/build"
+)
+
+func init() {
+	commands["build"] = command{
+		usage: "[-config ci.yaml] [-package name] | -name name -version version [flags]",
+		help:  "build a package for its targets",
+		run:   runBuild,
+	}
+	commands["plugin"] = command{
+		usage: "[-config ci.yaml] [-package name] | -name name -version version [-file file...] [flags]",
+		help:  "build a plugin and zip it with its manifest",
+		run:   runPlugin,
+	}
+	commands["manifest"] = command{
+		usage: "[-version version] [manifest.json]",
+		help:  "check a plugin manifest, and that its version matches",
+		run:   runManifest,
+	}
+	commands["release"] = command{
+		usage: "[-config ci.yaml] | -name name -version version [flags] [-dir dist]",
+		help:  "release a package",
+		run:   runRelease,
+	}
+	commands["next-version"] = command{
+		usage: "[-tag] [-push] [-remote origin]",
+		help:  "work out the next version from conventional commits since the last tag, and optionally tag it",
+		run:   runNextVersion,
+	}
+	commands["changelog"] = command{
+		usage: "[-from ref] [-to ref] [-version v1.2.3] [-format markdown|html|json] [-issue-url url] [-issue-project key... | -issue-pattern regexp] [-o file]",
+		help:  "generate a changelog from the commits between two refs",
+		run:   runChangelog,
+	}
+	commands["init-release"] = command{
+		usage: "[-config ci.yaml] | -name name -version version [flags] [-o .goreleaser.yml] [-overwrite]",
+		help:  "write a goreleaser config matching the package, to customize and commit (-o - prints it)",
+		run:   runInitRelease,
+	}
+}
+
+// packageFlags selects a package either from the project config
+// or from flags describing it directly.
+type packageFlags struct {
+	config       string
+	pkgName      string
+	name         string
+	version      string
+	main         string
+	packagePath  string
+	outDir       string
+	dockerRepo   string
+	targets      stringList
+	buildArgs    stringList
+	cgo          bool
+	force        bool
+	reproducible bool
+	sign         bool
+	sbom         string
+	denyLicenses stringList
+	parallelism  int
+	// release selects the release targets from the project config.
+	release bool
+	// includeSBOM is set by -include-sbom or the plugin's project config.
+	includeSBOM bool
+	// includeNotices is set by -include-notices or the plugin's project config.
+	includeNotices bool
+	// icon is set by -icon-max-size, -resize-icon or the plugin's project config.
+	icon build.IconOptions
+}
+
+func (p *packageFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.config, "config", "", "project config file (default ci.yaml, ci.yml or ci.json)")
+	fs.StringVar(&p.pkgName, "package", "", "name of the package in the project config")
+	fs.StringVar(&p.name, "name", "", "package name, instead of using the project config")
+	fs.StringVar(&p.version, "version", "", `package version, or "git" to derive it from the latest git tag`)
+	fs.StringVar(&p.main, "main", "", "path to main.go or the build dir")
+	fs.StringVar(&p.packagePath, "package-path", "", "import path of the package")
+	fs.StringVar(&p.outDir, "out", "", "output directory")
+	fs.StringVar(&p.dockerRepo, "docker-repo", "", "docker repository")
+	fs.Var(&p.targets, "target", "target to build, such as linux/amd64 or linux/arm/v7 (repeatable)")
+	fs.Var(&p.buildArgs, "build-arg", "extra argument passed to go build (repeatable)")
+	fs.BoolVar(&p.cgo, "cgo", false, "enable CGO")
+	fs.BoolVar(&p.force, "force", false, "rebuild targets even if they are up to date")
+	fs.BoolVar(&p.reproducible, "reproducible", false, "build reproducibly")
+	fs.StringVar(&p.sbom, "sbom", "", "write an SBOM next to each output: cyclonedx or spdx")
+	fs.Var(&p.denyLicenses, "deny-license", "fail the build if a linked module has this SPDX license, such as GPL-3.0 (repeatable)")
+	fs.BoolVar(&p.sign, "sign", false, "sign the outputs with the key from $CI_SIGNING_KEY or $CI_SIGNING_KEY_FILE")
+	fs.IntVar(&p.parallelism, "parallelism", 0, "maximum number of targets built at once")
+}
+
+// resolve returns the package, its targets and its plugin files.
+func (p *packageFlags) resolve(ctx context.Context) (build.Package, []build.PackageTarget, []string, error) {
+	var (
+		pkg     build.Package
+		targets []build.PackageTarget
+		files   []string
+	)
+
+	if p.name != "" {
+		version, err := p.parseVersion(ctx)
+		if err != nil {
+			return pkg, nil, nil, err
+		}
+		pkg = build.NewPackage(p.name, version)
+		targets = build.DefaultPackageTargets
+		if p.release {
+			targets = build.DefaultReleaseTargets
+		}
+	} else {
+		cfg, err := build.LoadProjectConfig(p.config)
+		if err != nil {
+			return pkg, nil, nil, err
+		}
+
+		var pc build.PackageConfig
+		if p.pkgName != "" {
+			var ok bool
+			if pc, ok = cfg.FindPackage(p.pkgName); !ok {
+				return pkg, nil, nil, fmt.Errorf("package %q is not defined in the project config", p.pkgName)
+			}
+		} else if pc, err = cfg.ReleasePackage(); err != nil {
+			return pkg, nil, nil, fmt.Errorf("-package is required: %s", err)
+		}
+
+		pkg = cfg.ToPackage(pc)
+		targets = pc.ToTargets()
+		if p.release {
+			if targets, err = cfg.ReleaseTargets(); err != nil {
+				return pkg, nil, nil, err
+			}
+		}
+		if pc.Plugin != nil {
+			files = pc.Plugin.Files
+			p.includeSBOM = p.includeSBOM || pc.Plugin.IncludeSBOM
+			p.includeNotices = p.includeNotices || pc.Plugin.IncludeNotices
+			icon := pc.Plugin.Icon.ToIconOptions()
+			if p.icon.MaxSize == 0 {
+				p.icon.MaxSize = icon.MaxSize
+			}
+			p.icon.MaxBytes = icon.MaxBytes
+			p.icon.Resize = p.icon.Resize || icon.Resize
+		}
+	}
+
+	if p.version != "" && p.name == "" {
+		version, err := p.parseVersion(ctx)
+		if err != nil {
+			return pkg, nil, nil, err
+		}
+		pkg.Version = version
+		pkg.VersionString = version.String()
+	}
+	if p.main != "" {
+		pkg.Main = p.main
+	}
+	if p.packagePath != "" {
+		pkg.PackagePath = p.packagePath
+		pkg.VersionVars = build.DefaultVersionVars(p.packagePath)
+	}
+	if p.outDir != "" {
+		pkg.OutDir = p.outDir
+	}
+	if p.dockerRepo != "" {
+		pkg.DockerRepo = p.dockerRepo
+	}
+	if len(p.buildArgs) > 0 {
+		pkg.BuildArgs = append(pkg.BuildArgs, p.buildArgs...)
+	}
+	if p.cgo {
+		pkg.CGOEnabled = true
+	}
+	if p.parallelism > 0 {
+		pkg.Parallelism = p.parallelism
+	}
+	pkg.Force = pkg.Force || p.force
+	pkg.Reproducible = pkg.Reproducible || p.reproducible
+	pkg.Sign = pkg.Sign || p.sign
+	if p.sbom != "" {
+		pkg.SBOM = p.sbom
+	}
+	if len(p.denyLicenses) > 0 {
+		licenses := build.LicenseOptions{}
+		if pkg.Licenses != nil {
+			licenses = *pkg.Licenses
+		}
+		licenses.Deny = append(licenses.Deny, p.denyLicenses...)
+		pkg.Licenses = &licenses
+	}
+
+	if len(p.targets) > 0 {
+		var err error
+		if targets, err = build.ParseTargets(p.targets...); err != nil {
+			return pkg, nil, nil, err
+		}
+	}
+
+	return pkg, targets, files, nil
+}
+
+// parseVersion parses -version, deriving it from git if it is "git".
+func (p *packageFlags) parseVersion(ctx context.Context) (semver.Version, error) {
+	if p.version == "git" {
+		gv, err := build.VersionFromGitContext(ctx)
+		if err != nil {
+			return semver.Version{}, fmt.Errorf("could not derive version from git: %s", err)
+		}
+		return gv.Version, nil
+	}
+
+	version, err := semver.NewVersion(strings.TrimPrefix(p.version, "v"))
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("invalid -version %q: %s", p.version, err)
+	}
+	return *version, nil
+}
+
+func runBuild(ctx context.Context, args []string) (interface{}, error) {
+	var p packageFlags
+	fs := newFlagSet("build")
+	p.register(fs)
+	fs.Parse(args)
+
+	pkg, targets, _, err := p.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts, err := build.BuildPackagesContext(ctx, pkg, targets...)
+	return artifacts, err
+}
+
+func runPlugin(ctx context.Context, args []string) (interface{}, error) {
+	var (
+		p     packageFlags
+		files stringList
+	)
+	fs := newFlagSet("plugin")
+	p.register(fs)
+	fs.Var(&files, "file", "extra file to include in package.zip (repeatable)")
+	fs.BoolVar(&p.includeSBOM, "include-sbom", false, "include the SBOM of each target in package.zip")
+	fs.BoolVar(&p.includeNotices, "include-notices", false, "include the third party notices of each target in package.zip")
+	fs.IntVar(&p.icon.MaxSize, "icon-max-size", 0, fmt.Sprintf("largest width and height of the icon in pixels (default %d)", build.DefaultIconMaxSize))
+	fs.BoolVar(&p.icon.Resize, "resize-icon", false, "scale a larger icon down to -icon-max-size instead of failing")
+	fs.Parse(args)
+
+	pkg, targets, configFiles, err := p.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := build.PluginConfig{
+		Package:        pkg,
+		Targets:        targets,
+		Files:          append(configFiles, files...),
+		IncludeSBOM:    p.includeSBOM,
+		IncludeNotices: p.includeNotices,
+		Icon:           p.icon,
+	}
+
+	return nil, build.BuildPluginContext(ctx, cfg)
+}
+
+func runManifest(ctx context.Context, args []string) (interface{}, error) {
+	var p packageFlags
+	fs := newFlagSet("manifest")
+	fs.StringVar(&p.version, "version", "", `version the manifest must have, or "git" to derive it from the latest git tag`)
+	fs.Parse(args)
+
+	path := build.PluginManifestFile
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	var version string
+	if p.version != "" {
+		v, err := p.parseVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		version = v.String()
+	}
+
+	manifest, err := build.ReadPluginManifest(path, version)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func runRelease(ctx context.Context, args []string) (interface{}, error) {
+	p := packageFlags{release: true}
+	fs := newFlagSet("release")
+	p.register(fs)
+	dir := fs.String("dir", "", "directory to write the release to (default release.dir in the project config, or "+build.DefaultReleaseDir+")")
+	fs.Parse(args)
+
+	pkg, targets, _, err := p.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if *dir != "" {
+		pkg.Archive.Dir = *dir
+	}
+
+	return nil, build.ReleaseContext(ctx, pkg, targets...)
+}
+
+func runInitRelease(ctx context.Context, args []string) (interface{}, error) {
+	p := packageFlags{release: true}
+	fs := newFlagSet("init-release")
+	p.register(fs)
+	out := fs.String("o", build.DefaultReleaserConfigFile, "file to write the config to, or - to print it")
+	overwrite := fs.Bool("overwrite", false, "replace the file if it already exists")
+	fs.Parse(args)
+
+	pkg, targets, _, err := p.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if *out == "-" {
+		return nil, build.GenerateReleaserConfig(os.Stdout, pkg, targets...)
+	}
+	return build.InitReleaserConfigContext(ctx, *out, pkg, *overwrite, targets...)
+}
+
+type nextVersionResult struct {
+	build.NextVersionResult
+	// Tagged is the tag which was created or found, if -tag was given.
+	Tagged string `json:"tagged,omitempty"`
+}
+
+func runNextVersion(ctx context.Context, args []string) (interface{}, error) {
+	fs := newFlagSet("next-version")
+	tag := fs.Bool("tag", false, "create an annotated tag for the next version on HEAD")
+	push := fs.Bool("push", false, "push the tag (implies -tag)")
+	remote := fs.String("remote", "origin", "remote to push the tag to")
+	fs.Parse(args)
+
+	next, err := build.NextVersionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := nextVersionResult{NextVersionResult: next}
+
+	if !*tag && !*push {
+		return result, nil
+	}
+	if next.Bump == build.BumpNone {
+		return result, fmt.Errorf("no commits since the last release call for a new one")
+	}
+
+	pushTo := ""
+	if *push {
+		pushTo = *remote
+	}
+	result.Tagged, err = build.TagReleaseContext(ctx, next.Next, pushTo)
+	return result, err
+}
+
+func runChangelog(ctx context.Context, args []string) (interface{}, error) {
+	var opts build.ChangelogOptions
+	var projects stringList
+	fs := newFlagSet("changelog")
+	fs.StringVar(&opts.From, "from", "", "ref the changelog starts after (default the previous release tag)")
+	fs.StringVar(&opts.To, "to", "HEAD", "ref the changelog ends at")
+	fs.StringVar(&opts.Version, "version", "", "title of the changelog (default -to)")
+	format := fs.String("format", build.ChangelogMarkdown, "output format: markdown, html or json")
+	issueURL := fs.String("issue-url", "", "prefix for issue links (default $JIRA_URL/browse/)")
+	fs.Var(&projects, "issue-project", "project key of the issues to collect, such as PROJ (repeatable, default $JIRA_PROJECTS)")
+	issuePattern := fs.String("issue-pattern", "", "regular expression matching issue keys (default JIRA style keys, except names such as SHA-256)")
+	out := fs.String("o", "", "file to write the changelog to instead of printing it")
+	fs.Parse(args)
+
+	var err error
+	if *issuePattern != "" {
+		if opts.IssuePattern, err = regexp.Compile(*issuePattern); err != nil {
+			return nil, fmt.Errorf("invalid -issue-pattern: %s", err)
+		}
+	}
+	opts.IssueProjects = projects
+	if len(opts.IssueProjects) == 0 && opts.IssuePattern == nil && os.Getenv("JIRA_PROJECTS") != "" {
+		opts.IssueProjects = strings.Split(os.Getenv("JIRA_PROJECTS"), ",")
+	}
+
+	opts.IssueURL = *issueURL
+	if opts.IssueURL == "" {
+		if jiraURL := os.Getenv("JIRA_URL"); jiraURL != "" {
+			opts.IssueURL = strings.TrimSuffix(jiraURL, "/") + "/browse/"
+		}
+	}
+
+	changelog, err := build.GenerateChangelogContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if jsonOutput && *out == "" {
+		return changelog, nil
+	}
+
+	text, err := changelog.Render(*format)
+	if err != nil {
+		return nil, err
+	}
+	if *out != "" {
+		return *out, ioutil.WriteFile(*out, []byte(text), 0644)
+	}
+	return text, nil
+}
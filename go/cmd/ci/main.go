@@ -0,0 +1,164 @@
+// Command ci exposes the helpers in github.com/naveego/ci/go/build as a
+// command line tool, so that CI steps can use them without a magefile.
+//
+// Usage:
+//
+//	ci [-json] [-dry-run] [-log text|json|teamcity] <command> [flags] [args]
+//
+// Run "ci help" for the list of commands.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/naveego/ci/go/build"
+)
+
+// command is a subcommand of ci. Run parses args with the command's flags
+// and returns a result which is printed as JSON when -json is set. The context
+// is cancelled by an interrupt or SIGTERM, which stops the running step.
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, args []string) (interface{}, error)
+}
+
+var commands = map[string]command{}
+
+var (
+	jsonOutput bool
+	dryRun     bool
+	logFormat  string
+)
+
+func main() {
+	flag.BoolVar(&jsonOutput, "json", false, "print results as JSON")
+	flag.BoolVar(&dryRun, "dry-run", false, "print the commands which would be run instead of running them")
+	flag.StringVar(&logFormat, "log", "", "log format: text, json or teamcity (default $CI_LOG_FORMAT, teamcity on TeamCity, otherwise text)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if logFormat != "" {
+		out := os.Stderr
+		if logFormat == build.LogFormatTeamCity {
+			out = os.Stdout
+		}
+		logger, err := build.NewLogger(logFormat, out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ci: %s\n", err)
+			os.Exit(2)
+		}
+		build.SetLogger(logger)
+	}
+
+	if dryRun {
+		build.SetRunner(&build.DryRunner{Out: os.Stderr})
+	}
+
+	args := flag.Args()
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ci: unknown command %q\n\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	result, err := cmd.run(ctx, args[1:])
+	stop()
+	if err != nil {
+		if jsonOutput {
+			printJSON(map[string]interface{}{"error": err.Error(), "result": result})
+		} else {
+			fmt.Fprintf(os.Stderr, "ci %s: %s\n", args[0], err)
+		}
+		os.Exit(1)
+	}
+
+	printResult(result)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: ci [-json] [-dry-run] [-log format] <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].help)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run \"ci <command> -h\" for the flags of a command.")
+}
+
+// newFlagSet returns a FlagSet for the command which prints the command's usage.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: ci %s %s\n\n%s\n\n", name, commands[name].usage, commands[name].help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func printResult(result interface{}) {
+	if result == nil {
+		return
+	}
+	if jsonOutput {
+		printJSON(result)
+		return
+	}
+	switch r := result.(type) {
+	case string:
+		fmt.Println(r)
+	case []string:
+		fmt.Println(strings.Join(r, "\n"))
+	case fmt.Stringer:
+		fmt.Println(r.String())
+	default:
+		printJSON(r)
+	}
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "ci: could not encode result: %s\n", err)
+	}
+}
+
+// stringList is a flag which may be repeated or given a comma separated list.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
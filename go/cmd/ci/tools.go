@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/naveego/ci/go/build"
+)
+
+func init() {
+	commands["docker"] = command{
+		usage: "-source image -image name -build number -major n -minor n [-prefix prefix]",
+		help:  "tag a docker image with its version tags and push them",
+		run:   runDocker,
+	}
+	commands["s3"] = command{
+		usage: "-bucket bucket [-key key | -service-id id -version version] file",
+		help:  "upload a file to S3",
+		run:   runS3,
+	}
+	commands["zip"] = command{
+		usage: "-o archive.zip file...",
+		help:  "zip files into an archive",
+		run:   runZip,
+	}
+	commands["unzip"] = command{
+		usage: "[-d dir] archive.zip",
+		help:  "extract a zip archive",
+		run:   runUnzip,
+	}
+	commands["test"] = command{
+		usage: "[-tags tag...] | -integration -name name -compose docker-compose.yml",
+		help:  "run unit tests, or integration tests in docker",
+		run:   runTest,
+	}
+	commands["version"] = command{
+		usage: "",
+		help:  "print the version derived from the latest git tag",
+		run:   runVersion,
+	}
+	commands["git"] = command{
+		usage: "",
+		help:  "print the git branch, commit and state",
+		run:   runGit,
+	}
+	commands["tools"] = command{
+		usage: "[-install] [tool...]",
+		help:  "check the pinned versions of the tools this package uses, optionally installing them",
+		run:   runTools,
+	}
+	commands["sign"] = command{
+		usage: "[-key-file file] [-dir dir] file...",
+		help:  "write SHA256SUMS and detached ed25519 signatures of files",
+		run:   runSign,
+	}
+	commands["verify"] = command{
+		usage: "[-key key | -key-file file] [-sums SHA256SUMS] [file...]",
+		help:  "check files against a signed SHA256SUMS and their signatures",
+		run:   runVerify,
+	}
+	commands["licenses"] = command{
+		usage: "[-deny id...] [-exception module...] [-o THIRD_PARTY_NOTICES] binary",
+		help:  "list the licenses of the modules linked into a Go binary, failing if one is denied",
+		run:   runLicenses,
+	}
+}
+
+func runDocker(ctx context.Context, args []string) (interface{}, error) {
+	fs := newFlagSet("docker")
+	source := fs.String("source", "", "existing image to tag")
+	image := fs.String("image", os.Getenv("IMAGE_NAME"), "name of the image to push (default $IMAGE_NAME)")
+	prefix := fs.String("prefix", os.Getenv("IMAGE_TAG_PREFIX"), "prefix for every tag (default $IMAGE_TAG_PREFIX)")
+	buildNumber := fs.String("build", os.Getenv("BUILD_NUMBER"), "build number (default $BUILD_NUMBER)")
+	major := fs.String("major", os.Getenv("MAJOR_VERSION"), "major version (default $MAJOR_VERSION)")
+	minor := fs.String("minor", os.Getenv("MINOR_VERSION"), "minor version (default $MINOR_VERSION)")
+	fs.Parse(args)
+
+	if *source == "" || *image == "" {
+		return nil, fmt.Errorf("-source and -image are required")
+	}
+
+	return build.TagAndPushDockerImagesContext(ctx, *source, *image, *prefix, *buildNumber, *major, *minor)
+}
+
+type s3Result struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+func (r s3Result) String() string {
+	return fmt.Sprintf("s3://%s/%s", r.Bucket, r.Key)
+}
+
+func runS3(ctx context.Context, args []string) (interface{}, error) {
+	fs := newFlagSet("s3")
+	bucket := fs.String("bucket", "", "bucket to upload to")
+	key := fs.String("key", "", "key to upload to")
+	serviceID := fs.String("service-id", "", "service ID used to build a release path instead of -key")
+	version := fs.String("version", "", "version used to build a release path instead of -key")
+	fs.Parse(args)
+
+	if *bucket == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return nil, fmt.Errorf("-bucket and a file are required")
+	}
+	src := fs.Arg(0)
+
+	if *key == "" {
+		if *serviceID == "" || *version == "" {
+			return nil, fmt.Errorf("either -key or -service-id and -version are required")
+		}
+		v, err := semver.NewVersion(strings.TrimPrefix(*version, "v"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid -version %q: %s", *version, err)
+		}
+		*key = build.ToS3ReleasePath(filepath.Base(src), *serviceID, *v)
+	}
+
+	_, err := build.UploadToS3Context(ctx, *bucket, src, *key)
+	return s3Result{Bucket: *bucket, Key: *key}, err
+}
+
+func runZip(ctx context.Context, args []string) (interface{}, error) {
+	fs := newFlagSet("zip")
+	out := fs.String("o", "", "archive to create")
+	fs.Parse(args)
+
+	if *out == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return nil, fmt.Errorf("-o and at least one file are required")
+	}
+
+	return *out, build.ZipFiles(*out, fs.Args())
+}
+
+func runUnzip(ctx context.Context, args []string) (interface{}, error) {
+	fs := newFlagSet("unzip")
+	dest := fs.String("d", ".", "directory to extract into")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return nil, fmt.Errorf("an archive is required")
+	}
+
+	return build.Unzip(fs.Arg(0), *dest)
+}
+
+func runTest(ctx context.Context, args []string) (interface{}, error) {
+	var tags stringList
+	fs := newFlagSet("test")
+	fs.Var(&tags, "tags", "build tags for unit tests (repeatable)")
+	integration := fs.Bool("integration", false, "run integration tests using docker-compose")
+	name := fs.String("name", "", "docker-compose project name for integration tests")
+	compose := fs.String("compose", "docker-compose.yml", "docker-compose file for integration tests")
+	fs.Parse(args)
+
+	if *integration {
+		if *name == "" {
+			return nil, fmt.Errorf("-name is required for integration tests")
+		}
+		return nil, build.RunIntegrationTestsInDockerContext(ctx, *name, *compose)
+	}
+
+	return nil, build.RunUnitTestsContext(ctx, tags)
+}
+
+type gitInfo struct {
+	Branch        string `json:"branch"`
+	Commit        string `json:"commit"`
+	ShortCommit   string `json:"shortCommit"`
+	Dirty         bool   `json:"dirty"`
+	ReleaseBranch bool   `json:"releaseBranch"`
+	MasterBranch  bool   `json:"masterBranch"`
+}
+
+func (g gitInfo) String() string {
+	return fmt.Sprintf("branch:  %s\ncommit:  %s\nshort:   %s\ndirty:   %t\nrelease: %t\nmaster:  %t",
+		g.Branch, g.Commit, g.ShortCommit, g.Dirty, g.ReleaseBranch, g.MasterBranch)
+}
+
+func runGit(ctx context.Context, args []string) (interface{}, error) {
+	fs := newFlagSet("git")
+	fs.Parse(args)
+
+	var (
+		info gitInfo
+		err  error
+	)
+
+	if info.Branch, err = build.GitBranchContext(ctx); err != nil {
+		return nil, err
+	}
+	if info.Commit, err = build.GitHashContext(ctx); err != nil {
+		return nil, err
+	}
+	if info.ShortCommit, err = build.GitShortHashContext(ctx); err != nil {
+		return nil, err
+	}
+	if info.Dirty, err = build.GitDirtyContext(ctx); err != nil {
+		return nil, err
+	}
+	info.ReleaseBranch = build.OnReleaseBranchContext(ctx)
+	info.MasterBranch = build.OnMasterBranchContext(ctx)
+
+	return info, nil
+}
+
+func runVersion(ctx context.Context, args []string) (interface{}, error) {
+	fs := newFlagSet("version")
+	fs.Parse(args)
+
+	return build.VersionFromGitContext(ctx)
+}
+
+type toolsResult []build.ToolStatus
+
+func (r toolsResult) String() string {
+	var lines []string
+	for _, t := range r {
+		switch {
+		case t.OK && t.Installed != "":
+			lines = append(lines, fmt.Sprintf("%-12s %s (%s)", t.Name, t.Path, t.Installed))
+		case t.OK:
+			lines = append(lines, fmt.Sprintf("%-12s %s", t.Name, t.Path))
+		default:
+			lines = append(lines, fmt.Sprintf("%-12s %s", t.Name, t.Error))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func runTools(ctx context.Context, args []string) (interface{}, error) {
+	fs := newFlagSet("tools")
+	install := fs.Bool("install", false, "install missing Go tools into $CI_TOOL_DIR (default "+build.DefaultToolDir+")")
+	fs.Parse(args)
+
+	if *install {
+		names := fs.Args()
+		if len(names) == 0 {
+			for name, t := range build.Tools {
+				if t.Package != "" {
+					names = append(names, name)
+				}
+			}
+		}
+		for _, name := range names {
+			if _, err := build.EnsureToolContext(ctx, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	statuses, err := build.CheckToolsContext(ctx, fs.Args()...)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range statuses {
+		if !s.OK {
+			return toolsResult(statuses), fmt.Errorf("%s", s.Error)
+		}
+	}
+	return toolsResult(statuses), nil
+}
+
+func runSign(ctx context.Context, args []string) (interface{}, error) {
+	var opts build.SignOptions
+	fs := newFlagSet("sign")
+	fs.StringVar(&opts.KeyFile, "key-file", "", "file containing the private key (default $CI_SIGNING_KEY or $CI_SIGNING_KEY_FILE)")
+	fs.StringVar(&opts.Dir, "dir", "", "directory to write SHA256SUMS to (default the directory containing the files)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return nil, fmt.Errorf("at least one file is required")
+	}
+
+	return build.SignArtifactsContext(ctx, opts, fs.Args()...)
+}
+
+func runVerify(ctx context.Context, args []string) (interface{}, error) {
+	var opts build.VerifyOptions
+	fs := newFlagSet("verify")
+	fs.StringVar(&opts.PublicKey, "key", "", "public key, PEM or base64 (default $CI_VERIFY_KEY)")
+	fs.StringVar(&opts.PublicKeyFile, "key-file", "", "file containing the public key (default $CI_VERIFY_KEY_FILE)")
+	fs.StringVar(&opts.SumsFile, "sums", "", "checksums file (default SHA256SUMS next to the first file)")
+	fs.Parse(args)
+
+	return build.VerifyArtifacts(opts, fs.Args()...)
+}
+
+func runLicenses(ctx context.Context, args []string) (interface{}, error) {
+	var opts build.LicenseOptions
+	var deny, exceptions stringList
+	fs := newFlagSet("licenses")
+	fs.Var(&deny, "deny", "SPDX license ID or pattern which may not be linked, such as GPL-* (repeatable)")
+	fs.Var(&exceptions, "exception", "module allowed whatever its license (repeatable)")
+	out := fs.String("o", "", "write the third party notices to this file")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return nil, fmt.Errorf("a binary is required")
+	}
+	opts.Deny, opts.Exceptions = deny, exceptions
+
+	report, err := build.CollectLicensesContext(ctx, fs.Arg(0), opts)
+	if _, denied := err.(build.DeniedError); err != nil && !denied {
+		return nil, err
+	}
+	if *out != "" {
+		if writeErr := report.WriteNotices(*out); writeErr != nil {
+			return report, writeErr
+		}
+	}
+	return report, err
+}
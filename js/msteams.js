@@ -39,7 +39,7 @@ function notify(err) {
 
       if (jiraURL) {
         var issueIDExp = /([A-Z]{2,5}-[0-9]{0,5})/g;
-        var matches = _.uniq(issueIDExp.exec(stdout));
+        var matches = _.uniq(stdout.match(issueIDExp) || []);
 
         message =
           "<div><p>The following JIRA issues are related to this deployment:</p>";